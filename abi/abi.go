@@ -0,0 +1,164 @@
+// Package abi 定义了合约的接口描述格式以及调用数据的编解码规则。
+//
+// 函数和事件都由签名 "name(type1,type2,...)" 标识：
+// 函数选择器是签名 SHA-256 的前 4 字节，事件的 topic 同样取签名 SHA-256 的前 4 字节。
+// 调用数据为 选择器 || 参数编码，返回值与事件数据只包含参数编码。
+//
+// 参数依次编码后直接拼接：
+//
+//	int, bool  8 字节小端序（bool 为 0 或 1）
+//	address    20 字节
+//	bytes      8 字节小端序长度 || 内容
+package abi
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// 支持的参数类型
+const (
+	TypeInt     = "int"
+	TypeBool    = "bool"
+	TypeBytes   = "bytes"
+	TypeAddress = "address"
+)
+
+// Argument 描述一个参数或返回值
+type Argument struct {
+	Name string `json:"name,omitempty"`
+	Type string `json:"type"`
+}
+
+// Function 描述一个可以从外部调用的合约函数
+type Function struct {
+	Name    string     `json:"name"`
+	Inputs  []Argument `json:"inputs"`
+	Outputs []Argument `json:"outputs"`
+}
+
+// Event 描述一个合约事件
+type Event struct {
+	Name   string     `json:"name"`
+	Inputs []Argument `json:"inputs"`
+}
+
+// ABI 是一个合约对外暴露的全部函数和事件
+type ABI struct {
+	Functions []Function `json:"functions"`
+	Events    []Event    `json:"events"`
+}
+
+// Parse 解析 JSON 格式的 ABI 并校验其中的类型
+func Parse(data []byte) (*ABI, error) {
+	a := new(ABI)
+	if err := json.Unmarshal(data, a); err != nil {
+		return nil, err
+	}
+	for _, f := range a.Functions {
+		if err := checkTypes(f.Inputs, f.Outputs); err != nil {
+			return nil, fmt.Errorf("function %s: %w", f.Name, err)
+		}
+	}
+	for _, e := range a.Events {
+		if err := checkTypes(e.Inputs); err != nil {
+			return nil, fmt.Errorf("event %s: %w", e.Name, err)
+		}
+	}
+	return a, nil
+}
+
+func checkTypes(lists ...[]Argument) error {
+	for _, args := range lists {
+		for _, arg := range args {
+			switch arg.Type {
+			case TypeInt, TypeBool, TypeBytes, TypeAddress:
+			default:
+				return fmt.Errorf("unsupported type %q", arg.Type)
+			}
+		}
+	}
+	return nil
+}
+
+func signature(name string, args []Argument) string {
+	types := make([]string, len(args))
+	for i, arg := range args {
+		types[i] = arg.Type
+	}
+	return fmt.Sprintf("%s(%s)", name, strings.Join(types, ","))
+}
+
+// Selector 返回签名 SHA-256 的前 4 字节
+func Selector(signature string) []byte {
+	h := sha256.Sum256([]byte(signature))
+	return h[:4]
+}
+
+// Signature 返回函数签名，例如 "transfer(address,int)"
+func (f *Function) Signature() string {
+	return signature(f.Name, f.Inputs)
+}
+
+// Selector 返回函数选择器
+func (f *Function) Selector() []byte {
+	return Selector(f.Signature())
+}
+
+// Signature 返回事件签名
+func (e *Event) Signature() string {
+	return signature(e.Name, e.Inputs)
+}
+
+// Topic 返回事件在日志中的 topic
+func (e *Event) Topic() []byte {
+	return Selector(e.Signature())
+}
+
+// Function 按名字查找函数
+func (a *ABI) Function(name string) (*Function, error) {
+	for i := range a.Functions {
+		if a.Functions[i].Name == name {
+			return &a.Functions[i], nil
+		}
+	}
+	return nil, fmt.Errorf("function %q not found in abi", name)
+}
+
+// EncodeCall 编码对函数 name 的调用数据
+func (a *ABI) EncodeCall(name string, args ...any) ([]byte, error) {
+	f, err := a.Function(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) != len(f.Inputs) {
+		return nil, fmt.Errorf("function %s expects %d arguments, got %d", name, len(f.Inputs), len(args))
+	}
+	data, err := Encode(f.Inputs, args)
+	if err != nil {
+		return nil, fmt.Errorf("function %s: %w", name, err)
+	}
+	return append(f.Selector(), data...), nil
+}
+
+// DecodeOutputs 解码函数的返回数据
+func (f *Function) DecodeOutputs(data []byte) ([]any, error) {
+	return Decode(f.Outputs, data)
+}
+
+// DecodeLog 根据 topic 找到对应的事件并解码其数据
+func (a *ABI) DecodeLog(topic, data []byte) (*Event, []any, error) {
+	for i := range a.Events {
+		e := &a.Events[i]
+		if string(e.Topic()) == string(topic) {
+			values, err := Decode(e.Inputs, data)
+			if err != nil {
+				return nil, nil, fmt.Errorf("event %s: %w", e.Name, err)
+			}
+			return e, values, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("no event with topic %x in abi", topic)
+}
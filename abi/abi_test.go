@@ -0,0 +1,62 @@
+package abi
+
+import (
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"github.com/virtue186/xchain/types"
+	"testing"
+)
+
+const testABI = `{
+	"functions": [
+		{"name": "transfer", "inputs": [{"name": "to", "type": "address"}, {"name": "amount", "type": "int"}], "outputs": [{"type": "bool"}]}
+	],
+	"events": [
+		{"name": "Memo", "inputs": [{"name": "ok", "type": "bool"}, {"name": "text", "type": "bytes"}]}
+	]
+}`
+
+func TestEncodeCall(t *testing.T) {
+	a, err := Parse([]byte(testABI))
+	assert.Nil(t, err)
+
+	to := types.Address{1, 2, 3}
+	data, err := a.EncodeCall("transfer", to, int64(-5))
+	assert.Nil(t, err)
+	assert.Equal(t, Selector("transfer(address,int)"), data[:4])
+	assert.Equal(t, to.ToSlice(), data[4:24])
+	assert.Equal(t, int64(-5), int64(binary.LittleEndian.Uint64(data[24:])))
+
+	_, err = a.EncodeCall("transfer", to)
+	assert.NotNil(t, err)
+	_, err = a.EncodeCall("transfer", to, "5")
+	assert.NotNil(t, err)
+
+	fn, _ := a.Function("transfer")
+	out, err := fn.DecodeOutputs([]byte{1, 0, 0, 0, 0, 0, 0, 0})
+	assert.Nil(t, err)
+	assert.Equal(t, []any{true}, out)
+}
+
+func TestDecodeLog(t *testing.T) {
+	a, err := Parse([]byte(testABI))
+	assert.Nil(t, err)
+
+	args := a.Events[0].Inputs
+	data, err := Encode(args, []any{true, []byte("hello")})
+	assert.Nil(t, err)
+
+	event, values, err := a.DecodeLog(a.Events[0].Topic(), data)
+	assert.Nil(t, err)
+	assert.Equal(t, "Memo", event.Name)
+	assert.Equal(t, []any{true, []byte("hello")}, values)
+
+	// 多余或缺失的字节都应报错
+	_, err = Decode(args, append(data, 0))
+	assert.NotNil(t, err)
+	_, err = Decode(args, data[:len(data)-1])
+	assert.NotNil(t, err)
+
+	_, err = Parse([]byte(`{"functions": [{"name": "f", "inputs": [{"type": "uint"}]}]}`))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,144 @@
+package abi
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"github.com/virtue186/xchain/types"
+	"strconv"
+	"strings"
+)
+
+// Encode 按 args 描述的类型依次编码 values。
+// Go 中对应的类型为：int 用 int64，bool 用 bool，bytes 用 []byte，address 用 types.Address
+func Encode(args []Argument, values []any) ([]byte, error) {
+	if len(args) != len(values) {
+		return nil, fmt.Errorf("expected %d values, got %d", len(args), len(values))
+	}
+	var buf []byte
+	for i, arg := range args {
+		switch arg.Type {
+		case TypeInt:
+			v, ok := values[i].(int64)
+			if !ok {
+				return nil, argError(i, arg, values[i])
+			}
+			buf = binary.LittleEndian.AppendUint64(buf, uint64(v))
+		case TypeBool:
+			v, ok := values[i].(bool)
+			if !ok {
+				return nil, argError(i, arg, values[i])
+			}
+			var n uint64
+			if v {
+				n = 1
+			}
+			buf = binary.LittleEndian.AppendUint64(buf, n)
+		case TypeAddress:
+			v, ok := values[i].(types.Address)
+			if !ok {
+				return nil, argError(i, arg, values[i])
+			}
+			buf = append(buf, v.ToSlice()...)
+		case TypeBytes:
+			v, ok := values[i].([]byte)
+			if !ok {
+				return nil, argError(i, arg, values[i])
+			}
+			buf = binary.LittleEndian.AppendUint64(buf, uint64(len(v)))
+			buf = append(buf, v...)
+		default:
+			return nil, fmt.Errorf("unsupported type %q", arg.Type)
+		}
+	}
+	return buf, nil
+}
+
+func argError(i int, arg Argument, v any) error {
+	return fmt.Errorf("argument %d (%s): cannot encode %T as %s", i, arg.Name, v, arg.Type)
+}
+
+// Decode 按 args 描述的类型解码 data，数据必须被恰好用完
+func Decode(args []Argument, data []byte) ([]any, error) {
+	values := make([]any, 0, len(args))
+	off := 0
+	need := func(n int) error {
+		if n < 0 || off+n > len(data) {
+			return fmt.Errorf("data too short: need %d bytes at offset %d, have %d", n, off, len(data)-off)
+		}
+		return nil
+	}
+
+	for _, arg := range args {
+		switch arg.Type {
+		case TypeInt, TypeBool:
+			if err := need(8); err != nil {
+				return nil, err
+			}
+			n := binary.LittleEndian.Uint64(data[off:])
+			off += 8
+			if arg.Type == TypeInt {
+				values = append(values, int64(n))
+			} else {
+				values = append(values, n != 0)
+			}
+		case TypeAddress:
+			if err := need(20); err != nil {
+				return nil, err
+			}
+			values = append(values, types.AddressFromBytes(data[off:off+20]))
+			off += 20
+		case TypeBytes:
+			if err := need(8); err != nil {
+				return nil, err
+			}
+			n := binary.LittleEndian.Uint64(data[off:])
+			off += 8
+			if n > uint64(len(data)) {
+				return nil, fmt.Errorf("bytes length %d exceeds data size", n)
+			}
+			if err := need(int(n)); err != nil {
+				return nil, err
+			}
+			values = append(values, append([]byte{}, data[off:off+int(n)]...))
+			off += int(n)
+		default:
+			return nil, fmt.Errorf("unsupported type %q", arg.Type)
+		}
+	}
+	if off != len(data) {
+		return nil, fmt.Errorf("%d trailing bytes after decoding", len(data)-off)
+	}
+	return values, nil
+}
+
+// ParseValue 把命令行中的字符串解析为 typ 类型的值。
+// bytes 接受 0x 开头的十六进制，否则按原始字符串处理
+func ParseValue(typ, s string) (any, error) {
+	switch typ {
+	case TypeInt:
+		return strconv.ParseInt(s, 0, 64)
+	case TypeBool:
+		return strconv.ParseBool(s)
+	case TypeAddress:
+		return types.AddressFromHex(s)
+	case TypeBytes:
+		if strings.HasPrefix(s, "0x") {
+			return hex.DecodeString(s[2:])
+		}
+		return []byte(s), nil
+	}
+	return nil, fmt.Errorf("unsupported type %q", typ)
+}
+
+// FormatValue 把解码出的值格式化为便于阅读的字符串
+func FormatValue(v any) string {
+	switch x := v.(type) {
+	case []byte:
+		return "0x" + hex.EncodeToString(x)
+	case types.Address:
+		return x.String()
+	default:
+		return fmt.Sprint(x)
+	}
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// BanResponse 是封禁列表中的一条记录
+type BanResponse struct {
+	NodeID string `json:"node_id,omitempty"`
+	IP     string `json:"ip,omitempty"`
+	Reason string `json:"reason"`
+	Until  string `json:"until"`
+}
+
+// UnbanParams 定义了 admin_unban 的参数，target 为节点 ID 或 IP
+type UnbanParams struct {
+	Target string `json:"target"`
+}
+
+// UnbanResponse 定义了 admin_unban 的返回值
+type UnbanResponse struct {
+	Removed bool `json:"removed"`
+}
+
+// PeerResponse 是一个已连接节点的信息，BestHeight 是对方在握手时通告的高度，RTTMillis 为 0 表示尚未测量
+type PeerResponse struct {
+	NodeID     string  `json:"node_id"`
+	RemoteAddr string  `json:"remote_addr"`
+	ListenAddr string  `json:"listen_addr,omitempty"`
+	Outbound   bool    `json:"outbound"`
+	BestHeight uint32  `json:"best_height"`
+	RTTMillis  float64 `json:"rtt_ms"`
+}
+
+// handleAdminRPC 处理 admin 监听地址上的请求，只提供 admin 接口
+func (s *APIServer) handleAdminRPC(w http.ResponseWriter, r *http.Request) {
+	var req JSONRPCRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, -32700, "Parse error", 0)
+		return
+	}
+
+	s.logger.Log("msg", "received admin rpc request", "method", req.Method, "id", req.ID)
+
+	switch req.Method {
+	case "admin_list_bans":
+		s.handleListBans(w, req)
+	case "admin_unban":
+		s.handleUnban(w, req)
+	case "admin_list_peers":
+		s.handleListPeers(w, req)
+	default:
+		writeError(w, -32601, fmt.Sprintf("method not found: %s", req.Method), req.ID)
+	}
+}
+
+// handleListPeers 返回已连接的节点及其心跳往返时间
+func (s *APIServer) handleListPeers(w http.ResponseWriter, req JSONRPCRequest) {
+	if s.peers == nil {
+		writeError(w, -32000, "peer list is not available", req.ID)
+		return
+	}
+
+	peers := []PeerResponse{}
+	for _, p := range s.peers.Peers() {
+		info := p.Info()
+		peers = append(peers, PeerResponse{
+			NodeID:     string(p.ID()),
+			RemoteAddr: string(p.RemoteAddr()),
+			ListenAddr: info.ListenAddr,
+			Outbound:   p.IsOutbound(),
+			BestHeight: info.BestHeight,
+			RTTMillis:  float64(p.RTT().Microseconds()) / 1000,
+		})
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].NodeID < peers[j].NodeID })
+
+	resp := JSONRPCResponse{
+		Version: "2.0",
+		Result:  peers,
+		ID:      req.ID,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleListBans 返回仍在封禁期的节点
+func (s *APIServer) handleListBans(w http.ResponseWriter, req JSONRPCRequest) {
+	if s.banList == nil {
+		writeError(w, -32000, "ban list is not available", req.ID)
+		return
+	}
+
+	bans := []BanResponse{}
+	for _, e := range s.banList.List() {
+		bans = append(bans, BanResponse{
+			NodeID: string(e.NodeID),
+			IP:     e.IP,
+			Reason: e.Reason,
+			Until:  e.Until.Format(time.RFC3339),
+		})
+	}
+
+	resp := JSONRPCResponse{
+		Version: "2.0",
+		Result:  bans,
+		ID:      req.ID,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleUnban 解除对一个节点 ID 或 IP 的封禁
+func (s *APIServer) handleUnban(w http.ResponseWriter, req JSONRPCRequest) {
+	if s.banList == nil {
+		writeError(w, -32000, "ban list is not available", req.ID)
+		return
+	}
+	var params UnbanParams
+	if err := json.Unmarshal(req.Params, &params); err != nil || params.Target == "" {
+		writeError(w, -32602, "Invalid params", req.ID)
+		return
+	}
+
+	resp := JSONRPCResponse{
+		Version: "2.0",
+		Result:  UnbanResponse{Removed: s.banList.Unban(params.Target)},
+		ID:      req.ID,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
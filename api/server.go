@@ -2,42 +2,97 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/go-kit/log"
 	"github.com/virtue186/xchain/core"
 	"github.com/virtue186/xchain/network"
 	"github.com/virtue186/xchain/types"
 	"net/http"
+	"time"
 )
 
+// shutdownTimeout 是关闭 API 服务器时等待进行中请求的最长时间
+const shutdownTimeout = 5 * time.Second
+
 type APIServer struct {
 	listenAddr string
+	adminAddr  string // admin 接口的监听地址，为空时不提供 admin 接口
 	logger     log.Logger
 	bc         *core.BlockChain // 持有对区块链核心的引用，以便查询数据
 	txPool     *network.TxPool
+	banList    *network.BanList // 为空时 admin 接口不可用
+	peers      PeerLister       // 为空时 admin_list_peers 不可用
+}
+
+// PeerLister 提供当前已连接的节点，network.Transport 实现了它
+type PeerLister interface {
+	Peers() []network.Peer
 }
 
-func NewAPIServer(listenAddr string, logger log.Logger, bc *core.BlockChain, txPool *network.TxPool) *APIServer {
+func NewAPIServer(listenAddr string, logger log.Logger, bc *core.BlockChain, txPool *network.TxPool, banList *network.BanList, peers PeerLister) *APIServer {
 	return &APIServer{
 		listenAddr: listenAddr,
 		logger:     logger,
 		bc:         bc,
 		txPool:     txPool,
+		banList:    banList,
+		peers:      peers,
 	}
 }
 
-func (s *APIServer) Run() error {
+// EnableAdmin 在 listenAddr 上单独提供 admin 接口（封禁管理和节点列表）。
+// admin 接口没有鉴权，默认关闭，只应监听在外部无法访问的地址上
+func (s *APIServer) EnableAdmin(listenAddr string) {
+	s.adminAddr = listenAddr
+}
+
+// Run 启动 API 服务器，ctx 被取消后等待进行中的请求完成再返回
+func (s *APIServer) Run(ctx context.Context) error {
 	s.logger.Log("msg", "starting API server", "listenAddr", s.listenAddr)
 
 	// 创建一个新的 HTTP 请求多路复用器 (router)
 	mux := http.NewServeMux()
 	// 为我们的 RPC 端点注册一个处理器
 	mux.HandleFunc("/rpc", s.handleRPC)
+	servers := []*http.Server{{Addr: s.listenAddr, Handler: mux}}
+	if s.adminAddr != "" {
+		s.logger.Log("msg", "starting admin API server", "listenAddr", s.adminAddr)
+		adminMux := http.NewServeMux()
+		adminMux.HandleFunc("/rpc", s.handleAdminRPC)
+		servers = append(servers, &http.Server{Addr: s.adminAddr, Handler: adminMux})
+	}
+
+	errCh := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func() { errCh <- srv.ListenAndServe() }()
+	}
 
-	// 启动服务器
-	return http.ListenAndServe(s.listenAddr, mux)
+	// 任何一个服务器无法启动时关闭全部服务器
+	var runErr error
+	remaining := len(servers)
+	select {
+	case runErr = <-errCh:
+		remaining--
+	case <-ctx.Done():
+	}
+	s.logger.Log("msg", "stopping API server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, srv := range servers {
+		if err := srv.Shutdown(shutdownCtx); err != nil && runErr == nil {
+			runErr = err
+		}
+	}
+	for ; remaining > 0; remaining-- {
+		if err := <-errCh; !errors.Is(err, http.ErrServerClosed) && runErr == nil {
+			runErr = err
+		}
+	}
+	return runErr
 }
 
 // JSONRPCRequest 定义了 JSON-RPC 2.0 请求的结构
@@ -80,6 +135,10 @@ func (s *APIServer) handleRPC(w http.ResponseWriter, r *http.Request) {
 		s.handleGetAccountState(w, req)
 	case "send_raw_transaction": // 【新增】
 		s.handleSendRawTransaction(w, req)
+	case "call_contract":
+		s.handleCallContract(w, req)
+	case "debug_trace_transaction":
+		s.handleTraceTransaction(w, req)
 	default:
 		// 如果方法不存在
 		writeError(w, -32601, fmt.Sprintf("method not found: %s", req.Method), req.ID)
@@ -193,3 +252,169 @@ func (s *APIServer) handleSendRawTransaction(w http.ResponseWriter, req JSONRPCR
 
 	s.logger.Log("msg", "transaction received via api", "hash", hash)
 }
+
+type CallContractParams struct {
+	From  string `json:"from"` // 可选，默认为零地址
+	To    string `json:"to"`
+	Value uint64 `json:"value"`
+	Data  string `json:"data"` // 十六进制编码的调用数据
+}
+
+type LogResponse struct {
+	Address string `json:"address"`
+	Topic   string `json:"topic"`
+	Data    string `json:"data"`
+}
+
+type CallContractResponse struct {
+	ReturnData string        `json:"return_data"`
+	Logs       []LogResponse `json:"logs"`
+	GasUsed    uint64        `json:"gas_used"`
+	Error      string        `json:"error,omitempty"` // 执行失败或回滚的原因
+}
+
+// handleCallContract 基于最新状态模拟执行一次合约调用，不会产生交易
+func (s *APIServer) handleCallContract(w http.ResponseWriter, req JSONRPCRequest) {
+	var params CallContractParams
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		writeError(w, -32602, "Invalid params", req.ID)
+		return
+	}
+
+	var from types.Address
+	if params.From != "" {
+		addr, err := types.AddressFromHex(params.From)
+		if err != nil {
+			writeError(w, -32602, fmt.Sprintf("invalid address format: %s", params.From), req.ID)
+			return
+		}
+		from = addr
+	}
+	to, err := types.AddressFromHex(params.To)
+	if err != nil {
+		writeError(w, -32602, fmt.Sprintf("invalid address format: %s", params.To), req.ID)
+		return
+	}
+	data, err := hex.DecodeString(params.Data)
+	if err != nil {
+		writeError(w, -32602, "Invalid data: not a valid hex string", req.ID)
+		return
+	}
+
+	result := s.bc.StaticCall(from, to, params.Value, data)
+	respBody := CallContractResponse{
+		ReturnData: hex.EncodeToString(result.ReturnData),
+		Logs:       make([]LogResponse, len(result.Logs)),
+		GasUsed:    result.GasUsed,
+	}
+	for i, l := range result.Logs {
+		respBody.Logs[i] = LogResponse{
+			Address: l.Address.String(),
+			Topic:   hex.EncodeToString(l.Topic),
+			Data:    hex.EncodeToString(l.Data),
+		}
+	}
+	if result.Err != nil {
+		respBody.Error = result.Err.Error()
+	}
+
+	resp := JSONRPCResponse{
+		Version: "2.0",
+		Result:  respBody,
+		ID:      req.ID,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+type TraceTransactionParams struct {
+	Hash types.Hash `json:"hash"`
+}
+
+type StorageWriteResponse struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type StepLogResponse struct {
+	Pc      int                    `json:"pc"`
+	Op      string                 `json:"op"`
+	Gas     uint64                 `json:"gas"`
+	GasCost uint64                 `json:"gas_cost"`
+	Depth   int                    `json:"depth"`
+	Address string                 `json:"address"`
+	Stack   []string               `json:"stack"` // 从栈底到栈顶，整数为十进制，字节串为十六进制
+	Storage []StorageWriteResponse `json:"storage,omitempty"`
+}
+
+type TraceTransactionResponse struct {
+	GasUsed    uint64            `json:"gas_used"`
+	Failed     bool              `json:"failed"`
+	Error      string            `json:"error,omitempty"`
+	ReturnData string            `json:"return_data"`
+	Steps      []StepLogResponse `json:"steps"`
+}
+
+// handleTraceTransaction 在交易所在区块的父状态上重新执行交易，返回逐条指令的跟踪记录
+func (s *APIServer) handleTraceTransaction(w http.ResponseWriter, req JSONRPCRequest) {
+	var params TraceTransactionParams
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		writeError(w, -32602, "Invalid params", req.ID)
+		return
+	}
+
+	result, steps, err := s.bc.TraceTransaction(params.Hash)
+	if err != nil {
+		writeError(w, -32000, fmt.Sprintf("failed to trace transaction: %s", err), req.ID)
+		return
+	}
+
+	respBody := TraceTransactionResponse{
+		GasUsed:    result.GasUsed,
+		Failed:     result.Err != nil,
+		ReturnData: hex.EncodeToString(result.ReturnData),
+		Steps:      make([]StepLogResponse, len(steps)),
+	}
+	if result.Err != nil {
+		respBody.Error = result.Err.Error()
+	}
+	for i, step := range steps {
+		stack := make([]string, len(step.Stack))
+		for j, v := range step.Stack {
+			stack[j] = formatStackValue(v)
+		}
+		storage := make([]StorageWriteResponse, len(step.Storage))
+		for j, w := range step.Storage {
+			storage[j] = StorageWriteResponse{Key: hex.EncodeToString(w.Key), Value: hex.EncodeToString(w.Value)}
+		}
+		respBody.Steps[i] = StepLogResponse{
+			Pc:      step.Pc,
+			Op:      step.Op.String(),
+			Gas:     step.Gas,
+			GasCost: step.GasCost,
+			Depth:   step.Depth,
+			Address: step.Address.String(),
+			Stack:   stack,
+			Storage: storage,
+		}
+	}
+
+	resp := JSONRPCResponse{
+		Version: "2.0",
+		Result:  respBody,
+		ID:      req.ID,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func formatStackValue(v any) string {
+	switch x := v.(type) {
+	case []byte:
+		return "0x" + hex.EncodeToString(x)
+	case byte:
+		return fmt.Sprintf("0x%02x", x)
+	default:
+		return fmt.Sprint(x)
+	}
+}
@@ -0,0 +1,321 @@
+// Package asm 实现了 core.VM 字节码与可读汇编文本之间的相互转换。
+//
+// 汇编格式以行为单位，`;` 之后的内容为注释：
+//
+//	loop:                 ; 标签，可被 jump/jumpi 引用
+//	    push 1            ; 助记符与 core.Instruction 一一对应
+//	    push8 -100000     ; push8 接受任意 64 位有符号整数
+//	    pushb 'a'         ; 单字节立即数可以写成十进制、0x 十六进制或字符
+//	    jumpi loop        ; 跳转指令的立即数可以是标签或绝对位置
+//	    .bytes "foo"      ; 伪指令：把字节串压栈（展开为 pushb... push n pack）
+//	    .byte 0xff        ; 伪指令：原样输出一个字节
+package asm
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"github.com/virtue186/xchain/core"
+	"strconv"
+	"strings"
+)
+
+// Assemble 把汇编文本翻译为字节码
+func Assemble(src string) ([]byte, error) {
+	lines, err := parse(src)
+	if err != nil {
+		return nil, err
+	}
+
+	// 第一遍：计算每条语句的位置，记录标签
+	labels := make(map[string]int)
+	offset := 0
+	for _, l := range lines {
+		if l.label != "" {
+			if _, ok := labels[l.label]; ok {
+				return nil, fmt.Errorf("line %d: duplicate label %q", l.num, l.label)
+			}
+			labels[l.label] = offset
+		}
+		size, err := l.size()
+		if err != nil {
+			return nil, err
+		}
+		offset += size
+	}
+
+	// 第二遍：生成字节码
+	code := make([]byte, 0, offset)
+	for _, l := range lines {
+		if l.op == "" {
+			continue
+		}
+		code, err = l.emit(code, labels)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return code, nil
+}
+
+// Disassemble 把字节码翻译为汇编文本，跳转目标会被替换为自动生成的标签。
+// 无法识别的字节以 .byte 输出，因此 Assemble(Disassemble(code)) 总是等于 code。
+func Disassemble(code []byte) string {
+	type decoded struct {
+		offset  int
+		instr   core.Instruction
+		operand []byte
+		raw     bool
+	}
+
+	var instrs []decoded
+	starts := make(map[int]bool)
+	for pc := 0; pc < len(code); {
+		instr := core.Instruction(code[pc])
+		size := instr.OperandSize()
+		if size < 0 || pc+1+size > len(code) {
+			instrs = append(instrs, decoded{offset: pc, instr: instr, raw: true})
+			pc++
+			continue
+		}
+		instrs = append(instrs, decoded{offset: pc, instr: instr, operand: code[pc+1 : pc+1+size]})
+		starts[pc] = true
+		pc += 1 + size
+	}
+
+	// 只有指向合法指令起点的跳转才会被替换为标签
+	targets := make(map[int]bool)
+	for _, d := range instrs {
+		if !d.raw && isJump(d.instr) {
+			if t := int(binary.BigEndian.Uint16(d.operand)); starts[t] {
+				targets[t] = true
+			}
+		}
+	}
+
+	sb := new(strings.Builder)
+	for _, d := range instrs {
+		if targets[d.offset] {
+			fmt.Fprintf(sb, "%s:\n", labelName(d.offset))
+		}
+		switch {
+		case d.raw:
+			fmt.Fprintf(sb, "    .byte 0x%02x\n", byte(d.instr))
+		case isJump(d.instr):
+			t := int(binary.BigEndian.Uint16(d.operand))
+			if targets[t] {
+				fmt.Fprintf(sb, "    %s %s\n", d.instr, labelName(t))
+			} else {
+				fmt.Fprintf(sb, "    %s %d\n", d.instr, t)
+			}
+		case d.instr == core.InstrPushByte:
+			fmt.Fprintf(sb, "    %s 0x%02x\n", d.instr, d.operand[0])
+		case len(d.operand) == 1:
+			fmt.Fprintf(sb, "    %s %d\n", d.instr, d.operand[0])
+		case len(d.operand) == 8:
+			fmt.Fprintf(sb, "    %s %d\n", d.instr, int64(binary.BigEndian.Uint64(d.operand)))
+		default:
+			fmt.Fprintf(sb, "    %s\n", d.instr)
+		}
+	}
+	return sb.String()
+}
+
+func labelName(offset int) string {
+	return fmt.Sprintf("L%04x", offset)
+}
+
+func isJump(instr core.Instruction) bool {
+	return instr == core.InstrJump || instr == core.InstrJumpIf
+}
+
+// line 是解析后的一行汇编
+type line struct {
+	num     int
+	label   string
+	op      string
+	operand string
+}
+
+func parse(src string) ([]line, error) {
+	var lines []line
+	for i, text := range strings.Split(src, "\n") {
+		l := line{num: i + 1}
+		text = stripComment(text)
+
+		if idx := strings.Index(text, ":"); idx >= 0 && !strings.ContainsAny(text[:idx], "'\"") {
+			l.label = strings.TrimSpace(text[:idx])
+			if !isIdent(l.label) {
+				return nil, fmt.Errorf("line %d: invalid label %q", l.num, l.label)
+			}
+			text = text[idx+1:]
+		}
+
+		text = strings.TrimSpace(text)
+		if text != "" {
+			fields := strings.SplitN(text, " ", 2)
+			l.op = strings.ToLower(fields[0])
+			if len(fields) == 2 {
+				l.operand = strings.TrimSpace(fields[1])
+			}
+		}
+
+		if l.label != "" || l.op != "" {
+			lines = append(lines, l)
+		}
+	}
+	return lines, nil
+}
+
+// stripComment 去掉 `;` 之后的注释，但保留字符串和字符字面量中的 `;`
+func stripComment(text string) string {
+	var quote rune
+	for i, r := range text {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == ';':
+			return text[:i]
+		}
+	}
+	return text
+}
+
+func (l line) size() (int, error) {
+	switch l.op {
+	case "":
+		return 0, nil
+	case ".byte":
+		return 1, nil
+	case ".bytes":
+		b, err := l.bytesOperand()
+		if err != nil {
+			return 0, err
+		}
+		return 2*len(b) + 3, nil
+	}
+
+	instr, ok := core.InstructionByName(l.op)
+	if !ok {
+		return 0, fmt.Errorf("line %d: unknown instruction %q", l.num, l.op)
+	}
+	return 1 + instr.OperandSize(), nil
+}
+
+func (l line) emit(code []byte, labels map[string]int) ([]byte, error) {
+	switch l.op {
+	case ".byte":
+		v, err := l.byteOperand()
+		if err != nil {
+			return nil, err
+		}
+		return append(code, v), nil
+	case ".bytes":
+		b, err := l.bytesOperand()
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range b {
+			code = append(code, byte(core.InstrPushByte), c)
+		}
+		return append(code, byte(core.InstrPushInt), byte(len(b)), byte(core.InstrPack)), nil
+	}
+
+	instr, _ := core.InstructionByName(l.op)
+	switch instr.OperandSize() {
+	case 0:
+		if l.operand != "" {
+			return nil, fmt.Errorf("line %d: %s takes no operand", l.num, l.op)
+		}
+		return append(code, byte(instr)), nil
+	case 1:
+		v, err := l.byteOperand()
+		if err != nil {
+			return nil, err
+		}
+		return append(code, byte(instr), v), nil
+	case 2:
+		target, ok := labels[l.operand]
+		if !ok {
+			v, err := strconv.ParseUint(l.operand, 0, 16)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: undefined label %q", l.num, l.operand)
+			}
+			target = int(v)
+		}
+		if target > 0xffff {
+			return nil, fmt.Errorf("line %d: jump target %d out of range", l.num, target)
+		}
+		return binary.BigEndian.AppendUint16(append(code, byte(instr)), uint16(target)), nil
+	case 8:
+		v, err := strconv.ParseInt(l.operand, 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid integer operand %q", l.num, l.operand)
+		}
+		return binary.BigEndian.AppendUint64(append(code, byte(instr)), uint64(v)), nil
+	}
+	return nil, fmt.Errorf("line %d: unsupported operand size for %s", l.num, l.op)
+}
+
+// byteOperand 解析单字节立即数：十进制、0x 十六进制或 'c' 形式的字符
+func (l line) byteOperand() (byte, error) {
+	s := l.operand
+	if s == "" {
+		return 0, fmt.Errorf("line %d: %s requires an operand", l.num, l.op)
+	}
+	if strings.HasPrefix(s, "'") {
+		r, _, tail, err := strconv.UnquoteChar(strings.TrimSuffix(s[1:], "'"), '\'')
+		if err != nil || tail != "" || !strings.HasSuffix(s, "'") || r > 0xff {
+			return 0, fmt.Errorf("line %d: invalid character literal %s", l.num, s)
+		}
+		return byte(r), nil
+	}
+	v, err := strconv.ParseUint(s, 0, 8)
+	if err != nil {
+		return 0, fmt.Errorf("line %d: invalid byte operand %q", l.num, s)
+	}
+	return byte(v), nil
+}
+
+// bytesOperand 解析 .bytes 的操作数："字符串" 或 0x 十六进制
+func (l line) bytesOperand() ([]byte, error) {
+	s := l.operand
+	var b []byte
+	switch {
+	case strings.HasPrefix(s, "\""):
+		str, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid string literal %s", l.num, s)
+		}
+		b = []byte(str)
+	case strings.HasPrefix(s, "0x"):
+		h, err := hex.DecodeString(s[2:])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid hex literal %s", l.num, s)
+		}
+		b = h
+	default:
+		return nil, fmt.Errorf("line %d: .bytes expects a string or 0x hex literal", l.num)
+	}
+	if len(b) > 0xff {
+		return nil, fmt.Errorf("line %d: .bytes literal longer than 255 bytes", l.num)
+	}
+	return b, nil
+}
+
+func isIdent(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (i > 0 && r >= '0' && r <= '9') {
+			continue
+		}
+		return false
+	}
+	return true
+}
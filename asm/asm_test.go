@@ -0,0 +1,75 @@
+package asm
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/virtue186/xchain/core"
+	"testing"
+)
+
+func TestAssemble(t *testing.T) {
+	src := `
+; 计数到 3
+start:
+    push 3
+loop:   push 1
+    sub
+    jumpi loop      ; 非 0 时继续
+    .bytes "ok"
+    return
+`
+	code, err := Assemble(src)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{
+		byte(core.InstrPushInt), 3,
+		byte(core.InstrPushInt), 1,
+		byte(core.InstrSub),
+		byte(core.InstrJumpIf), 0x00, 0x02,
+		byte(core.InstrPushByte), 'o',
+		byte(core.InstrPushByte), 'k',
+		byte(core.InstrPushInt), 2,
+		byte(core.InstrPack),
+		byte(core.InstrReturn),
+	}, code)
+}
+
+func TestAssembleErrors(t *testing.T) {
+	_, err := Assemble("push 1\nfoo 2\n")
+	assert.EqualError(t, err, `line 2: unknown instruction "foo"`)
+
+	_, err = Assemble("jump nowhere")
+	assert.EqualError(t, err, `line 1: undefined label "nowhere"`)
+
+	_, err = Assemble("push")
+	assert.EqualError(t, err, `line 1: push requires an operand`)
+
+	_, err = Assemble("push 256")
+	assert.EqualError(t, err, `line 1: invalid byte operand "256"`)
+}
+
+func TestDisassembleRoundTrip(t *testing.T) {
+	code, err := Assemble(`
+top:
+    pushb ';'
+    push 0
+    jumpi top
+    jump 0x0100
+    push8 -70000
+    .byte 0xff
+    .byte 0x0a`)
+	assert.Nil(t, err)
+
+	text := Disassemble(code)
+	assert.Equal(t, `L0000:
+    pushb 0x3b
+    push 0
+    jumpi L0000
+    jump 256
+    push8 -70000
+    .byte 0xff
+    .byte 0x0a
+`, text)
+
+	again, err := Assemble(text)
+	assert.Nil(t, err)
+	assert.Equal(t, code, again)
+}
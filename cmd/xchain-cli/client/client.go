@@ -83,6 +83,40 @@ func (c *Client) SendRawTransaction(txHex string) (string, error) {
 	return rpcResp.Result, nil
 }
 
+// CallContract 调用 call_contract RPC 方法，在节点上模拟执行一次合约调用
+func (c *Client) CallContract(params CallContractParams) (*CallContractResponse, error) {
+	reqBody, _ := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      1,
+		"method":  "call_contract",
+		"params":  params,
+	})
+
+	resp, err := http.Post(c.Endpoint, "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to API server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, _ := io.ReadAll(resp.Body)
+	var rpcResp struct {
+		Result *CallContractResponse `json:"result"`
+		Error  *RPCError             `json:"error"`
+	}
+
+	if err := json.Unmarshal(bodyBytes, &rpcResp); err != nil {
+		return nil, fmt.Errorf("failed to parse RPC response: %w\nResponse body: %s", err, string(bodyBytes))
+	}
+	if rpcResp.Error != nil {
+		return nil, fmt.Errorf("API error: %s", rpcResp.Error.Message)
+	}
+	if rpcResp.Result == nil {
+		return nil, fmt.Errorf("received empty result from API")
+	}
+
+	return rpcResp.Result, nil
+}
+
 // --- 辅助数据结构 ---
 
 type AccountStateResponse struct {
@@ -93,3 +127,23 @@ type AccountStateResponse struct {
 type RPCError struct {
 	Message string `json:"message"`
 }
+
+type CallContractParams struct {
+	From  string `json:"from,omitempty"`
+	To    string `json:"to"`
+	Value uint64 `json:"value"`
+	Data  string `json:"data"`
+}
+
+type LogResponse struct {
+	Address string `json:"address"`
+	Topic   string `json:"topic"`
+	Data    string `json:"data"`
+}
+
+type CallContractResponse struct {
+	ReturnData string        `json:"return_data"`
+	Logs       []LogResponse `json:"logs"`
+	GasUsed    uint64        `json:"gas_used"`
+	Error      string        `json:"error"`
+}
@@ -0,0 +1,152 @@
+package contract
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/virtue186/xchain/abi"
+	"github.com/virtue186/xchain/cmd/xchain-cli/client"
+	"github.com/virtue186/xchain/crypto"
+	"github.com/virtue186/xchain/types"
+	"os"
+	"strings"
+)
+
+func newCallCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "call --abi <file> --to <address> <function> [args...]",
+		Short: "Call a contract function",
+		Long: `Encodes the arguments according to the contract ABI and simulates the call
+on the node, printing the decoded return values and events. With --send the
+call is also submitted as a transaction signed by --from.
+
+Arguments are parsed by type: int accepts decimal or 0x-prefixed numbers,
+bool accepts true/false, address accepts hex, and bytes accepts 0x-prefixed
+hex or a plain string.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			abiPath, _ := cmd.Flags().GetString("abi")
+			toAddrHex, _ := cmd.Flags().GetString("to")
+			fromKeyHex, _ := cmd.Flags().GetString("from")
+			value, _ := cmd.Flags().GetUint64("value")
+			send, _ := cmd.Flags().GetBool("send")
+			apiEndpoint, err := cmd.Flags().GetString("url")
+			if err != nil {
+				return err
+			}
+
+			if abiPath == "" || toAddrHex == "" {
+				return fmt.Errorf("flags --abi and --to are required")
+			}
+			if send && fromKeyHex == "" {
+				return fmt.Errorf("flag --from is required with --send")
+			}
+
+			abiJSON, err := os.ReadFile(abiPath)
+			if err != nil {
+				return err
+			}
+			contractABI, err := abi.Parse(abiJSON)
+			if err != nil {
+				return fmt.Errorf("invalid abi: %w", err)
+			}
+			to, err := types.AddressFromHex(toAddrHex)
+			if err != nil {
+				return fmt.Errorf("invalid contract address: %w", err)
+			}
+
+			fn, err := contractABI.Function(args[0])
+			if err != nil {
+				return err
+			}
+			if len(args)-1 != len(fn.Inputs) {
+				return fmt.Errorf("function %s expects %d arguments, got %d", fn.Signature(), len(fn.Inputs), len(args)-1)
+			}
+			values := make([]any, len(fn.Inputs))
+			for i, input := range fn.Inputs {
+				if values[i], err = abi.ParseValue(input.Type, args[i+1]); err != nil {
+					return fmt.Errorf("invalid argument %s: %w", input.Name, err)
+				}
+			}
+			data, err := contractABI.EncodeCall(fn.Name, values...)
+			if err != nil {
+				return err
+			}
+
+			var fromKey crypto.PrivateKey
+			params := client.CallContractParams{To: to.String(), Value: value, Data: hex.EncodeToString(data)}
+			if fromKeyHex != "" {
+				if fromKey, err = crypto.NewPrivateKeyFromHex(fromKeyHex); err != nil {
+					return fmt.Errorf("invalid private key: %w", err)
+				}
+				params.From = fromKey.PublicKey().Address().String()
+			}
+
+			cli := client.New(apiEndpoint)
+			result, err := cli.CallContract(params)
+			if err != nil {
+				return err
+			}
+			if err := printCallResult(contractABI, fn, result); err != nil {
+				return err
+			}
+
+			if send {
+				txHash, _, err := sendTransaction(cli, fromKey, to, value, data)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("Transaction Hash: %s\n", txHash)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().String("abi", "", "Path to the contract ABI in JSON format")
+	cmd.Flags().String("to", "", "Address of the contract (in hex format)")
+	cmd.Flags().String("from", "", "Private key of the caller (in hex format)")
+	cmd.Flags().Uint64("value", 0, "Amount to transfer with the call")
+	cmd.Flags().Bool("send", false, "Submit the call as a transaction after simulating it")
+
+	return cmd
+}
+
+// printCallResult 打印模拟调用的返回值和事件，调用失败时返回错误
+func printCallResult(contractABI *abi.ABI, fn *abi.Function, result *client.CallContractResponse) error {
+	returnData, err := hex.DecodeString(result.ReturnData)
+	if err != nil {
+		return fmt.Errorf("invalid return data: %w", err)
+	}
+	if result.Error != "" {
+		// 回滚时返回数据是 require 的错误信息
+		if len(returnData) > 0 {
+			return fmt.Errorf("call failed: %s: %q", result.Error, returnData)
+		}
+		return fmt.Errorf("call failed: %s", result.Error)
+	}
+
+	fmt.Printf("Gas used: %d\n", result.GasUsed)
+	outputs, err := fn.DecodeOutputs(returnData)
+	if err != nil {
+		return fmt.Errorf("failed to decode return data 0x%s: %w", result.ReturnData, err)
+	}
+	for i, out := range outputs {
+		fmt.Printf("Output %d (%s): %s\n", i, fn.Outputs[i].Type, abi.FormatValue(out))
+	}
+
+	for _, l := range result.Logs {
+		topic, _ := hex.DecodeString(l.Topic)
+		data, _ := hex.DecodeString(l.Data)
+		event, values, err := contractABI.DecodeLog(topic, data)
+		if err != nil {
+			fmt.Printf("Log %s topic=0x%s data=0x%s\n", l.Address, l.Topic, l.Data)
+			continue
+		}
+		formatted := make([]string, len(values))
+		for i, v := range values {
+			formatted[i] = fmt.Sprintf("%s=%s", event.Inputs[i].Name, abi.FormatValue(v))
+		}
+		fmt.Printf("Event %s(%s)\n", event.Name, strings.Join(formatted, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package contract
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/virtue186/xchain/compiler"
+	"os"
+	"strings"
+)
+
+func newCompileCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "compile [file]",
+		Short: "Compile a contract source file into VM bytecode",
+		Long: `Compiles a contract written in the xchain contract language and prints the
+resulting bytecode in hex format. Compilation errors are reported as
+file:line:column: message.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := args[0]
+			showAsm, _ := cmd.Flags().GetBool("asm")
+			showABI, _ := cmd.Flags().GetBool("abi")
+
+			src, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+
+			res, err := compiler.Compile(string(src))
+			if err != nil {
+				return formatCompileError(path, err)
+			}
+
+			if showAsm {
+				fmt.Print(res.Asm)
+				return nil
+			}
+			if showABI {
+				out, err := json.MarshalIndent(res.ABI, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(out))
+				return nil
+			}
+			fmt.Println(hex.EncodeToString(res.Code))
+			return nil
+		},
+	}
+
+	cmd.Flags().Bool("asm", false, "Print the generated assembly instead of bytecode")
+	cmd.Flags().Bool("abi", false, "Print the contract ABI in JSON format instead of bytecode")
+
+	return cmd
+}
+
+// formatCompileError 为每条编译错误加上文件名前缀
+func formatCompileError(path string, err error) error {
+	var list compiler.ErrorList
+	var single *compiler.Error
+	switch {
+	case errors.As(err, &list):
+	case errors.As(err, &single):
+		list = compiler.ErrorList{single}
+	default:
+		return err
+	}
+
+	lines := make([]string, len(list))
+	for i, e := range list {
+		lines[i] = fmt.Sprintf("%s:%s", path, e)
+	}
+	return fmt.Errorf("compilation failed:\n%s", strings.Join(lines, "\n"))
+}
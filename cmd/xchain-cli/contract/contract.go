@@ -0,0 +1,54 @@
+package contract
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/virtue186/xchain/cmd/xchain-cli/client"
+	"github.com/virtue186/xchain/core"
+	"github.com/virtue186/xchain/crypto"
+	"github.com/virtue186/xchain/types"
+)
+
+// NewContractCmd 返回 contract 命令组，用于编译和操作合约
+func NewContractCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "contract",
+		Short: "Compile and interact with smart contracts",
+	}
+	cmd.AddCommand(newCompileCmd())
+	cmd.AddCommand(newDeployCmd())
+	cmd.AddCommand(newCallCmd())
+	return cmd
+}
+
+// sendTransaction 查询发送方的 nonce，构建并签名交易后提交到节点，返回交易哈希和所用的 nonce
+func sendTransaction(cli *client.Client, key crypto.PrivateKey, to types.Address, value uint64, data []byte) (string, uint64, error) {
+	from := key.PublicKey().Address()
+	state, err := cli.GetAccountState(from.String())
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to get current nonce for sender: %w", err)
+	}
+
+	tx := core.NewTransaction(data)
+	tx.To = to
+	tx.Value = value
+	tx.Nonce = state.Nonce
+	if tx.Data == nil {
+		tx.Data = []byte{}
+	}
+	if err := tx.Sign(key); err != nil {
+		return "", 0, fmt.Errorf("failed to sign transaction: %w", err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := tx.Encode(buf, core.JSONEncoder[*core.Transaction]{}); err != nil {
+		return "", 0, fmt.Errorf("failed to encode transaction: %w", err)
+	}
+	hash, err := cli.SendRawTransaction(hex.EncodeToString(buf.Bytes()))
+	if err != nil {
+		return "", 0, err
+	}
+	return hash, tx.Nonce, nil
+}
@@ -0,0 +1,83 @@
+package contract
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/virtue186/xchain/cmd/xchain-cli/client"
+	"github.com/virtue186/xchain/compiler"
+	"github.com/virtue186/xchain/core"
+	"github.com/virtue186/xchain/crypto"
+	"github.com/virtue186/xchain/types"
+	"os"
+	"strings"
+)
+
+func newDeployCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "deploy --from <private_key> [file]",
+		Short: "Deploy a contract to the network",
+		Long: `Compiles the given contract source file (or takes the raw bytecode from
+--code) and submits a deployment transaction signed by --from. The address of
+the new contract is printed once the transaction has been accepted.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fromKeyHex, _ := cmd.Flags().GetString("from")
+			codeHex, _ := cmd.Flags().GetString("code")
+			value, _ := cmd.Flags().GetUint64("value")
+			apiEndpoint, err := cmd.Flags().GetString("url")
+			if err != nil {
+				return err
+			}
+
+			if fromKeyHex == "" {
+				return fmt.Errorf("flag --from is required")
+			}
+			if (len(args) == 0) == (codeHex == "") {
+				return fmt.Errorf("either a source file or --code must be given")
+			}
+
+			var code []byte
+			if len(args) == 1 {
+				src, err := os.ReadFile(args[0])
+				if err != nil {
+					return err
+				}
+				res, err := compiler.Compile(string(src))
+				if err != nil {
+					return formatCompileError(args[0], err)
+				}
+				code = res.Code
+			} else {
+				code, err = hex.DecodeString(strings.TrimPrefix(codeHex, "0x"))
+				if err != nil {
+					return fmt.Errorf("invalid --code: %w", err)
+				}
+			}
+			if len(code) == 0 {
+				return fmt.Errorf("contract code is empty")
+			}
+
+			fromKey, err := crypto.NewPrivateKeyFromHex(fromKeyHex)
+			if err != nil {
+				return fmt.Errorf("invalid private key: %w", err)
+			}
+
+			cli := client.New(apiEndpoint)
+			txHash, nonce, err := sendTransaction(cli, fromKey, types.Address{}, value, code)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("Transaction Hash: %s\n", txHash)
+			fmt.Printf("Contract Address: %s\n", core.ContractAddress(fromKey.PublicKey().Address(), nonce))
+			return nil
+		},
+	}
+
+	cmd.Flags().String("from", "", "Private key of the deployer (in hex format)")
+	cmd.Flags().String("code", "", "Deploy raw bytecode (in hex format) instead of compiling a source file")
+	cmd.Flags().Uint64("value", 0, "Amount to transfer to the new contract")
+
+	return cmd
+}
@@ -5,7 +5,9 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/virtue186/xchain/cmd/xchain-cli/account"
 	"github.com/virtue186/xchain/cmd/xchain-cli/balance"
+	"github.com/virtue186/xchain/cmd/xchain-cli/contract"
 	"github.com/virtue186/xchain/cmd/xchain-cli/transfer"
+	"github.com/virtue186/xchain/cmd/xchain-cli/vm"
 	"os"
 )
 
@@ -28,6 +30,8 @@ func main() {
 	rootCmd.AddCommand(account.NewAccountCmd())
 	rootCmd.AddCommand(balance.NewBalanceCmd())
 	rootCmd.AddCommand(transfer.NewTransferCmd())
+	rootCmd.AddCommand(vm.NewVmCmd())
+	rootCmd.AddCommand(contract.NewContractCmd())
 
 	// 执行命令
 	if err := rootCmd.Execute(); err != nil {
@@ -0,0 +1,183 @@
+package vm
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/virtue186/xchain/asm"
+	"github.com/virtue186/xchain/core"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// NewVmCmd 返回 vm 命令组，包含汇编、反汇编和本地运行三个子命令
+func NewVmCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "vm",
+		Short: "Assemble, disassemble and locally run xchain VM bytecode",
+	}
+	cmd.AddCommand(newAsmCmd())
+	cmd.AddCommand(newDisasmCmd())
+	cmd.AddCommand(newRunCmd())
+	return cmd
+}
+
+func newAsmCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "asm [file]",
+		Short: "Assemble a source file into hex bytecode",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			src, err := os.ReadFile(args[0])
+			if err != nil {
+				return err
+			}
+			code, err := asm.Assemble(string(src))
+			if err != nil {
+				return fmt.Errorf("%s: %w", args[0], err)
+			}
+			fmt.Println(hex.EncodeToString(code))
+			return nil
+		},
+	}
+}
+
+func newDisasmCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "disasm [hex_bytecode]",
+		Short: "Disassemble hex bytecode into readable assembly",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			code, err := decodeHex(args[0])
+			if err != nil {
+				return fmt.Errorf("invalid bytecode: %w", err)
+			}
+			fmt.Print(asm.Disassemble(code))
+			return nil
+		},
+	}
+}
+
+func newRunCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "run [file] | run --code <hex_bytecode>",
+		Short: "Execute bytecode locally against a throwaway in-memory state",
+		Long: `Assembles the given source file (or takes raw bytecode from --code), runs it
+in a fresh VM on top of an empty in-memory state, and prints the final stack
+and every storage slot the execution wrote. Nothing is sent to the network.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			codeHex, _ := cmd.Flags().GetString("code")
+			inputHex, _ := cmd.Flags().GetString("input")
+			gas, _ := cmd.Flags().GetUint64("gas")
+
+			var code []byte
+			switch {
+			case len(args) == 1 && codeHex == "":
+				src, err := os.ReadFile(args[0])
+				if err != nil {
+					return err
+				}
+				if code, err = asm.Assemble(string(src)); err != nil {
+					return fmt.Errorf("%s: %w", args[0], err)
+				}
+			case len(args) == 0 && codeHex != "":
+				var err error
+				if code, err = decodeHex(codeHex); err != nil {
+					return fmt.Errorf("invalid --code: %w", err)
+				}
+			default:
+				return fmt.Errorf("provide either a source file or --code, but not both")
+			}
+
+			input, err := decodeHex(inputHex)
+			if err != nil {
+				return fmt.Errorf("invalid --input: %w", err)
+			}
+
+			state := core.NewStateCache(nil)
+			vm := core.NewContractVm(code, state, core.Contract{Input: input}, gas)
+			runErr := vm.Run()
+
+			// 打印执行结果
+			switch {
+			case runErr == nil:
+				fmt.Println("Status:   success")
+			case errors.Is(runErr, core.ErrExecutionReverted):
+				fmt.Println("Status:   reverted")
+			default:
+				fmt.Printf("Status:   failed (%s)\n", runErr)
+			}
+			fmt.Printf("Gas used: %d\n", gas-vm.Gas())
+			fmt.Printf("Return:   0x%s\n", hex.EncodeToString(vm.ReturnData()))
+
+			fmt.Println("Stack (bottom to top):")
+			stack := vm.Stack()
+			if len(stack) == 0 {
+				fmt.Println("  <empty>")
+			}
+			for i, item := range stack {
+				fmt.Printf("  [%d] %s\n", i, formatStackItem(item))
+			}
+
+			fmt.Println("Storage diff:")
+			changes, err := state.StorageChanges()
+			if err != nil {
+				return err
+			}
+			if len(changes) == 0 {
+				fmt.Println("  <none>")
+			}
+			for _, c := range changes {
+				fmt.Printf("  %s %s: %s -> %s\n", c.Address, formatBytes(c.Key), formatBytes(c.Old), formatBytes(c.New))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().String("code", "", "Raw bytecode to run (in hex format) instead of a source file")
+	cmd.Flags().String("input", "", "Call data passed to the contract (in hex format)")
+	cmd.Flags().Uint64("gas", core.DefaultGasLimit, "Gas available to the execution")
+
+	return cmd
+}
+
+func decodeHex(s string) ([]byte, error) {
+	return hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(s), "0x"))
+}
+
+func formatStackItem(item any) string {
+	switch v := item.(type) {
+	case int:
+		return fmt.Sprintf("int   %d", v)
+	case byte:
+		return fmt.Sprintf("byte  0x%02x", v)
+	case []byte:
+		return fmt.Sprintf("bytes %s", formatBytes(v))
+	default:
+		return fmt.Sprintf("%T %v", v, v)
+	}
+}
+
+// formatBytes 以十六进制输出字节串，若内容是可打印字符则附带其字符串形式
+func formatBytes(b []byte) string {
+	if b == nil {
+		return "<nil>"
+	}
+	s := "0x" + hex.EncodeToString(b)
+	if len(b) > 0 && isPrintable(b) {
+		s += " " + strconv.Quote(string(b))
+	}
+	return s
+}
+
+func isPrintable(b []byte) bool {
+	for _, c := range b {
+		if c < 0x20 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
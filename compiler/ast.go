@@ -0,0 +1,183 @@
+package compiler
+
+// Program 是一份合约源码的语法树
+// 调用数据的选择器匹配某个函数时执行该函数，否则执行顶层语句 Body
+type Program struct {
+	Storage   []*StorageDecl
+	Events    []*EventDecl
+	Functions []*FuncDecl
+	Body      []Stmt
+}
+
+// StorageDecl 声明一个持久化的存储变量: storage name: type;
+type StorageDecl struct {
+	pos  Pos
+	Name string
+	Type *Type
+}
+
+// EventDecl 声明一个事件: event Name(a: int, b: address);
+type EventDecl struct {
+	pos    Pos
+	Name   string
+	Params []*Param
+}
+
+// FuncDecl 声明一个可以从外部调用的函数: fn name(a: int) -> type { ... }
+type FuncDecl struct {
+	pos    Pos
+	Name   string
+	Params []*Param
+	Result *Type // 可以为 nil，表示没有返回值
+	Body   []Stmt
+}
+
+type Param struct {
+	pos  Pos
+	Name string
+	Type *Type
+
+	slot int // 函数参数所在的局部变量槽
+}
+
+// --- 语句 ---
+
+type Stmt interface {
+	Pos() Pos
+}
+
+// LetStmt 声明一个局部变量: let name[: type] = value;
+type LetStmt struct {
+	pos   Pos
+	Name  string
+	Type  *Type // 可以为 nil，此时由 Value 推导
+	Value Expr
+
+	slot int // 由类型检查器分配的局部变量槽
+}
+
+// AssignStmt 为局部变量、存储变量或存储映射的元素赋值
+type AssignStmt struct {
+	pos    Pos
+	Target Expr // *Ident 或 *IndexExpr
+	Value  Expr
+}
+
+type IfStmt struct {
+	pos  Pos
+	Cond Expr
+	Then []Stmt
+	Else []Stmt // else if 会被表示为只包含一个 *IfStmt 的 Else
+}
+
+type WhileStmt struct {
+	pos  Pos
+	Cond Expr
+	Body []Stmt
+}
+
+// RequireStmt 在条件不成立时以 Msg 回滚: require(cond[, msg]);
+type RequireStmt struct {
+	pos  Pos
+	Cond Expr
+	Msg  Expr // 可以为 nil
+}
+
+// EmitStmt 记录一条事件: emit Name(args...);
+type EmitStmt struct {
+	pos   Pos
+	Event string
+	Args  []Expr
+
+	decl *EventDecl
+}
+
+// ReturnStmt 结束执行并返回一个可选的值
+type ReturnStmt struct {
+	pos   Pos
+	Value Expr // 可以为 nil
+}
+
+func (s *LetStmt) Pos() Pos     { return s.pos }
+func (s *AssignStmt) Pos() Pos  { return s.pos }
+func (s *IfStmt) Pos() Pos      { return s.pos }
+func (s *WhileStmt) Pos() Pos   { return s.pos }
+func (s *RequireStmt) Pos() Pos { return s.pos }
+func (s *EmitStmt) Pos() Pos    { return s.pos }
+func (s *ReturnStmt) Pos() Pos  { return s.pos }
+
+// --- 表达式 ---
+
+type Expr interface {
+	Pos() Pos
+	Type() *Type // 类型检查之后才有值
+}
+
+// typed 为表达式保存类型检查的结果
+type typed struct {
+	pos Pos
+	typ *Type
+}
+
+func (t *typed) Pos() Pos    { return t.pos }
+func (t *typed) Type() *Type { return t.typ }
+
+type IntLit struct {
+	typed
+	Value int64
+}
+
+type BoolLit struct {
+	typed
+	Value bool
+}
+
+// BytesLit 是字符串或十六进制字面量，40 位十六进制字面量的类型为 address
+type BytesLit struct {
+	typed
+	Value []byte
+}
+
+// identKind 表示标识符解析到的对象
+type identKind int
+
+const (
+	identLocal identKind = iota
+	identStorage
+	identBuiltin
+)
+
+type Ident struct {
+	typed
+	Name string
+
+	kind identKind
+	slot int // identLocal 时的局部变量槽
+}
+
+// IndexExpr 访问存储映射中的元素: name[key]
+type IndexExpr struct {
+	typed
+	X     *Ident
+	Index Expr
+}
+
+type UnaryExpr struct {
+	typed
+	Op tokenKind
+	X  Expr
+}
+
+type BinaryExpr struct {
+	typed
+	Op tokenKind
+	X  Expr
+	Y  Expr
+}
+
+// CallExpr 调用内置函数，例如 len(x)
+type CallExpr struct {
+	typed
+	Func string
+	Args []Expr
+}
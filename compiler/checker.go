@@ -0,0 +1,349 @@
+package compiler
+
+const (
+	// maxErrors 是类型检查器最多报告的错误数
+	maxErrors = 10
+	// maxLocals 是用户可用的局部变量槽数量，剩余的槽留给函数分发代码使用
+	maxLocals = 253
+)
+
+// builtins 是可以直接当作变量使用的内置标识符
+var builtins = map[string]*Type{
+	"caller": TypeAddress, // 调用方地址
+	"value":  TypeInt,     // 随调用转入的金额
+	"input":  TypeBytes,   // 调用数据
+}
+
+type scope struct {
+	parent *scope
+	vars   map[string]*Ident // 只用到 typ 和 slot
+}
+
+func (s *scope) lookup(name string) *Ident {
+	for ; s != nil; s = s.parent {
+		if v, ok := s.vars[name]; ok {
+			return v
+		}
+	}
+	return nil
+}
+
+// checker 解析标识符、推导并检查类型，同时为局部变量分配槽位
+type checker struct {
+	storage map[string]*StorageDecl
+	events  map[string]*EventDecl
+	fn      *FuncDecl // 正在检查的函数，检查顶层语句时为 nil
+	scope   *scope
+	slots   int
+	errs    ErrorList
+}
+
+func check(prog *Program) error {
+	c := &checker{
+		storage: make(map[string]*StorageDecl),
+		events:  make(map[string]*EventDecl),
+	}
+
+	for _, decl := range prog.Storage {
+		if _, ok := builtins[decl.Name]; ok {
+			c.errorf(decl.pos, "cannot redeclare builtin '%s'", decl.Name)
+			continue
+		}
+		if _, ok := c.storage[decl.Name]; ok {
+			c.errorf(decl.pos, "storage variable '%s' redeclared", decl.Name)
+			continue
+		}
+		if decl.Type.Kind == KindMap && (decl.Type.Key.Kind == KindMap || decl.Type.Elem.Kind == KindMap) {
+			c.errorf(decl.pos, "nested maps are not supported")
+		}
+		c.storage[decl.Name] = decl
+	}
+	for _, decl := range prog.Events {
+		if _, ok := c.events[decl.Name]; ok {
+			c.errorf(decl.pos, "event '%s' redeclared", decl.Name)
+			continue
+		}
+		for _, param := range decl.Params {
+			if param.Type.Kind == KindMap {
+				c.errorf(param.pos, "event parameter '%s' cannot be a map", param.Name)
+			}
+		}
+		c.events[decl.Name] = decl
+	}
+
+	funcs := make(map[string]bool)
+	for _, decl := range prog.Functions {
+		if funcs[decl.Name] {
+			c.errorf(decl.pos, "function '%s' redeclared", decl.Name)
+			continue
+		}
+		funcs[decl.Name] = true
+		c.checkFunc(decl)
+	}
+
+	c.checkBlock(prog.Body)
+
+	if len(c.errs) > 0 {
+		return c.errs
+	}
+	return nil
+}
+
+func (c *checker) errorf(pos Pos, format string, args ...any) {
+	if len(c.errs) < maxErrors {
+		c.errs = append(c.errs, errorf(pos, format, args...))
+	}
+}
+
+func (c *checker) checkFunc(decl *FuncDecl) {
+	if decl.Result != nil && decl.Result.Kind == KindMap {
+		c.errorf(decl.pos, "function '%s' cannot return a map", decl.Name)
+	}
+
+	c.fn = decl
+	c.scope = &scope{parent: c.scope, vars: make(map[string]*Ident)}
+	for _, param := range decl.Params {
+		if param.Type.Kind == KindMap {
+			c.errorf(param.pos, "parameter '%s' cannot be a map", param.Name)
+		}
+		slot, ok := c.declare(param.pos, param.Name, param.Type)
+		if ok {
+			param.slot = slot
+		}
+	}
+	c.checkBlock(decl.Body)
+	c.scope = c.scope.parent
+	c.fn = nil
+}
+
+// declare 在当前作用域中声明一个局部变量并为其分配槽位
+func (c *checker) declare(pos Pos, name string, typ *Type) (int, bool) {
+	if _, ok := c.scope.vars[name]; ok {
+		c.errorf(pos, "'%s' redeclared in this block", name)
+		return 0, false
+	}
+	if c.slots >= maxLocals {
+		c.errorf(pos, "too many local variables")
+		return 0, false
+	}
+	slot := c.slots
+	c.slots++
+	c.scope.vars[name] = &Ident{typed: typed{typ: typ}, Name: name, kind: identLocal, slot: slot}
+	return slot, true
+}
+
+func (c *checker) checkBlock(stmts []Stmt) {
+	c.scope = &scope{parent: c.scope, vars: make(map[string]*Ident)}
+	for _, stmt := range stmts {
+		c.checkStmt(stmt)
+	}
+	c.scope = c.scope.parent
+}
+
+func (c *checker) checkStmt(stmt Stmt) {
+	switch s := stmt.(type) {
+	case *LetStmt:
+		typ := c.checkExpr(s.Value)
+		if s.Type != nil {
+			if s.Type.Kind == KindMap {
+				c.errorf(s.pos, "local variable '%s' cannot be a map", s.Name)
+			} else if typ != nil && !typ.Equal(s.Type) {
+				c.errorf(s.Value.Pos(), "cannot use %s value as %s in declaration of '%s'", typ, s.Type, s.Name)
+			}
+			typ = s.Type
+		}
+		if slot, ok := c.declare(s.pos, s.Name, typ); ok {
+			s.slot = slot
+		}
+
+	case *AssignStmt:
+		var target *Type
+		switch t := s.Target.(type) {
+		case *Ident:
+			target = c.checkExpr(t)
+			if t.kind == identBuiltin {
+				c.errorf(t.pos, "cannot assign to builtin '%s'", t.Name)
+			}
+			if target != nil && target.Kind == KindMap {
+				c.errorf(t.pos, "cannot assign to map '%s' as a whole", t.Name)
+				target = nil
+			}
+		case *IndexExpr:
+			target = c.checkExpr(t)
+		default:
+			c.errorf(s.Target.Pos(), "cannot assign to this expression")
+		}
+		value := c.checkExpr(s.Value)
+		if target != nil && value != nil && !target.Equal(value) {
+			c.errorf(s.Value.Pos(), "cannot assign %s value to %s", value, target)
+		}
+
+	case *IfStmt:
+		c.expectType(s.Cond, TypeBool, "if condition")
+		c.checkBlock(s.Then)
+		if s.Else != nil {
+			c.checkBlock(s.Else)
+		}
+
+	case *WhileStmt:
+		c.expectType(s.Cond, TypeBool, "while condition")
+		c.checkBlock(s.Body)
+
+	case *RequireStmt:
+		c.expectType(s.Cond, TypeBool, "require condition")
+		if s.Msg != nil {
+			c.expectType(s.Msg, TypeBytes, "require message")
+		}
+
+	case *EmitStmt:
+		decl, ok := c.events[s.Event]
+		if !ok {
+			c.errorf(s.pos, "undefined event '%s'", s.Event)
+			for _, arg := range s.Args {
+				c.checkExpr(arg)
+			}
+			return
+		}
+		s.decl = decl
+		if len(s.Args) != len(decl.Params) {
+			c.errorf(s.pos, "event '%s' expects %d arguments, got %d", s.Event, len(decl.Params), len(s.Args))
+		}
+		for i, arg := range s.Args {
+			typ := c.checkExpr(arg)
+			if i < len(decl.Params) && typ != nil && !typ.Equal(decl.Params[i].Type) {
+				c.errorf(arg.Pos(), "cannot use %s value as %s argument '%s'", typ, decl.Params[i].Type, decl.Params[i].Name)
+			}
+		}
+
+	case *ReturnStmt:
+		var typ *Type
+		if s.Value != nil {
+			typ = c.checkExpr(s.Value)
+			if typ != nil && typ.Kind == KindMap {
+				c.errorf(s.Value.Pos(), "cannot return a map")
+				return
+			}
+		}
+		if c.fn == nil {
+			// 顶层语句可以返回任意类型的值
+			return
+		}
+		switch {
+		case c.fn.Result == nil && s.Value != nil:
+			c.errorf(s.Value.Pos(), "function '%s' does not return a value", c.fn.Name)
+		case c.fn.Result != nil && s.Value == nil:
+			c.errorf(s.pos, "function '%s' must return a %s value", c.fn.Name, c.fn.Result)
+		case c.fn.Result != nil && typ != nil && !typ.Equal(c.fn.Result):
+			c.errorf(s.Value.Pos(), "cannot return %s value from function '%s' returning %s", typ, c.fn.Name, c.fn.Result)
+		}
+	}
+}
+
+func (c *checker) expectType(e Expr, want *Type, what string) {
+	if typ := c.checkExpr(e); typ != nil && !typ.Equal(want) {
+		c.errorf(e.Pos(), "%s must be %s, found %s", what, want, typ)
+	}
+}
+
+// checkExpr 检查表达式并返回其类型，出错时返回 nil 以避免级联报错
+func (c *checker) checkExpr(e Expr) *Type {
+	switch x := e.(type) {
+	case *IntLit:
+		x.typ = TypeInt
+	case *BoolLit:
+		x.typ = TypeBool
+	case *BytesLit:
+		if len(x.Value) > 255 {
+			c.errorf(x.pos, "bytes literal longer than 255 bytes")
+		}
+		if x.typ == nil {
+			x.typ = TypeBytes
+		}
+
+	case *Ident:
+		if v := c.scope.lookup(x.Name); v != nil {
+			x.kind, x.slot, x.typ = identLocal, v.slot, v.typ
+		} else if decl, ok := c.storage[x.Name]; ok {
+			x.kind, x.typ = identStorage, decl.Type
+		} else if typ, ok := builtins[x.Name]; ok {
+			x.kind, x.typ = identBuiltin, typ
+		} else {
+			c.errorf(x.pos, "undefined: %s", x.Name)
+		}
+
+	case *IndexExpr:
+		m := c.checkExpr(x.X)
+		key := c.checkExpr(x.Index)
+		if m == nil {
+			return nil
+		}
+		if m.Kind != KindMap {
+			c.errorf(x.pos, "cannot index '%s' of type %s", x.X.Name, m)
+			return nil
+		}
+		if key != nil && !key.Equal(m.Key) {
+			c.errorf(x.Index.Pos(), "cannot use %s value as key of %s", key, m)
+		}
+		x.typ = m.Elem
+
+	case *UnaryExpr:
+		switch x.Op {
+		case tokNot:
+			c.expectType(x.X, TypeBool, "operand of '!'")
+			x.typ = TypeBool
+		case tokMinus:
+			c.expectType(x.X, TypeInt, "operand of '-'")
+			x.typ = TypeInt
+		}
+
+	case *BinaryExpr:
+		lt := c.checkExpr(x.X)
+		rt := c.checkExpr(x.Y)
+		switch x.Op {
+		case tokPlus, tokMinus, tokStar, tokSlash, tokPercent:
+			c.expectOperands(x, lt, rt, TypeInt)
+			x.typ = TypeInt
+		case tokLt, tokLe, tokGt, tokGe:
+			c.expectOperands(x, lt, rt, TypeInt)
+			x.typ = TypeBool
+		case tokAnd, tokOr:
+			c.expectOperands(x, lt, rt, TypeBool)
+			x.typ = TypeBool
+		case tokEq, tokNe:
+			if lt != nil && rt != nil {
+				if !lt.Equal(rt) {
+					c.errorf(x.pos, "mismatched types %s and %s in comparison", lt, rt)
+				} else if lt.Kind == KindMap {
+					c.errorf(x.pos, "maps cannot be compared")
+				}
+			}
+			x.typ = TypeBool
+		}
+
+	case *CallExpr:
+		for _, arg := range x.Args {
+			c.checkExpr(arg)
+		}
+		switch x.Func {
+		case "len":
+			if len(x.Args) != 1 {
+				c.errorf(x.pos, "len expects 1 argument, got %d", len(x.Args))
+			} else if typ := x.Args[0].Type(); typ != nil && typ.Kind != KindBytes {
+				c.errorf(x.Args[0].Pos(), "len expects bytes, found %s", typ)
+			}
+			x.typ = TypeInt
+		default:
+			c.errorf(x.pos, "undefined function: %s", x.Func)
+		}
+	}
+	return e.Type()
+}
+
+func (c *checker) expectOperands(x *BinaryExpr, lt, rt, want *Type) {
+	if lt != nil && !lt.Equal(want) {
+		c.errorf(x.X.Pos(), "operator %s expects %s operands, found %s", x.Op, want, lt)
+	}
+	if rt != nil && !rt.Equal(want) {
+		c.errorf(x.Y.Pos(), "operator %s expects %s operands, found %s", x.Op, want, rt)
+	}
+}
@@ -0,0 +1,359 @@
+package compiler
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// 函数分发代码占用的局部变量槽，用户变量只能使用 [0, maxLocals)
+const (
+	slotEnd      = 253 // 正在解码的 bytes 参数的结束偏移
+	slotOffset   = 254 // 调用数据的当前解码偏移
+	slotSelector = 255 // 调用数据中的函数选择器
+)
+
+// codegen 把通过类型检查的语法树翻译为 asm 包的汇编文本
+//
+// 值在栈上的表示：int 和 bool 为整数，bytes 和 address 为字节串。
+// 存储变量 x 的键为 "x"，映射元素 m[k] 的键为 "m:" 拼接 k 的编码，
+// 整数在存储和事件数据中统一编码为 8 字节小端序。
+// 声明了函数时，程序开头是按选择器跳转的分发代码，未匹配时执行顶层语句。
+type codegen struct {
+	sb     strings.Builder
+	labels int
+}
+
+func generate(prog *Program) string {
+	g := &codegen{}
+	if len(prog.Functions) == 0 {
+		g.stmts(prog.Body)
+		return g.sb.String()
+	}
+
+	g.dispatch(prog.Functions)
+	g.label("fallback")
+	if len(prog.Body) == 0 {
+		g.pushBytes([]byte("unknown function"))
+		g.emit("revert")
+	} else {
+		g.stmts(prog.Body)
+		g.pushBytes(nil)
+		g.emit("return")
+	}
+	for _, fn := range prog.Functions {
+		g.function(fn)
+	}
+	return g.sb.String()
+}
+
+// dispatch 比较调用数据的前 4 字节与各函数的选择器，跳转到匹配的函数
+func (g *codegen) dispatch(funcs []*FuncDecl) {
+	g.emit("input")
+	g.emit("len")
+	g.emit("push 4")
+	g.emit("lt")
+	g.emit("jumpi fallback")
+	g.emit("input")
+	g.emit("push 0")
+	g.emit("push 4")
+	g.emit("slice")
+	g.emit("lstore %d", slotSelector)
+	for _, fn := range funcs {
+		g.emit("lload %d", slotSelector)
+		g.pushBytes(funcABI(fn).Selector())
+		g.emit("eq")
+		g.emit("jumpi fn_%s", fn.Name)
+	}
+	g.emit("jump fallback")
+}
+
+// function 生成函数体，入口处按 ABI 编码从调用数据中解码参数
+func (g *codegen) function(fn *FuncDecl) {
+	g.label("fn_" + fn.Name)
+	g.emit("push 4")
+	g.emit("lstore %d", slotOffset)
+	for _, param := range fn.Params {
+		switch param.Type.Kind {
+		case KindInt, KindBool:
+			g.readInput(8)
+			g.emit("toint")
+			if param.Type.Kind == KindBool {
+				g.emit("push 0")
+				g.emit("eq")
+				g.emit("not")
+			}
+			g.emit("lstore %d", param.slot)
+			g.advance(8)
+		case KindAddress:
+			g.readInput(20)
+			g.emit("lstore %d", param.slot)
+			g.advance(20)
+		case KindBytes:
+			// 先读出 8 字节的长度，算出内容的结束偏移
+			g.readInput(8)
+			g.emit("toint")
+			g.emit("lload %d", slotOffset)
+			g.emit("add")
+			g.emit("push 8")
+			g.emit("add")
+			g.emit("lstore %d", slotEnd)
+			g.advance(8)
+			g.emit("input")
+			g.emit("lload %d", slotOffset)
+			g.emit("lload %d", slotEnd)
+			g.emit("slice")
+			g.emit("lstore %d", param.slot)
+			g.emit("lload %d", slotEnd)
+			g.emit("lstore %d", slotOffset)
+		}
+	}
+
+	g.stmts(fn.Body)
+	if fn.Result != nil {
+		g.pushBytes([]byte("missing return"))
+		g.emit("revert")
+	} else {
+		g.pushBytes(nil)
+		g.emit("return")
+	}
+}
+
+// readInput 压入调用数据中从当前偏移开始的 n 个字节
+func (g *codegen) readInput(n int) {
+	g.emit("input")
+	g.emit("lload %d", slotOffset)
+	g.emit("lload %d", slotOffset)
+	g.emit("push %d", n)
+	g.emit("add")
+	g.emit("slice")
+}
+
+// advance 把当前解码偏移后移 n 个字节
+func (g *codegen) advance(n int) {
+	g.emit("lload %d", slotOffset)
+	g.emit("push %d", n)
+	g.emit("add")
+	g.emit("lstore %d", slotOffset)
+}
+
+func (g *codegen) emit(format string, args ...any) {
+	fmt.Fprintf(&g.sb, "    "+format+"\n", args...)
+}
+
+func (g *codegen) label(name string) {
+	fmt.Fprintf(&g.sb, "%s:\n", name)
+}
+
+func (g *codegen) newLabel(prefix string) string {
+	g.labels++
+	return fmt.Sprintf("%s_%d", prefix, g.labels)
+}
+
+func (g *codegen) pushBytes(b []byte) {
+	g.emit(".bytes 0x%s", hex.EncodeToString(b))
+}
+
+func (g *codegen) stmts(stmts []Stmt) {
+	for _, stmt := range stmts {
+		g.stmt(stmt)
+	}
+}
+
+func (g *codegen) stmt(stmt Stmt) {
+	switch s := stmt.(type) {
+	case *LetStmt:
+		g.expr(s.Value)
+		g.emit("lstore %d", s.slot)
+
+	case *AssignStmt:
+		switch t := s.Target.(type) {
+		case *Ident:
+			if t.kind == identLocal {
+				g.expr(s.Value)
+				g.emit("lstore %d", t.slot)
+				return
+			}
+			g.pushBytes([]byte(t.Name))
+		case *IndexExpr:
+			g.mapKey(t)
+		}
+		g.expr(s.Value)
+		g.emit("store")
+
+	case *IfStmt:
+		elseLabel, endLabel := g.newLabel("else"), g.newLabel("endif")
+		g.expr(s.Cond)
+		g.emit("not")
+		g.emit("jumpi %s", elseLabel)
+		g.stmts(s.Then)
+		g.emit("jump %s", endLabel)
+		g.label(elseLabel)
+		g.stmts(s.Else)
+		g.label(endLabel)
+
+	case *WhileStmt:
+		loopLabel, endLabel := g.newLabel("while"), g.newLabel("endwhile")
+		g.label(loopLabel)
+		g.expr(s.Cond)
+		g.emit("not")
+		g.emit("jumpi %s", endLabel)
+		g.stmts(s.Body)
+		g.emit("jump %s", loopLabel)
+		g.label(endLabel)
+
+	case *RequireStmt:
+		okLabel := g.newLabel("require_ok")
+		g.expr(s.Cond)
+		g.emit("jumpi %s", okLabel)
+		if s.Msg != nil {
+			g.expr(s.Msg)
+		} else {
+			g.pushBytes(nil)
+		}
+		g.emit("revert")
+		g.label(okLabel)
+
+	case *EmitStmt:
+		g.pushBytes(eventABI(s.decl).Topic())
+		g.pushBytes(nil)
+		for _, arg := range s.Args {
+			g.expr(arg)
+			g.encode(arg.Type(), true)
+			g.emit("concat")
+		}
+		g.emit("log")
+
+	case *ReturnStmt:
+		if s.Value != nil {
+			g.expr(s.Value)
+			g.encode(s.Value.Type(), true)
+		} else {
+			g.pushBytes(nil)
+		}
+		g.emit("return")
+	}
+}
+
+// encode 把栈顶的值转换为字节串。lengthPrefix 为真时，
+// bytes 类型的值前面会加上 8 字节的长度，以便拼接后仍能被解码
+func (g *codegen) encode(typ *Type, lengthPrefix bool) {
+	switch typ.Kind {
+	case KindInt, KindBool:
+		g.emit("tobytes")
+	case KindBytes:
+		if lengthPrefix {
+			g.emit("dup")
+			g.emit("len")
+			g.emit("tobytes")
+			g.emit("swap")
+			g.emit("concat")
+		}
+	}
+}
+
+// mapKey 压入映射元素 m[k] 在存储中的键
+func (g *codegen) mapKey(x *IndexExpr) {
+	g.pushBytes([]byte(x.X.Name + ":"))
+	g.expr(x.Index)
+	g.encode(x.Index.Type(), false)
+	g.emit("concat")
+}
+
+// load 在存储键已经压栈的情况下读取值，并转换为类型 typ 在栈上的表示
+func (g *codegen) load(typ *Type) {
+	g.emit("load")
+	if typ.Kind == KindInt || typ.Kind == KindBool {
+		g.emit("toint")
+	}
+}
+
+func (g *codegen) expr(e Expr) {
+	switch x := e.(type) {
+	case *IntLit:
+		if x.Value >= 0 && x.Value <= 255 {
+			g.emit("push %d", x.Value)
+		} else {
+			g.emit("push8 %d", x.Value)
+		}
+	case *BoolLit:
+		if x.Value {
+			g.emit("push 1")
+		} else {
+			g.emit("push 0")
+		}
+	case *BytesLit:
+		g.pushBytes(x.Value)
+
+	case *Ident:
+		switch x.kind {
+		case identLocal:
+			g.emit("lload %d", x.slot)
+		case identStorage:
+			g.pushBytes([]byte(x.Name))
+			g.load(x.typ)
+		case identBuiltin:
+			switch x.Name {
+			case "caller":
+				g.emit("caller")
+			case "value":
+				g.emit("callvalue")
+			case "input":
+				g.emit("input")
+			}
+		}
+
+	case *IndexExpr:
+		g.mapKey(x)
+		g.load(x.typ)
+
+	case *UnaryExpr:
+		if x.Op == tokMinus {
+			g.emit("push 0")
+			g.expr(x.X)
+			g.emit("sub")
+			return
+		}
+		g.expr(x.X)
+		g.emit("not")
+
+	case *BinaryExpr:
+		g.expr(x.X)
+		g.expr(x.Y)
+		switch x.Op {
+		case tokPlus:
+			g.emit("add")
+		case tokMinus:
+			g.emit("sub")
+		case tokStar:
+			g.emit("mul")
+		case tokSlash:
+			g.emit("div")
+		case tokPercent:
+			g.emit("mod")
+		case tokEq:
+			g.emit("eq")
+		case tokNe:
+			g.emit("eq")
+			g.emit("not")
+		case tokLt:
+			g.emit("lt")
+		case tokLe:
+			g.emit("gt")
+			g.emit("not")
+		case tokGt:
+			g.emit("gt")
+		case tokGe:
+			g.emit("lt")
+			g.emit("not")
+		case tokAnd:
+			g.emit("and")
+		case tokOr:
+			g.emit("or")
+		}
+
+	case *CallExpr:
+		g.expr(x.Args[0])
+		g.emit("len")
+	}
+}
@@ -0,0 +1,113 @@
+// Package compiler 把一门小型的强类型合约语言编译为 core.VM 字节码。
+//
+// 一份源码由存储变量声明、事件声明、函数声明和顶层语句组成：
+//
+//	storage owner: address;
+//	storage balances: map[address]int;
+//	event Deposit(who: address, amount: int);
+//
+//	require(value > 0, "nothing to deposit");
+//	balances[caller] = balances[caller] + value;
+//	emit Deposit(caller, value);
+//
+//	fn balanceOf(who: address) -> int {
+//	    return balances[who];
+//	}
+//
+// 调用数据以某个函数的选择器开头时执行该函数，参数按 abi 包的规则从调用数据中解码，
+// 否则从头执行顶层语句。return 的值同样按 abi 规则编码为返回数据，
+// emit 记录的事件以事件签名的 topic 标识。编译结果中附带合约的 ABI 描述。
+//
+// 支持的类型有 int、bool、bytes、address，以及只能用于存储变量的 map[K]V。
+// 语句包括 let、赋值、if/else、while、require、emit 和 return；
+// 内置标识符 caller、value、input 分别表示调用方、转入金额和调用数据。
+package compiler
+
+import (
+	"fmt"
+	"github.com/virtue186/xchain/abi"
+	"github.com/virtue186/xchain/asm"
+	"strings"
+)
+
+// Error 是一条带有源码位置的编译错误
+type Error struct {
+	Pos Pos
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Msg)
+}
+
+func errorf(pos Pos, format string, args ...any) *Error {
+	return &Error{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// ErrorList 汇总了类型检查阶段发现的多条错误
+type ErrorList []*Error
+
+func (l ErrorList) Error() string {
+	msgs := make([]string, len(l))
+	for i, e := range l {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Result 是一次成功编译的产物
+type Result struct {
+	Code []byte   // 可直接部署的字节码
+	Asm  string   // 生成字节码所用的汇编，便于调试
+	ABI  *abi.ABI // 合约的函数和事件描述
+}
+
+// Compile 编译合约源码。返回的错误是 *Error 或 ErrorList
+func Compile(src string) (*Result, error) {
+	prog, err := parse(src)
+	if err != nil {
+		return nil, err
+	}
+	if err := check(prog); err != nil {
+		return nil, err
+	}
+
+	text := generate(prog)
+	code, err := asm.Assemble(text)
+	if err != nil {
+		// 生成的汇编无法汇编说明编译器自身有缺陷，例如程序超出了跳转范围
+		return nil, fmt.Errorf("internal error: %w", err)
+	}
+	return &Result{Code: code, Asm: text, ABI: buildABI(prog)}, nil
+}
+
+func buildABI(prog *Program) *abi.ABI {
+	a := &abi.ABI{Functions: []abi.Function{}, Events: []abi.Event{}}
+	for _, fn := range prog.Functions {
+		a.Functions = append(a.Functions, *funcABI(fn))
+	}
+	for _, ev := range prog.Events {
+		a.Events = append(a.Events, *eventABI(ev))
+	}
+	return a
+}
+
+func abiArgs(params []*Param) []abi.Argument {
+	args := make([]abi.Argument, len(params))
+	for i, p := range params {
+		args[i] = abi.Argument{Name: p.Name, Type: p.Type.String()}
+	}
+	return args
+}
+
+func funcABI(fn *FuncDecl) *abi.Function {
+	f := &abi.Function{Name: fn.Name, Inputs: abiArgs(fn.Params), Outputs: []abi.Argument{}}
+	if fn.Result != nil {
+		f.Outputs = append(f.Outputs, abi.Argument{Type: fn.Result.String()})
+	}
+	return f
+}
+
+func eventABI(ev *EventDecl) *abi.Event {
+	return &abi.Event{Name: ev.Name, Inputs: abiArgs(ev.Params)}
+}
@@ -0,0 +1,159 @@
+package compiler
+
+import (
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"github.com/virtue186/xchain/abi"
+	"github.com/virtue186/xchain/core"
+	"github.com/virtue186/xchain/types"
+	"testing"
+)
+
+func run(t *testing.T, src string, contract core.Contract) (*core.VM, *core.StateCache, error) {
+	res, err := Compile(src)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	state := core.NewStateCache(nil)
+	vm := core.NewContractVm(res.Code, state, contract, core.DefaultGasLimit)
+	return vm, state, vm.Run()
+}
+
+func decodeInt(b []byte) int64 {
+	return int64(binary.LittleEndian.Uint64(b))
+}
+
+func TestCompileArithmeticAndLoops(t *testing.T) {
+	src := `
+storage total: int;
+
+// 计算 1 + 2 + ... + 10
+let i = 1;
+let sum = 0;
+while i <= 10 {
+    sum = sum + i;
+    i = i + 1;
+}
+if sum == 55 && !(sum < 0) {
+    total = sum * 1000;
+} else {
+    total = -1;
+}
+return total;
+`
+	vm, state, err := run(t, src, core.Contract{})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(55000), decodeInt(vm.ReturnData()))
+
+	v, _ := state.GetStorage(types.Address{}, []byte("total"))
+	assert.Equal(t, int64(55000), decodeInt(v))
+}
+
+func TestCompileMapsRequireAndEvents(t *testing.T) {
+	src := `
+storage balances: map[address]int;
+event Deposit(who: address, amount: int, memo: bytes);
+
+require(value > 0, "nothing to deposit");
+balances[caller] = balances[caller] + value;
+emit Deposit(caller, value, "hi");
+`
+	caller := types.Address{7}
+	vm, state, err := run(t, src, core.Contract{Caller: caller, Value: 42})
+	assert.Nil(t, err)
+
+	key := append([]byte("balances:"), caller.ToSlice()...)
+	v, _ := state.GetStorage(types.Address{}, key)
+	assert.Equal(t, int64(42), decodeInt(v))
+
+	logs := vm.Logs()
+	assert.Len(t, logs, 1)
+	assert.Equal(t, abi.Selector("Deposit(address,int,bytes)"), logs[0].Topic)
+	data := logs[0].Data
+	assert.Equal(t, caller.ToSlice(), data[:20])
+	assert.Equal(t, int64(42), decodeInt(data[20:28]))
+	assert.Equal(t, int64(2), decodeInt(data[28:36]))
+	assert.Equal(t, []byte("hi"), data[36:])
+
+	vm, _, err = run(t, src, core.Contract{Caller: caller})
+	assert.ErrorIs(t, err, core.ErrExecutionReverted)
+	assert.Equal(t, []byte("nothing to deposit"), vm.ReturnData())
+}
+
+func TestCompileFunctions(t *testing.T) {
+	src := `
+storage names: map[address]bytes;
+
+fn setName(who: address, name: bytes, force: bool) {
+    require(force || len(names[who]) == 0, "name already set");
+    names[who] = name;
+}
+
+fn nameOf(who: address) -> bytes {
+    return names[who];
+}
+
+fn add(a: int, b: int) -> int {
+    return a + b;
+}
+`
+	res, err := Compile(src)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Len(t, res.ABI.Functions, 3)
+
+	state := core.NewStateCache(nil)
+	call := func(name string, args ...any) ([]any, error) {
+		input, err := res.ABI.EncodeCall(name, args...)
+		assert.Nil(t, err)
+		vm := core.NewContractVm(res.Code, state, core.Contract{Input: input}, core.DefaultGasLimit)
+		if err := vm.Run(); err != nil {
+			return nil, err
+		}
+		fn, _ := res.ABI.Function(name)
+		return fn.DecodeOutputs(vm.ReturnData())
+	}
+
+	who := types.Address{9}
+	out, err := call("add", int64(40), int64(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []any{int64(42)}, out)
+
+	_, err = call("setName", who, []byte("alice"), false)
+	assert.Nil(t, err)
+	_, err = call("setName", who, []byte("bob"), false)
+	assert.ErrorIs(t, err, core.ErrExecutionReverted)
+
+	out, err = call("nameOf", who)
+	assert.Nil(t, err)
+	assert.Equal(t, []any{[]byte("alice")}, out)
+
+	// 没有顶层语句时，未知的选择器会回滚
+	vm := core.NewContractVm(res.Code, state, core.Contract{Input: []byte{1, 2, 3, 4}}, core.DefaultGasLimit)
+	assert.ErrorIs(t, vm.Run(), core.ErrExecutionReverted)
+	assert.Equal(t, []byte("unknown function"), vm.ReturnData())
+}
+
+func TestCompileErrors(t *testing.T) {
+	cases := []struct {
+		src string
+		err string
+	}{
+		{"let x = 1\nlet y = 2;", "2:1: expected ';', found 'let'"},
+		{"let s = \"abc;", "1:9: unterminated string literal"},
+		{"let x = 1;\nx = true;", "2:5: cannot assign bool value to int"},
+		{"if 1 { }", "1:4: if condition must be bool, found int"},
+		{"storage m: map[address]int;\nlet v = m[1];", "2:11: cannot use int value as key of map[address]int"},
+		{"event E(a: int);\nemit E(true);", "2:8: cannot use bool value as int argument 'a'"},
+		{"let a = b + c;", "1:9: undefined: b\n1:13: undefined: c"},
+		{"caller = 0x0000000000000000000000000000000000000001;", "1:1: cannot assign to builtin 'caller'"},
+		{"fn f() -> int {\n  return true;\n}", "2:10: cannot return bool value from function 'f' returning int"},
+		{"fn f() { return 1; }", "1:17: function 'f' does not return a value"},
+		{"fn f() { }\nfn f() { }", "2:1: function 'f' redeclared"},
+	}
+	for _, c := range cases {
+		_, err := Compile(c.src)
+		assert.EqualError(t, err, c.err, c.src)
+	}
+}
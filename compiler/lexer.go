@@ -0,0 +1,160 @@
+package compiler
+
+import (
+	"strings"
+	"unicode"
+)
+
+// lexer 把源码切分为 token 序列
+type lexer struct {
+	src  []rune
+	off  int
+	line int
+	col  int
+}
+
+func newLexer(src string) *lexer {
+	return &lexer{src: []rune(src), line: 1, col: 1}
+}
+
+// tokenize 返回源码中全部的 token，最后一个总是 tokEOF
+func (l *lexer) tokenize() ([]token, error) {
+	var toks []token
+	for {
+		tok, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		toks = append(toks, tok)
+		if tok.kind == tokEOF {
+			return toks, nil
+		}
+	}
+}
+
+func (l *lexer) peek(n int) rune {
+	if l.off+n >= len(l.src) {
+		return 0
+	}
+	return l.src[l.off+n]
+}
+
+func (l *lexer) advance() rune {
+	r := l.src[l.off]
+	l.off++
+	if r == '\n' {
+		l.line++
+		l.col = 1
+	} else {
+		l.col++
+	}
+	return r
+}
+
+func (l *lexer) skipSpaceAndComments() {
+	for l.off < len(l.src) {
+		r := l.peek(0)
+		switch {
+		case unicode.IsSpace(r):
+			l.advance()
+		case r == '/' && l.peek(1) == '/':
+			for l.off < len(l.src) && l.peek(0) != '\n' {
+				l.advance()
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (l *lexer) next() (token, error) {
+	l.skipSpaceAndComments()
+	pos := Pos{Line: l.line, Col: l.col}
+	if l.off >= len(l.src) {
+		return token{kind: tokEOF, pos: pos}, nil
+	}
+
+	r := l.peek(0)
+	switch {
+	case r == '_' || unicode.IsLetter(r):
+		start := l.off
+		for l.off < len(l.src) && (l.peek(0) == '_' || unicode.IsLetter(l.peek(0)) || unicode.IsDigit(l.peek(0))) {
+			l.advance()
+		}
+		text := string(l.src[start:l.off])
+		if kind, ok := keywords[text]; ok {
+			return token{kind: kind, text: text, pos: pos}, nil
+		}
+		return token{kind: tokIdent, text: text, pos: pos}, nil
+
+	case r == '0' && (l.peek(1) == 'x' || l.peek(1) == 'X'):
+		l.advance()
+		l.advance()
+		start := l.off
+		for l.off < len(l.src) && strings.ContainsRune("0123456789abcdefABCDEF", l.peek(0)) {
+			l.advance()
+		}
+		text := string(l.src[start:l.off])
+		if len(text)%2 != 0 {
+			return token{}, errorf(pos, "hex literal must have an even number of digits")
+		}
+		return token{kind: tokHex, text: text, pos: pos}, nil
+
+	case unicode.IsDigit(r):
+		start := l.off
+		for l.off < len(l.src) && unicode.IsDigit(l.peek(0)) {
+			l.advance()
+		}
+		return token{kind: tokInt, text: string(l.src[start:l.off]), pos: pos}, nil
+
+	case r == '"':
+		l.advance()
+		sb := new(strings.Builder)
+		for {
+			if l.off >= len(l.src) || l.peek(0) == '\n' {
+				return token{}, errorf(pos, "unterminated string literal")
+			}
+			c := l.advance()
+			if c == '"' {
+				break
+			}
+			if c == '\\' {
+				if l.off >= len(l.src) {
+					return token{}, errorf(pos, "unterminated string literal")
+				}
+				escPos := Pos{Line: l.line, Col: l.col - 1}
+				switch e := l.advance(); e {
+				case 'n':
+					c = '\n'
+				case 't':
+					c = '\t'
+				case '\\', '"':
+					c = e
+				default:
+					return token{}, errorf(escPos, "unknown escape sequence \\%c", e)
+				}
+			}
+			sb.WriteRune(c)
+		}
+		return token{kind: tokString, text: sb.String(), pos: pos}, nil
+	}
+
+	// 运算符：先尝试两个字符的形式
+	two := map[string]tokenKind{"==": tokEq, "!=": tokNe, "<=": tokLe, ">=": tokGe, "&&": tokAnd, "||": tokOr, "->": tokArrow}
+	if kind, ok := two[string([]rune{r, l.peek(1)})]; ok {
+		l.advance()
+		l.advance()
+		return token{kind: kind, pos: pos}, nil
+	}
+	one := map[rune]tokenKind{
+		'+': tokPlus, '-': tokMinus, '*': tokStar, '/': tokSlash, '%': tokPercent,
+		'<': tokLt, '>': tokGt, '!': tokNot, '=': tokAssign,
+		'(': tokLParen, ')': tokRParen, '{': tokLBrace, '}': tokRBrace,
+		'[': tokLBracket, ']': tokRBracket, ',': tokComma, ';': tokSemi, ':': tokColon,
+	}
+	if kind, ok := one[r]; ok {
+		l.advance()
+		return token{kind: kind, pos: pos}, nil
+	}
+	return token{}, errorf(pos, "unexpected character %q", r)
+}
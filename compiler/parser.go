@@ -0,0 +1,489 @@
+package compiler
+
+import (
+	"encoding/hex"
+	"strconv"
+)
+
+// parser 是一个递归下降语法分析器，遇到第一个语法错误即停止
+type parser struct {
+	toks []token
+	pos  int
+}
+
+func parse(src string) (*Program, error) {
+	toks, err := newLexer(src).tokenize()
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	return p.parseProgram()
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.toks[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) accept(kind tokenKind) bool {
+	if p.peek().kind == kind {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(kind tokenKind) (token, error) {
+	tok := p.next()
+	if tok.kind != kind {
+		return tok, errorf(tok.pos, "expected %s, found %s", kind, describe(tok))
+	}
+	return tok, nil
+}
+
+func describe(tok token) string {
+	if tok.kind == tokIdent {
+		return "'" + tok.text + "'"
+	}
+	return tok.kind.String()
+}
+
+func (p *parser) parseProgram() (*Program, error) {
+	prog := &Program{}
+	for p.peek().kind != tokEOF {
+		switch p.peek().kind {
+		case tokStorage:
+			decl, err := p.parseStorage()
+			if err != nil {
+				return nil, err
+			}
+			prog.Storage = append(prog.Storage, decl)
+		case tokEvent:
+			decl, err := p.parseEvent()
+			if err != nil {
+				return nil, err
+			}
+			prog.Events = append(prog.Events, decl)
+		case tokFn:
+			decl, err := p.parseFunc()
+			if err != nil {
+				return nil, err
+			}
+			prog.Functions = append(prog.Functions, decl)
+		default:
+			stmt, err := p.parseStmt()
+			if err != nil {
+				return nil, err
+			}
+			prog.Body = append(prog.Body, stmt)
+		}
+	}
+	return prog, nil
+}
+
+func (p *parser) parseStorage() (*StorageDecl, error) {
+	kw := p.next()
+	name, err := p.expect(tokIdent)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(tokColon); err != nil {
+		return nil, err
+	}
+	typ, err := p.parseType()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(tokSemi); err != nil {
+		return nil, err
+	}
+	return &StorageDecl{pos: kw.pos, Name: name.text, Type: typ}, nil
+}
+
+func (p *parser) parseEvent() (*EventDecl, error) {
+	kw := p.next()
+	name, err := p.expect(tokIdent)
+	if err != nil {
+		return nil, err
+	}
+	params, err := p.parseParams()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(tokSemi); err != nil {
+		return nil, err
+	}
+	return &EventDecl{pos: kw.pos, Name: name.text, Params: params}, nil
+}
+
+func (p *parser) parseFunc() (*FuncDecl, error) {
+	kw := p.next()
+	name, err := p.expect(tokIdent)
+	if err != nil {
+		return nil, err
+	}
+	decl := &FuncDecl{pos: kw.pos, Name: name.text}
+	if decl.Params, err = p.parseParams(); err != nil {
+		return nil, err
+	}
+	if p.accept(tokArrow) {
+		if decl.Result, err = p.parseType(); err != nil {
+			return nil, err
+		}
+	}
+	if decl.Body, err = p.parseBlock(); err != nil {
+		return nil, err
+	}
+	return decl, nil
+}
+
+// parseParams 解析 "(" [name: type {"," name: type}] ")"
+func (p *parser) parseParams() ([]*Param, error) {
+	if _, err := p.expect(tokLParen); err != nil {
+		return nil, err
+	}
+	var params []*Param
+	for p.peek().kind != tokRParen {
+		if len(params) > 0 {
+			if _, err := p.expect(tokComma); err != nil {
+				return nil, err
+			}
+		}
+		name, err := p.expect(tokIdent)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokColon); err != nil {
+			return nil, err
+		}
+		typ, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+		params = append(params, &Param{pos: name.pos, Name: name.text, Type: typ})
+	}
+	p.next()
+	return params, nil
+}
+
+func (p *parser) parseType() (*Type, error) {
+	tok := p.next()
+	switch {
+	case tok.kind == tokMap:
+		if _, err := p.expect(tokLBracket); err != nil {
+			return nil, err
+		}
+		key, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokRBracket); err != nil {
+			return nil, err
+		}
+		elem, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+		return MapOf(key, elem), nil
+	case tok.kind == tokIdent:
+		switch tok.text {
+		case "int":
+			return TypeInt, nil
+		case "bool":
+			return TypeBool, nil
+		case "bytes":
+			return TypeBytes, nil
+		case "address":
+			return TypeAddress, nil
+		}
+		return nil, errorf(tok.pos, "unknown type '%s'", tok.text)
+	}
+	return nil, errorf(tok.pos, "expected type, found %s", describe(tok))
+}
+
+func (p *parser) parseBlock() ([]Stmt, error) {
+	if _, err := p.expect(tokLBrace); err != nil {
+		return nil, err
+	}
+	stmts := []Stmt{}
+	for p.peek().kind != tokRBrace {
+		if p.peek().kind == tokEOF {
+			return nil, errorf(p.peek().pos, "expected '}', found end of file")
+		}
+		stmt, err := p.parseStmt()
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, stmt)
+	}
+	p.next()
+	return stmts, nil
+}
+
+func (p *parser) parseStmt() (Stmt, error) {
+	tok := p.peek()
+	switch tok.kind {
+	case tokLet:
+		return p.parseLet()
+	case tokIf:
+		return p.parseIf()
+	case tokWhile:
+		p.next()
+		cond, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		body, err := p.parseBlock()
+		if err != nil {
+			return nil, err
+		}
+		return &WhileStmt{pos: tok.pos, Cond: cond, Body: body}, nil
+	case tokRequire:
+		p.next()
+		if _, err := p.expect(tokLParen); err != nil {
+			return nil, err
+		}
+		stmt := &RequireStmt{pos: tok.pos}
+		var err error
+		if stmt.Cond, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+		if p.accept(tokComma) {
+			if stmt.Msg, err = p.parseExpr(); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := p.expect(tokRParen); err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokSemi); err != nil {
+			return nil, err
+		}
+		return stmt, nil
+	case tokEmit:
+		p.next()
+		name, err := p.expect(tokIdent)
+		if err != nil {
+			return nil, err
+		}
+		args, err := p.parseArgs()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokSemi); err != nil {
+			return nil, err
+		}
+		return &EmitStmt{pos: tok.pos, Event: name.text, Args: args}, nil
+	case tokReturn:
+		p.next()
+		stmt := &ReturnStmt{pos: tok.pos}
+		if p.peek().kind != tokSemi {
+			var err error
+			if stmt.Value, err = p.parseExpr(); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := p.expect(tokSemi); err != nil {
+			return nil, err
+		}
+		return stmt, nil
+	case tokStorage, tokEvent, tokFn:
+		return nil, errorf(tok.pos, "%s declarations are only allowed at the top level", tok.kind)
+	}
+
+	// 赋值语句: target = value;
+	target, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	assign, err := p.expect(tokAssign)
+	if err != nil {
+		return nil, err
+	}
+	value, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(tokSemi); err != nil {
+		return nil, err
+	}
+	return &AssignStmt{pos: assign.pos, Target: target, Value: value}, nil
+}
+
+func (p *parser) parseLet() (Stmt, error) {
+	kw := p.next()
+	name, err := p.expect(tokIdent)
+	if err != nil {
+		return nil, err
+	}
+	stmt := &LetStmt{pos: kw.pos, Name: name.text}
+	if p.accept(tokColon) {
+		if stmt.Type, err = p.parseType(); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := p.expect(tokAssign); err != nil {
+		return nil, err
+	}
+	if stmt.Value, err = p.parseExpr(); err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(tokSemi); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+func (p *parser) parseIf() (Stmt, error) {
+	kw := p.next()
+	cond, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	then, err := p.parseBlock()
+	if err != nil {
+		return nil, err
+	}
+	stmt := &IfStmt{pos: kw.pos, Cond: cond, Then: then}
+	if p.accept(tokElse) {
+		if p.peek().kind == tokIf {
+			elseIf, err := p.parseIf()
+			if err != nil {
+				return nil, err
+			}
+			stmt.Else = []Stmt{elseIf}
+		} else if stmt.Else, err = p.parseBlock(); err != nil {
+			return nil, err
+		}
+	}
+	return stmt, nil
+}
+
+func (p *parser) parseArgs() ([]Expr, error) {
+	if _, err := p.expect(tokLParen); err != nil {
+		return nil, err
+	}
+	var args []Expr
+	for p.peek().kind != tokRParen {
+		if len(args) > 0 {
+			if _, err := p.expect(tokComma); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	p.next()
+	return args, nil
+}
+
+// 二元运算符的优先级，数值越大结合越紧
+var precedence = map[tokenKind]int{
+	tokOr:  1,
+	tokAnd: 2,
+	tokEq:  3, tokNe: 3,
+	tokLt: 4, tokLe: 4, tokGt: 4, tokGe: 4,
+	tokPlus: 5, tokMinus: 5,
+	tokStar: 6, tokSlash: 6, tokPercent: 6,
+}
+
+func (p *parser) parseExpr() (Expr, error) {
+	return p.parseBinary(1)
+}
+
+func (p *parser) parseBinary(minPrec int) (Expr, error) {
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		prec, ok := precedence[op.kind]
+		if !ok || prec < minPrec {
+			return x, nil
+		}
+		p.next()
+		y, err := p.parseBinary(prec + 1)
+		if err != nil {
+			return nil, err
+		}
+		x = &BinaryExpr{typed: typed{pos: op.pos}, Op: op.kind, X: x, Y: y}
+	}
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	tok := p.peek()
+	if tok.kind == tokNot || tok.kind == tokMinus {
+		p.next()
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &UnaryExpr{typed: typed{pos: tok.pos}, Op: tok.kind, X: x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (Expr, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokInt:
+		v, err := strconv.ParseInt(tok.text, 10, 64)
+		if err != nil {
+			return nil, errorf(tok.pos, "integer literal %s out of range", tok.text)
+		}
+		return &IntLit{typed: typed{pos: tok.pos}, Value: v}, nil
+	case tokTrue, tokFalse:
+		return &BoolLit{typed: typed{pos: tok.pos}, Value: tok.kind == tokTrue}, nil
+	case tokString:
+		return &BytesLit{typed: typed{pos: tok.pos}, Value: []byte(tok.text)}, nil
+	case tokHex:
+		b, _ := hex.DecodeString(tok.text) // 词法分析阶段已经校验过
+		lit := &BytesLit{typed: typed{pos: tok.pos}, Value: b}
+		if len(b) == 20 {
+			lit.typ = TypeAddress
+		}
+		return lit, nil
+	case tokLParen:
+		x, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokRParen); err != nil {
+			return nil, err
+		}
+		return x, nil
+	case tokIdent:
+		ident := &Ident{typed: typed{pos: tok.pos}, Name: tok.text}
+		switch p.peek().kind {
+		case tokLBracket:
+			p.next()
+			index, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := p.expect(tokRBracket); err != nil {
+				return nil, err
+			}
+			return &IndexExpr{typed: typed{pos: tok.pos}, X: ident, Index: index}, nil
+		case tokLParen:
+			args, err := p.parseArgs()
+			if err != nil {
+				return nil, err
+			}
+			return &CallExpr{typed: typed{pos: tok.pos}, Func: tok.text, Args: args}, nil
+		}
+		return ident, nil
+	}
+	return nil, errorf(tok.pos, "expected expression, found %s", describe(tok))
+}
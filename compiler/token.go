@@ -0,0 +1,132 @@
+package compiler
+
+import "fmt"
+
+// Pos 是源码中的位置，行列均从 1 开始
+type Pos struct {
+	Line int
+	Col  int
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Col)
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokInt
+	tokString
+	tokHex
+
+	// 关键字
+	tokStorage
+	tokEvent
+	tokLet
+	tokIf
+	tokElse
+	tokWhile
+	tokRequire
+	tokEmit
+	tokReturn
+	tokTrue
+	tokFalse
+	tokMap
+	tokFn
+
+	// 运算符和分隔符
+	tokPlus     // +
+	tokMinus    // -
+	tokStar     // *
+	tokSlash    // /
+	tokPercent  // %
+	tokEq       // ==
+	tokNe       // !=
+	tokLt       // <
+	tokLe       // <=
+	tokGt       // >
+	tokGe       // >=
+	tokAnd      // &&
+	tokOr       // ||
+	tokNot      // !
+	tokAssign   // =
+	tokLParen   // (
+	tokRParen   // )
+	tokLBrace   // {
+	tokRBrace   // }
+	tokLBracket // [
+	tokRBracket // ]
+	tokComma    // ,
+	tokSemi     // ;
+	tokColon    // :
+	tokArrow    // ->
+)
+
+var keywords = map[string]tokenKind{
+	"storage": tokStorage,
+	"event":   tokEvent,
+	"let":     tokLet,
+	"if":      tokIf,
+	"else":    tokElse,
+	"while":   tokWhile,
+	"require": tokRequire,
+	"emit":    tokEmit,
+	"return":  tokReturn,
+	"true":    tokTrue,
+	"false":   tokFalse,
+	"map":     tokMap,
+	"fn":      tokFn,
+}
+
+var tokenNames = map[tokenKind]string{
+	tokEOF:      "end of file",
+	tokIdent:    "identifier",
+	tokInt:      "integer",
+	tokString:   "string",
+	tokHex:      "hex literal",
+	tokPlus:     "'+'",
+	tokMinus:    "'-'",
+	tokStar:     "'*'",
+	tokSlash:    "'/'",
+	tokPercent:  "'%'",
+	tokEq:       "'=='",
+	tokNe:       "'!='",
+	tokLt:       "'<'",
+	tokLe:       "'<='",
+	tokGt:       "'>'",
+	tokGe:       "'>='",
+	tokAnd:      "'&&'",
+	tokOr:       "'||'",
+	tokNot:      "'!'",
+	tokAssign:   "'='",
+	tokLParen:   "'('",
+	tokRParen:   "')'",
+	tokLBrace:   "'{'",
+	tokRBrace:   "'}'",
+	tokLBracket: "'['",
+	tokRBracket: "']'",
+	tokComma:    "','",
+	tokSemi:     "';'",
+	tokColon:    "':'",
+	tokArrow:    "'->'",
+}
+
+func (k tokenKind) String() string {
+	if name, ok := tokenNames[k]; ok {
+		return name
+	}
+	for word, kind := range keywords {
+		if kind == k {
+			return fmt.Sprintf("'%s'", word)
+		}
+	}
+	return fmt.Sprintf("token(%d)", int(k))
+}
+
+type token struct {
+	kind tokenKind
+	text string // 标识符、数字的原文，或字符串/十六进制字面量解码前的内容
+	pos  Pos
+}
@@ -0,0 +1,60 @@
+package compiler
+
+import "fmt"
+
+type Kind int
+
+const (
+	KindInt Kind = iota
+	KindBool
+	KindBytes
+	KindAddress
+	KindMap
+)
+
+// Type 是语言中的类型。映射只能作为存储变量的类型出现
+type Type struct {
+	Kind Kind
+	Key  *Type // 仅用于 KindMap
+	Elem *Type // 仅用于 KindMap
+}
+
+var (
+	TypeInt     = &Type{Kind: KindInt}
+	TypeBool    = &Type{Kind: KindBool}
+	TypeBytes   = &Type{Kind: KindBytes}
+	TypeAddress = &Type{Kind: KindAddress}
+)
+
+func MapOf(key, elem *Type) *Type {
+	return &Type{Kind: KindMap, Key: key, Elem: elem}
+}
+
+func (t *Type) Equal(o *Type) bool {
+	if t == nil || o == nil {
+		return t == o
+	}
+	if t.Kind != o.Kind {
+		return false
+	}
+	if t.Kind == KindMap {
+		return t.Key.Equal(o.Key) && t.Elem.Equal(o.Elem)
+	}
+	return true
+}
+
+func (t *Type) String() string {
+	switch t.Kind {
+	case KindInt:
+		return "int"
+	case KindBool:
+		return "bool"
+	case KindBytes:
+		return "bytes"
+	case KindAddress:
+		return "address"
+	case KindMap:
+		return fmt.Sprintf("map[%s]%s", t.Key, t.Elem)
+	}
+	return "invalid"
+}
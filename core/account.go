@@ -11,17 +11,22 @@ type AccountState struct {
 	Nonce   uint64        `json:"nonce"`   // 交易计数器
 }
 
-// Encode 将 AccountState 编码为字节流
+// Encode 将 AccountState 编码为规范的二进制格式
 func (a *AccountState) Encode() ([]byte, error) {
-	// 将 gob.NewEncoder(buf).Encode(a) 替换为 json.Marshal(a)
-	return json.Marshal(a)
+	return a.MarshalBinary()
 }
 
-// Decode 从字节流解码 AccountState
+// DecodeAccountState 从字节流解码 AccountState
 func DecodeAccountState(b []byte) (*AccountState, error) {
 	as := new(AccountState)
-	// 将 gob.NewDecoder(...).Decode(as) 替换为 json.Unmarshal(b, as)
-	if err := json.Unmarshal(b, as); err != nil {
+	// 兼容改用规范编码之前以 JSON 存储的账户
+	if len(b) > 0 && b[0] == '{' {
+		if err := json.Unmarshal(b, as); err != nil {
+			return nil, err
+		}
+		return as, nil
+	}
+	if err := as.UnmarshalBinary(b); err != nil {
 		return nil, err
 	}
 	return as, nil
@@ -1,7 +1,6 @@
 package core
 
 import (
-	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"github.com/virtue186/xchain/crypto"
@@ -28,8 +27,9 @@ type Block struct {
 	hash types.Hash
 }
 
+// Bytes 返回区块头的规范编码，用于计算区块哈希和签名
 func (h *Header) Bytes() []byte {
-	b, err := json.Marshal(h)
+	b, err := h.MarshalBinary()
 	if err != nil {
 		// 在关键操作中，如果序列化失败，panic是可接受的
 		panic(err)
@@ -101,16 +101,6 @@ func NewBlockFromPreHeader(h *Header, txx []*Transaction) (*Block, error) {
 	return NewBlock(header, txx)
 }
 
-func CalculateDataHash(txx []*Transaction) (hash types.Hash, err error) {
-	// 将整个交易列表进行json编码
-	b, err := json.Marshal(txx)
-	if err != nil {
-		return types.Hash{}, err
-	}
-	hash = sha256.Sum256(b)
-	return
-}
-
 func DecodeBlock(b []byte) (*Block, error) {
 	block := new(Block)
 	if err := json.Unmarshal(b, block); err != nil {
@@ -1,28 +1,35 @@
 package core
 
 import (
+	"encoding/hex"
 	"fmt"
 	"github.com/go-kit/log"
+	"github.com/virtue186/xchain/types"
 	"sync"
 )
 
 type BlockChain struct {
-	logger        log.Logger
-	store         Storage
-	headers       []*Header
-	validator     Validator
-	lock          sync.RWMutex
-	contractState *State
-	State         *State
+	logger     log.Logger
+	store      Storage
+	headers    []*Header
+	validator  Validator
+	lock       sync.RWMutex
+	insertLock sync.Mutex   // 保证校验、执行、追加区块作为一个整体进行，同一个区块不会被加入两次
+	stateLock  sync.RWMutex // 保护 State 不在执行区块的同时被读取
+	State      *State
+}
+
+// Close 关闭底层存储
+func (bc *BlockChain) Close() error {
+	return bc.store.Close()
 }
 
 func NewBlockChain(log log.Logger, storage Storage, genesis *Block) (*BlockChain, error) {
 	bc := &BlockChain{
-		contractState: NewState(storage),
-		headers:       []*Header{},
-		store:         storage,
-		logger:        log,
-		State:         NewState(storage),
+		headers: []*Header{},
+		store:   storage,
+		logger:  log,
+		State:   NewState(storage),
 	}
 	bc.validator = NewBlockValidator(bc)
 	// 从数据库加载现有的区块头
@@ -71,14 +78,15 @@ func (bc *BlockChain) GetBlocks(fromHeight uint32, count int) ([]*Block, error)
 	bc.lock.RLock()
 	defer bc.lock.RUnlock()
 
-	// 确保请求范围有效
-	if fromHeight > bc.Height() {
+	// 确保请求范围有效，已持有读锁，不能再调用 Height
+	height := uint32(len(bc.headers) - 1)
+	if fromHeight > height {
 		return nil, nil // 没有新区块可提供
 	}
 
 	blocks := make([]*Block, 0, count)
 	// 最多提供到链的最高点
-	for i := 0; i < count && fromHeight+uint32(i) <= bc.Height(); i++ {
+	for i := 0; i < count && fromHeight+uint32(i) <= height; i++ {
 		currentHeight := fromHeight + uint32(i)
 		hash, err := bc.store.GetBlockHashByHeight(currentHeight)
 		if err != nil {
@@ -95,6 +103,9 @@ func (bc *BlockChain) GetBlocks(fromHeight uint32, count int) ([]*Block, error)
 }
 
 func (bc *BlockChain) AddBlock(b *Block) error {
+	bc.insertLock.Lock()
+	defer bc.insertLock.Unlock()
+
 	if err := bc.validator.ValidateBlock(b); err != nil {
 		return err
 	}
@@ -102,7 +113,7 @@ func (bc *BlockChain) AddBlock(b *Block) error {
 		// 如果交易应用失败，这是一个严重的共识错误，不应添加此区块
 		return fmt.Errorf("failed to apply block: %w", err)
 	}
-	return bc.AddBlockWithoutValidation(b)
+	return bc.appendBlock(b)
 }
 
 func (bc *BlockChain) SetValidator(v Validator) {
@@ -116,7 +127,23 @@ func (bc *BlockChain) Height() uint32 {
 	return uint32(len(bc.headers) - 1)
 }
 
+// CurrentHeader 返回最新区块的区块头
+func (bc *BlockChain) CurrentHeader() *Header {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	return bc.headers[len(bc.headers)-1]
+}
+
 func (bc *BlockChain) AddBlockWithoutValidation(b *Block) error {
+	bc.insertLock.Lock()
+	defer bc.insertLock.Unlock()
+
+	return bc.appendBlock(b)
+}
+
+// appendBlock 保存区块并追加区块头，调用方需持有 insertLock
+func (bc *BlockChain) appendBlock(b *Block) error {
 	bc.logger.Log(
 		"msg", "add block",
 		"hash", b.Hash(BlockHasher{}),
@@ -124,8 +151,24 @@ func (bc *BlockChain) AddBlockWithoutValidation(b *Block) error {
 		"transaction", len(b.Transactions),
 	)
 
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+	if err := bc.store.PutBlock(b); err != nil {
+		return err
+	}
 	bc.headers = append(bc.headers, b.Header)
-	return bc.store.PutBlock(b)
+	return nil
+}
+
+// GetBlockByHash 从数据库读取区块，不存在时返回 ErrNotFound
+func (bc *BlockChain) GetBlockByHash(hash types.Hash) (*Block, error) {
+	return bc.store.GetBlockByHash(hash)
+}
+
+// HasBlock 判断区块是否已经在链上
+func (bc *BlockChain) HasBlock(hash types.Hash) bool {
+	_, err := bc.store.GetBlockByHash(hash)
+	return err == nil
 }
 
 func (bc *BlockChain) GetHeader(height uint32) (*Header, error) {
@@ -140,53 +183,98 @@ func (bc *BlockChain) GetHeader(height uint32) (*Header, error) {
 }
 
 func (bc *BlockChain) applyBlock(b *Block) error {
+	bc.stateLock.Lock()
+	defer bc.stateLock.Unlock()
+
+	// 整个区块的修改先写入缓存，任何一笔交易无效都不会留下部分写入
+	cache := NewStateCache(bc.State)
 	for _, tx := range b.Transactions {
-		if err := bc.applyTransaction(tx); err != nil {
-			return err
+		if err := bc.applyTransaction(cache, tx); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidBlock, err)
 		}
 	}
-	return nil
+
+	// 提交之前记录被修改数据的旧值，之后可以据此重建历史状态
+	journal, err := newStateJournal(cache)
+	if err != nil {
+		return err
+	}
+	if err := bc.putJournal(b.Hash(BlockHasher{}), journal); err != nil {
+		return err
+	}
+	return cache.Commit()
 }
 
-// applyTransaction 是状态转换的核心函数
-func (bc *BlockChain) applyTransaction(tx *Transaction) error {
+// applyTransaction 是状态转换的核心函数，交易的修改写入 state，由调用方负责提交
+func (bc *BlockChain) applyTransaction(state *StateCache, tx *Transaction) error {
 	senderAddr := tx.From.Address()
-
-	// 1. 获取发送方和接收方的账户状态
-	senderState, err := bc.State.Get(senderAddr)
+	res, err := executeTransaction(state, tx, nil)
 	if err != nil {
 		return err
 	}
 
-	receiverState, err := bc.State.Get(tx.To)
+	if !res.ContractAddress.IsZero() {
+		bc.logger.Log("msg", "contract deployed", "from", senderAddr, "address", res.ContractAddress, "size", len(tx.Data))
+	}
+	if res.Err != nil {
+		bc.logger.Log("msg", "contract execution failed", "from", senderAddr, "to", tx.To, "err", res.Err)
+	}
+	for _, l := range res.Logs {
+		bc.logger.Log("msg", "contract event", "address", l.Address, "topic", hex.EncodeToString(l.Topic), "data", hex.EncodeToString(l.Data))
+	}
+	bc.logger.Log("msg", "transaction applied", "from", senderAddr, "to", tx.To, "value", tx.Value)
+
+	return nil
+}
+
+// executeTransaction 校验并执行一笔交易，成功时把修改提交到 state。
+// 返回的 error 表示交易本身无效；合约执行失败记录在 ExecutionResult.Err 中，
+// 此时只回滚调用本身，交易依然上链并消耗 nonce，避免一笔失败的调用让整个区块无效
+func executeTransaction(state *StateCache, tx *Transaction, tracer Tracer) (*ExecutionResult, error) {
+	senderAddr := tx.From.Address()
+	cache := state.Child()
+
+	// 1. 获取发送方的账户状态
+	senderState, err := cache.Get(senderAddr)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// 2. 验证交易
 	// 2.1 验证 Nonce
 	if tx.Nonce != senderState.Nonce {
-		return fmt.Errorf("invalid nonce. expected %d, got %d", senderState.Nonce, tx.Nonce)
+		return nil, fmt.Errorf("invalid nonce. expected %d, got %d", senderState.Nonce, tx.Nonce)
 	}
 	// 2.2 验证余额
 	if senderState.Balance < tx.Value {
-		return fmt.Errorf("insufficient balance. have %d, want %d", senderState.Balance, tx.Value)
+		return nil, fmt.Errorf("insufficient balance. have %d, want %d", senderState.Balance, tx.Value)
 	}
 
 	// 3. 执行状态转换
 	senderState.Nonce++
-	senderState.Balance -= tx.Value
-	receiverState.Balance += tx.Value
-
-	// 4. 将更新后的状态写回数据库
-	if err := bc.State.Put(senderAddr, senderState); err != nil {
-		return err
-	}
-	if err := bc.State.Put(tx.To, receiverState); err != nil {
-		return err
+	if err := cache.Put(senderAddr, senderState); err != nil {
+		return nil, err
 	}
 
-	bc.logger.Log("msg", "transaction applied", "from", senderAddr, "to", tx.To, "value", tx.Value)
+	res := &ExecutionResult{}
+	if tx.To.IsZero() && len(tx.Data) > 0 {
+		// 3.1 接收方为空且携带数据：部署合约，Data 即合约字节码
+		res.ContractAddress = ContractAddress(senderAddr, tx.Nonce)
+		if err := cache.PutCode(res.ContractAddress, tx.Data); err != nil {
+			return nil, err
+		}
+		if err := transfer(cache, senderAddr, res.ContractAddress, tx.Value); err != nil {
+			return nil, err
+		}
+	} else {
+		// 3.2 普通转账或合约调用
+		ret, logs, gasLeft, err := call(cache, senderAddr, tx.To, tx.Value, tx.Data, DefaultGasLimit, 0, tracer)
+		res.ReturnData, res.Logs, res.GasUsed, res.Err = ret, logs, DefaultGasLimit-gasLeft, err
+	}
 
-	return nil
+	// 4. 将更新后的状态写回上一层
+	if err := cache.Commit(); err != nil {
+		return nil, err
+	}
+	return res, nil
 }
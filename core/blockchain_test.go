@@ -0,0 +1,61 @@
+package core
+
+import (
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/virtue186/xchain/crypto"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowValidator 在校验之后停顿一段时间，放大校验与追加之间的间隔
+type slowValidator struct {
+	Validator
+}
+
+func (v slowValidator) ValidateBlock(b *Block) error {
+	err := v.Validator.ValidateBlock(b)
+	time.Sleep(10 * time.Millisecond)
+	return err
+}
+
+func TestBlockChainConcurrentAddBlock(t *testing.T) {
+	genesis, _ := NewBlock(&Header{Version: 1}, nil)
+	bc, err := NewBlockChain(log.NewNopLogger(), NewMemoryStorage(), genesis)
+	assert.Nil(t, err)
+	bc.SetValidator(slowValidator{NewBlockValidator(bc)})
+
+	key := crypto.GeneratePrivateKey()
+	prev, err := bc.GetHeader(0)
+	assert.Nil(t, err)
+	// 没有交易的区块执行时不会因 nonce 重复而失败，只能靠校验拒绝重复的区块
+	b, err := NewBlockFromPreHeader(prev, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, b.Sign(key))
+
+	// 同一个区块同时从多个来源到达，只能被加入一次
+	var (
+		wg       sync.WaitGroup
+		lock     sync.Mutex
+		accepted int
+		start    = make(chan struct{})
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if bc.AddBlock(b) == nil {
+				lock.Lock()
+				accepted++
+				lock.Unlock()
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	assert.Equal(t, 1, accepted)
+	assert.Equal(t, uint32(1), bc.Height())
+}
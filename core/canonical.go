@@ -0,0 +1,297 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/virtue186/xchain/crypto"
+	"github.com/virtue186/xchain/types"
+	"math/big"
+)
+
+// 规范二进制编码用于所有参与共识的哈希和签名，与 Go 的结构体布局和 JSON 细节无关，
+// 其他语言的客户端可以据此复现哈希和签名。每个编码都以 编码版本 || 类型标签 两个字节开头，
+// 不同类型的编码因此不会相互冲突。
+//
+// 基本类型：整数为定长大端序（int64 按二进制补码），
+// 哈希和地址按原样写入，变长字节串为 4 字节大端长度 || 内容。
+//
+// 版本 1 的各类型布局：
+//
+//	Header        01 01 | Version u32 | PrevBlockHash [32] | DataHash [32] | Timestamp i64 | Height u32 | Nonce u64
+//	Transaction   01 02 | To [20] | Value u64 | Nonce u64 | Data bytes | From bytes | 有签名 u8 | R [32] | S [32]
+//	交易签名数据  01 03 | To [20] | Value u64 | Nonce u64 | Data bytes
+//	AccountState  01 04 | Address [20] | Balance u64 | Nonce u64
+//	交易列表      01 05 | 交易数 u32 | 每笔交易的哈希 [32]
+//	Block         01 06 | Header bytes | 交易数 u32 | 每笔交易 bytes | Validator bytes | 有签名 u8 | R [32] | S [32]
+//
+// 交易和区块没有签名时省略 R 和 S。区块编码只用于传输和存储，区块哈希仍只覆盖区块头。
+// 测试向量见 testdata/canonical_vectors.json。
+const CanonicalVersion byte = 1
+
+const (
+	tagHeader byte = iota + 1
+	tagTransaction
+	tagTxSignature
+	tagAccountState
+	tagTxList
+	tagBlock
+)
+
+var errShortBuffer = errors.New("canonical encoding: unexpected end of data")
+
+// canonicalWriter 按规范编码依次追加字段
+type canonicalWriter struct {
+	buf []byte
+}
+
+func newCanonicalWriter(tag byte) *canonicalWriter {
+	return &canonicalWriter{buf: []byte{CanonicalVersion, tag}}
+}
+
+func (w *canonicalWriter) uint8(v uint8)   { w.buf = append(w.buf, v) }
+func (w *canonicalWriter) uint32(v uint32) { w.buf = binary.BigEndian.AppendUint32(w.buf, v) }
+func (w *canonicalWriter) uint64(v uint64) { w.buf = binary.BigEndian.AppendUint64(w.buf, v) }
+func (w *canonicalWriter) fixed(b []byte)  { w.buf = append(w.buf, b...) }
+
+func (w *canonicalWriter) bytes(b []byte) {
+	w.uint32(uint32(len(b)))
+	w.buf = append(w.buf, b...)
+}
+
+// canonicalReader 按规范编码依次读取字段，出错后的读取都返回零值，只需在最后检查 err
+type canonicalReader struct {
+	buf []byte
+	err error
+}
+
+func newCanonicalReader(data []byte, tag byte) *canonicalReader {
+	r := &canonicalReader{buf: data}
+	if version := r.uint8(); r.err == nil && version != CanonicalVersion {
+		r.err = fmt.Errorf("canonical encoding: unsupported version %d", version)
+	}
+	if t := r.uint8(); r.err == nil && t != tag {
+		r.err = fmt.Errorf("canonical encoding: unexpected type tag %d, want %d", t, tag)
+	}
+	return r
+}
+
+func (r *canonicalReader) next(n int) []byte {
+	if r.err != nil {
+		return make([]byte, n)
+	}
+	if len(r.buf) < n {
+		r.err = errShortBuffer
+		return make([]byte, n)
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *canonicalReader) uint8() uint8   { return r.next(1)[0] }
+func (r *canonicalReader) uint32() uint32 { return binary.BigEndian.Uint32(r.next(4)) }
+func (r *canonicalReader) uint64() uint64 { return binary.BigEndian.Uint64(r.next(8)) }
+
+func (r *canonicalReader) bytes() []byte {
+	n := r.uint32()
+	if r.err == nil && uint64(n) > uint64(len(r.buf)) {
+		r.err = errShortBuffer
+	}
+	if r.err != nil {
+		return nil
+	}
+	return append([]byte{}, r.next(int(n))...)
+}
+
+// signature 写入 有签名 u8 | R [32] | S [32]，没有签名时只写入 0
+func (w *canonicalWriter) signature(sig *crypto.Signature) error {
+	if sig == nil {
+		w.uint8(0)
+		return nil
+	}
+	if sig.R.Sign() < 0 || sig.S.Sign() < 0 ||
+		sig.R.BitLen() > 256 || sig.S.BitLen() > 256 {
+		return fmt.Errorf("signature scalar out of range")
+	}
+	w.uint8(1)
+	w.fixed(sig.R.FillBytes(make([]byte, 32)))
+	w.fixed(sig.S.FillBytes(make([]byte, 32)))
+	return nil
+}
+
+func (r *canonicalReader) signature() *crypto.Signature {
+	switch hasSig := r.uint8(); {
+	case r.err != nil:
+	case hasSig == 1:
+		return &crypto.Signature{
+			R: new(big.Int).SetBytes(r.next(32)),
+			S: new(big.Int).SetBytes(r.next(32)),
+		}
+	case hasSig != 0:
+		r.err = fmt.Errorf("canonical encoding: invalid signature flag %d", hasSig)
+	}
+	return nil
+}
+
+// finish 检查是否恰好读完全部数据
+func (r *canonicalReader) finish() error {
+	if r.err == nil && len(r.buf) > 0 {
+		r.err = fmt.Errorf("canonical encoding: %d trailing bytes", len(r.buf))
+	}
+	return r.err
+}
+
+// MarshalBinary 返回区块头的规范编码
+func (h *Header) MarshalBinary() ([]byte, error) {
+	w := newCanonicalWriter(tagHeader)
+	w.uint32(h.Version)
+	w.fixed(h.PrevBlockHash[:])
+	w.fixed(h.DataHash[:])
+	w.uint64(uint64(h.Timestamp))
+	w.uint32(h.Height)
+	w.uint64(h.Nonce)
+	return w.buf, nil
+}
+
+// UnmarshalBinary 解析区块头的规范编码
+func (h *Header) UnmarshalBinary(data []byte) error {
+	r := newCanonicalReader(data, tagHeader)
+	h.Version = r.uint32()
+	h.PrevBlockHash = types.HashFromBytes(r.next(32))
+	h.DataHash = types.HashFromBytes(r.next(32))
+	h.Timestamp = int64(r.uint64())
+	h.Height = r.uint32()
+	h.Nonce = r.uint64()
+	return r.finish()
+}
+
+// MarshalBinary 返回包含发送方和签名在内的完整交易的规范编码
+func (tx *Transaction) MarshalBinary() ([]byte, error) {
+	w := newCanonicalWriter(tagTransaction)
+	tx.writeBody(w)
+	w.bytes(tx.From)
+	if err := w.signature(tx.Signature); err != nil {
+		return nil, err
+	}
+	return w.buf, nil
+}
+
+// UnmarshalBinary 解析完整交易的规范编码
+func (tx *Transaction) UnmarshalBinary(data []byte) error {
+	r := newCanonicalReader(data, tagTransaction)
+	tx.To = types.AddressFromBytes(r.next(20))
+	tx.Value = r.uint64()
+	tx.Nonce = r.uint64()
+	tx.Data = r.bytes()
+	tx.From = crypto.PublicKey(r.bytes())
+	tx.Signature = r.signature()
+	tx.hash = types.Hash{}
+	return r.finish()
+}
+
+// MarshalBinary 返回完整区块的编码。
+// 必须显式实现，否则会使用从 *Header 提升的方法而只编码区块头
+func (b *Block) MarshalBinary() ([]byte, error) {
+	if b.Header == nil {
+		return nil, fmt.Errorf("block has no header")
+	}
+	w := newCanonicalWriter(tagBlock)
+	header, _ := b.Header.MarshalBinary()
+	w.bytes(header)
+	w.uint32(uint32(len(b.Transactions)))
+	for _, tx := range b.Transactions {
+		data, err := tx.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		w.bytes(data)
+	}
+	w.bytes(b.Validator)
+	if err := w.signature(b.Signature); err != nil {
+		return nil, err
+	}
+	return w.buf, nil
+}
+
+// UnmarshalBinary 解析完整区块的编码
+func (b *Block) UnmarshalBinary(data []byte) error {
+	r := newCanonicalReader(data, tagBlock)
+	header := new(Header)
+	if err := header.UnmarshalBinary(r.bytes()); r.err == nil && err != nil {
+		return err
+	}
+	n := r.uint32()
+	if r.err == nil && uint64(n) > uint64(len(r.buf)) {
+		// 每笔交易至少占 4 字节长度前缀，防止恶意的交易数导致超大分配
+		r.err = errShortBuffer
+	}
+	var txx []*Transaction
+	if r.err == nil && n > 0 {
+		txx = make([]*Transaction, 0, n)
+	}
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		tx := new(Transaction)
+		if err := tx.UnmarshalBinary(r.bytes()); r.err == nil && err != nil {
+			return fmt.Errorf("transaction %d: %w", i, err)
+		}
+		txx = append(txx, tx)
+	}
+	validator := r.bytes()
+	sig := r.signature()
+	if err := r.finish(); err != nil {
+		return err
+	}
+	*b = Block{
+		Header:       header,
+		Transactions: txx,
+		Validator:    crypto.PublicKey(validator),
+		Signature:    sig,
+	}
+	return nil
+}
+
+// writeBody 写入交易中需要签名的字段
+func (tx *Transaction) writeBody(w *canonicalWriter) {
+	w.fixed(tx.To[:])
+	w.uint64(tx.Value)
+	w.uint64(tx.Nonce)
+	w.bytes(tx.Data)
+}
+
+// encodeForSignature 返回交易签名所覆盖的数据，不包含发送方公钥和签名本身
+func (tx *Transaction) encodeForSignature() ([]byte, error) {
+	w := newCanonicalWriter(tagTxSignature)
+	tx.writeBody(w)
+	return w.buf, nil
+}
+
+// MarshalBinary 返回账户状态的规范编码
+func (a *AccountState) MarshalBinary() ([]byte, error) {
+	w := newCanonicalWriter(tagAccountState)
+	w.fixed(a.Address[:])
+	w.uint64(a.Balance)
+	w.uint64(a.Nonce)
+	return w.buf, nil
+}
+
+// UnmarshalBinary 解析账户状态的规范编码
+func (a *AccountState) UnmarshalBinary(data []byte) error {
+	r := newCanonicalReader(data, tagAccountState)
+	a.Address = types.AddressFromBytes(r.next(20))
+	a.Balance = r.uint64()
+	a.Nonce = r.uint64()
+	return r.finish()
+}
+
+// CalculateDataHash 计算区块中交易列表的哈希，即交易数与各交易哈希的规范编码的 SHA-256
+func CalculateDataHash(txx []*Transaction) (types.Hash, error) {
+	w := newCanonicalWriter(tagTxList)
+	w.uint32(uint32(len(txx)))
+	for _, tx := range txx {
+		h := tx.Hash(TxHasher{})
+		w.fixed(h[:])
+	}
+	return sha256.Sum256(w.buf), nil
+}
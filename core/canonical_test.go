@@ -0,0 +1,127 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/virtue186/xchain/crypto"
+	"github.com/virtue186/xchain/types"
+	"math/big"
+	"os"
+	"testing"
+)
+
+// canonicalVector 是 testdata/canonical_vectors.json 中的一条测试向量
+type canonicalVector struct {
+	Name     string          `json:"name"`
+	Fields   json.RawMessage `json:"fields"`
+	Encoding string          `json:"encoding"`
+	SHA256   string          `json:"sha256"`
+}
+
+func loadCanonicalVectors(t *testing.T) map[string]canonicalVector {
+	data, err := os.ReadFile("testdata/canonical_vectors.json")
+	assert.Nil(t, err)
+	var list []canonicalVector
+	assert.Nil(t, json.Unmarshal(data, &list))
+	vectors := make(map[string]canonicalVector)
+	for _, v := range list {
+		vectors[v.Name] = v
+	}
+	return vectors
+}
+
+func repeat(b byte, n int) []byte {
+	return bytes.Repeat([]byte{b}, n)
+}
+
+func vectorTransaction() *Transaction {
+	return &Transaction{
+		To:    types.AddressFromBytes(repeat(0x33, 20)),
+		Value: 1000,
+		Nonce: 5,
+		Data:  []byte("hello"),
+	}
+}
+
+func TestCanonicalVectors(t *testing.T) {
+	vectors := loadCanonicalVectors(t)
+
+	signedTx := vectorTransaction()
+	pub, _ := hex.DecodeString("036b17d1f2e12c4247f8bce6e563a440f277037d812deb33a0f4a13945d898c296")
+	signedTx.From = crypto.PublicKey(pub)
+	signedTx.Signature = &crypto.Signature{
+		R: new(big.Int).SetBytes(repeat(0x44, 32)),
+		S: new(big.Int).SetBytes(repeat(0x55, 32)),
+	}
+	sigData, _ := vectorTransaction().encodeForSignature()
+	header, _ := (&Header{
+		Version:       1,
+		PrevBlockHash: types.HashFromBytes(repeat(0x11, 32)),
+		DataHash:      types.HashFromBytes(repeat(0x22, 32)),
+		Timestamp:     1700000000000000000,
+		Height:        42,
+		Nonce:         7,
+	}).MarshalBinary()
+	unsignedTx, _ := vectorTransaction().MarshalBinary()
+	fullTx, _ := signedTx.MarshalBinary()
+	account, _ := (&AccountState{
+		Address: types.AddressFromBytes(repeat(0x66, 20)),
+		Balance: 1000000,
+		Nonce:   3,
+	}).MarshalBinary()
+	txList := []*Transaction{vectorTransaction(), signedTx}
+	dataHash, _ := CalculateDataHash(txList)
+
+	encodings := map[string][]byte{
+		"header":              header,
+		"transaction_signing": sigData,
+		"transaction":         unsignedTx,
+		"transaction_signed":  fullTx,
+		"account_state":       account,
+	}
+	for name, enc := range encodings {
+		v, ok := vectors[name]
+		if !assert.True(t, ok, name) {
+			continue
+		}
+		assert.Equal(t, v.Encoding, hex.EncodeToString(enc), name)
+		sum := sha256.Sum256(enc)
+		assert.Equal(t, v.SHA256, hex.EncodeToString(sum[:]), name)
+	}
+	assert.Equal(t, vectors["transaction_signed"].SHA256, signedTx.Hash(TxHasher{}).String())
+	assert.Equal(t, vectors["data_hash"].SHA256, dataHash.String())
+}
+
+func TestCanonicalRoundTrip(t *testing.T) {
+	key := crypto.GeneratePrivateKey()
+	tx := vectorTransaction()
+	assert.Nil(t, tx.Sign(key))
+
+	data, err := tx.MarshalBinary()
+	assert.Nil(t, err)
+	decoded := new(Transaction)
+	assert.Nil(t, decoded.UnmarshalBinary(data))
+	assert.Nil(t, decoded.Verify())
+	assert.Equal(t, tx.Hash(TxHasher{}), decoded.Hash(TxHasher{}))
+
+	// 截断、多余字节和错误的类型标签都应当被拒绝
+	assert.NotNil(t, new(Transaction).UnmarshalBinary(data[:len(data)-1]))
+	assert.NotNil(t, new(Transaction).UnmarshalBinary(append(data, 0)))
+	assert.NotNil(t, new(Header).UnmarshalBinary(data))
+
+	acc := &AccountState{Address: key.PublicKey().Address(), Balance: 10, Nonce: 1}
+	enc, err := acc.Encode()
+	assert.Nil(t, err)
+	decodedAcc, err := DecodeAccountState(enc)
+	assert.Nil(t, err)
+	assert.Equal(t, acc, decodedAcc)
+
+	// 旧版本以 JSON 存储的账户依然可以读取
+	legacy, _ := json.Marshal(acc)
+	decodedAcc, err = DecodeAccountState(legacy)
+	assert.Nil(t, err)
+	assert.Equal(t, acc, decodedAcc)
+}
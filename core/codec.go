@@ -0,0 +1,91 @@
+package core
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// 内置编解码器的名字
+const (
+	CodecJSON   = "json"
+	CodecBinary = "binary"
+)
+
+// Codec 是一个按名字注册的编解码器，网络消息和存储都通过名字选择编解码器
+type Codec interface {
+	Name() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+var (
+	codecLock  sync.RWMutex
+	codecs     = make(map[string]Codec)
+	codecNames []string // 按注册顺序
+)
+
+func init() {
+	RegisterCodec(JSONCodec{})
+	RegisterCodec(BinaryCodec{})
+}
+
+// RegisterCodec 注册一个编解码器，同名的编解码器会被替换
+func RegisterCodec(c Codec) {
+	codecLock.Lock()
+	defer codecLock.Unlock()
+	if _, ok := codecs[c.Name()]; !ok {
+		codecNames = append(codecNames, c.Name())
+	}
+	codecs[c.Name()] = c
+}
+
+// LookupCodec 按名字查找已注册的编解码器
+func LookupCodec(name string) (Codec, error) {
+	codecLock.RLock()
+	defer codecLock.RUnlock()
+	c, ok := codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown codec %q", name)
+	}
+	return c, nil
+}
+
+// CodecNames 按注册顺序返回全部编解码器的名字
+func CodecNames() []string {
+	codecLock.RLock()
+	defer codecLock.RUnlock()
+	return append([]string{}, codecNames...)
+}
+
+// JSONCodec 使用标准库的 JSON 编码
+type JSONCodec struct{}
+
+func (JSONCodec) Name() string { return CodecJSON }
+
+func (JSONCodec) Marshal(v any) ([]byte, error) { return json.Marshal(v) }
+
+func (JSONCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+// BinaryCodec 是紧凑的二进制编码，要求值实现 encoding.BinaryMarshaler 和 encoding.BinaryUnmarshaler。
+// 区块、交易和账户状态使用规范编码，网络消息各自实现了二进制编码
+type BinaryCodec struct{}
+
+func (BinaryCodec) Name() string { return CodecBinary }
+
+func (BinaryCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(encoding.BinaryMarshaler)
+	if !ok {
+		return nil, fmt.Errorf("binary codec: %T does not implement encoding.BinaryMarshaler", v)
+	}
+	return m.MarshalBinary()
+}
+
+func (BinaryCodec) Unmarshal(data []byte, v any) error {
+	u, ok := v.(encoding.BinaryUnmarshaler)
+	if !ok {
+		return fmt.Errorf("binary codec: %T does not implement encoding.BinaryUnmarshaler", v)
+	}
+	return u.UnmarshalBinary(data)
+}
@@ -0,0 +1,44 @@
+package core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/virtue186/xchain/crypto"
+	"testing"
+)
+
+func TestCodecBlockRoundTrip(t *testing.T) {
+	key := crypto.GeneratePrivateKey()
+	tx := vectorTransaction()
+	assert.Nil(t, tx.Sign(key))
+	block, err := NewBlockFromPreHeader(&Header{Height: 1}, []*Transaction{tx})
+	assert.Nil(t, err)
+	assert.Nil(t, block.Sign(key))
+
+	for _, name := range []string{CodecJSON, CodecBinary} {
+		codec, err := LookupCodec(name)
+		assert.Nil(t, err)
+		data, err := codec.Marshal(block)
+		assert.Nil(t, err)
+
+		decoded := new(Block)
+		assert.Nil(t, codec.Unmarshal(data, decoded), name)
+		assert.Nil(t, decoded.Verify(), name)
+		assert.Equal(t, block.Hash(BlockHasher{}), decoded.Hash(BlockHasher{}), name)
+		assert.Equal(t, 1, len(decoded.Transactions), name)
+		assert.Equal(t, tx.Hash(TxHasher{}), decoded.Transactions[0].Hash(TxHasher{}), name)
+	}
+
+	// 二进制编码包含交易，而不是只有从 *Header 提升的区块头编码
+	data, _ := block.MarshalBinary()
+	header, _ := block.Header.MarshalBinary()
+	assert.Greater(t, len(data), len(header))
+}
+
+func TestLookupCodec(t *testing.T) {
+	_, err := LookupCodec("gob")
+	assert.NotNil(t, err)
+	assert.Equal(t, []string{CodecJSON, CodecBinary}, CodecNames())
+
+	_, err = BinaryCodec{}.Marshal(struct{}{})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,126 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/virtue186/xchain/types"
+)
+
+// Contract 描述了一次合约调用的执行上下文
+type Contract struct {
+	Address types.Address // 正在执行的合约地址
+	Caller  types.Address // 发起本次调用的账户或合约
+	Value   uint64        // 随调用转入的金额
+	Input   []byte        // 调用数据
+}
+
+// ContractAddress 根据部署者地址和部署交易的 nonce 计算新合约的地址
+func ContractAddress(deployer types.Address, nonce uint64) types.Address {
+	buf := make([]byte, 0, len(deployer)+8)
+	buf = append(buf, deployer.ToSlice()...)
+	buf = binary.BigEndian.AppendUint64(buf, nonce)
+	h := sha256.Sum256(buf)
+
+	return types.AddressFromBytes(h[len(h)-20:])
+}
+
+// call 在 state 的一个子缓存中执行从 caller 到 to 的消息调用。
+// 转账和合约执行只有在全部成功时才会提交到 state，否则整体回滚，事件也一并丢弃。
+// 返回值中的 gasLeft 是调用结束后未使用的 gas：
+// 正常返回和 REVERT 都会退还剩余 gas，其他错误则耗尽全部 gas。
+func call(state *StateCache, caller, to types.Address, value uint64, input []byte, gas uint64, depth int, tracer Tracer) (ret []byte, logs []*Log, gasLeft uint64, err error) {
+	if depth > MaxCallDepth {
+		return nil, nil, gas, ErrMaxCallDepth
+	}
+
+	child := state.Child()
+	if value > 0 {
+		if err := transfer(child, caller, to, value); err != nil {
+			return nil, nil, gas, err
+		}
+	}
+
+	code, err := child.GetCode(to)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if IsPrecompile(to) {
+		ret, gas, err = runPrecompile(to, input, gas)
+		if err != nil {
+			child.Discard()
+			return nil, nil, 0, err
+		}
+	} else if len(code) > 0 {
+		vm := NewContractVm(code, child, Contract{
+			Address: to,
+			Caller:  caller,
+			Value:   value,
+			Input:   input,
+		}, gas)
+		vm.depth = depth
+		vm.tracer = tracer
+
+		if err := vm.Run(); err != nil {
+			child.Discard()
+			if errors.Is(err, ErrExecutionReverted) {
+				return vm.ReturnData(), nil, vm.Gas(), err
+			}
+			return nil, nil, 0, err
+		}
+		ret, logs, gas = vm.ReturnData(), vm.Logs(), vm.Gas()
+	}
+
+	if err := child.Commit(); err != nil {
+		return nil, nil, 0, err
+	}
+	return ret, logs, gas, nil
+}
+
+// transfer 在 state 中把 value 从 from 转给 to
+func transfer(state StateDB, from, to types.Address, value uint64) error {
+	fromState, err := state.Get(from)
+	if err != nil {
+		return err
+	}
+	if fromState.Balance < value {
+		return fmt.Errorf("insufficient balance. have %d, want %d", fromState.Balance, value)
+	}
+	fromState.Balance -= value
+	if err := state.Put(from, fromState); err != nil {
+		return err
+	}
+
+	// 必须在写回 from 之后再读取 to，否则自我转账时会覆盖掉扣款
+	toState, err := state.Get(to)
+	if err != nil {
+		return err
+	}
+	toState.Balance += value
+	return state.Put(to, toState)
+}
+
+// ExecutionResult 是一次交易或只读调用的执行结果
+type ExecutionResult struct {
+	ReturnData      []byte
+	Logs            []*Log
+	GasUsed         uint64
+	Err             error         // 执行失败或回滚的原因，为 nil 表示调用成功
+	ContractAddress types.Address // 部署交易创建的合约地址
+}
+
+// StaticCall 基于当前状态模拟执行一次调用，产生的状态修改全部丢弃
+func (bc *BlockChain) StaticCall(from, to types.Address, value uint64, input []byte) *ExecutionResult {
+	bc.stateLock.RLock()
+	defer bc.stateLock.RUnlock()
+
+	cache := NewStateCache(bc.State)
+	ret, logs, gasLeft, err := call(cache, from, to, value, input, DefaultGasLimit, 0, nil)
+	return &ExecutionResult{
+		ReturnData: ret,
+		Logs:       logs,
+		GasUsed:    DefaultGasLimit - gasLeft,
+		Err:        err,
+	}
+}
@@ -2,7 +2,6 @@ package core
 
 import (
 	"crypto/sha256"
-	"encoding/json"
 	"github.com/virtue186/xchain/types"
 )
 
@@ -22,10 +21,10 @@ func (BlockHasher) Hash(h *Header) types.Hash {
 type TxHasher struct {
 }
 
-// Hash 计算交易的哈希值
+// Hash 计算交易的哈希值，即完整交易规范编码的 SHA-256
 func (TxHasher) Hash(tx *Transaction) types.Hash {
 
-	b, err := json.Marshal(tx)
+	b, err := tx.MarshalBinary()
 	if err != nil {
 		panic(err)
 	}
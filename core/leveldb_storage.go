@@ -1,14 +1,16 @@
 package core
 
 import (
-	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/virtue186/xchain/types"
 	"strconv"
 )
 
 type LeveldbStorage struct {
-	db *leveldb.DB
+	db    *leveldb.DB
+	codec Codec // 区块的编解码器
 }
 
 func (s *LeveldbStorage) Put(key, value []byte) error {
@@ -23,17 +25,57 @@ func (s *LeveldbStorage) Delete(key []byte) error {
 	return s.db.Delete(key, nil)
 }
 
+// NewLeveldbStorage 使用 JSON 编码区块
 func NewLeveldbStorage(path string) (*LeveldbStorage, error) {
+	return NewLeveldbStorageWithCodec(path, CodecJSON)
+}
+
+// NewLeveldbStorageWithCodec 使用名为 codec 的编解码器编码区块。
+// 数据库第一次打开时会记录所用的编解码器，之后必须使用同一个编解码器打开
+func NewLeveldbStorageWithCodec(path string, codec string) (*LeveldbStorage, error) {
+	c, err := LookupCodec(codec)
+	if err != nil {
+		return nil, err
+	}
 	db, err := leveldb.OpenFile(path, nil)
 	if err != nil {
 		return nil, err
 	}
-	return &LeveldbStorage{db: db}, nil
+	if err := checkStorageCodec(db, codec); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &LeveldbStorage{db: db, codec: c}, nil
+}
+
+// checkStorageCodec 检查数据库记录的编解码器，新数据库则记录 codec
+func checkStorageCodec(db *leveldb.DB, codec string) error {
+	stored, err := db.Get(codecKey, nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		// 记录编解码器之前创建的数据库中的区块都是 JSON 编码
+		stored = []byte(codec)
+		if _, err := db.Get(blockHeightKey(0), nil); err == nil {
+			stored = []byte(CodecJSON)
+		}
+		if err := db.Put(codecKey, stored, nil); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	if string(stored) != codec {
+		return fmt.Errorf("database uses codec %q, cannot open it with %q", stored, codec)
+	}
+	return nil
+}
+
+// Codec 返回编码区块所用的编解码器名字
+func (s *LeveldbStorage) Codec() string {
+	return s.codec.Name()
 }
 
 func (s *LeveldbStorage) PutBlock(block *Block) error {
-	// 将 gob.NewEncoder(buf).Encode(block) 替换为 json.Marshal(block)
-	data, err := json.Marshal(block)
+	data, err := s.codec.Marshal(block)
 	if err != nil {
 		return err
 	}
@@ -47,14 +89,17 @@ func (s *LeveldbStorage) PutBlock(block *Block) error {
 }
 
 // GetBlockByHash 根据区块哈希从数据库中获取区块
-// 此处无需修改，因为它依赖的 DecodeBlock 已经在别处被修改为使用json
 func (s *LeveldbStorage) GetBlockByHash(hash types.Hash) (*Block, error) {
 	data, err := s.db.Get(blockKey(hash), nil)
 	if err != nil {
 		return nil, err
 	}
 
-	return DecodeBlock(data)
+	block := new(Block)
+	if err := s.codec.Unmarshal(data, block); err != nil {
+		return nil, err
+	}
+	return block, nil
 }
 
 // GetBlockHashByHeight 根据区块高度从数据库中获取区块哈希
@@ -77,6 +122,9 @@ const (
 	blockPrefix       = "b"
 )
 
+// codecKey 记录数据库中区块所用的编解码器
+var codecKey = []byte("mcodec")
+
 var (
 	blockHeightPrefixB = []byte(blockHeightPrefix) // []byte{'h'}
 	blockPrefixB       = []byte(blockPrefix)       // []byte{'b'}
@@ -0,0 +1,82 @@
+package core
+
+import (
+	"github.com/virtue186/xchain/types"
+	"sync"
+)
+
+// MemoryStorage 是只保存在内存中的 Storage，用于测试。区块同样经过编解码器编码后保存，
+// 读出的区块与写入的区块互不影响
+type MemoryStorage struct {
+	codec Codec
+
+	lock sync.RWMutex
+	data map[string][]byte
+}
+
+// NewMemoryStorage 创建一个使用 JSON 编码区块的内存存储
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{codec: JSONCodec{}, data: make(map[string][]byte)}
+}
+
+func (s *MemoryStorage) Put(key, value []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.data[string(key)] = append([]byte{}, value...)
+	return nil
+}
+
+func (s *MemoryStorage) Get(key []byte) ([]byte, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	value, ok := s.data[string(key)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte{}, value...), nil
+}
+
+func (s *MemoryStorage) Delete(key []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.data, string(key))
+	return nil
+}
+
+func (s *MemoryStorage) PutBlock(block *Block) error {
+	data, err := s.codec.Marshal(block)
+	if err != nil {
+		return err
+	}
+	blockHash := block.Hash(BlockHasher{})
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.data[string(blockHeightKey(block.Height))] = blockHash.ToSlice()
+	s.data[string(blockKey(blockHash))] = data
+	return nil
+}
+
+func (s *MemoryStorage) GetBlockByHash(hash types.Hash) (*Block, error) {
+	data, err := s.Get(blockKey(hash))
+	if err != nil {
+		return nil, err
+	}
+	block := new(Block)
+	if err := s.codec.Unmarshal(data, block); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+func (s *MemoryStorage) GetBlockHashByHeight(height uint32) (types.Hash, error) {
+	data, err := s.Get(blockHeightKey(height))
+	if err != nil {
+		return types.Hash{}, err
+	}
+	return types.HashFromBytes(data), nil
+}
+
+func (s *MemoryStorage) Close() error {
+	return nil
+}
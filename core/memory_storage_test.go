@@ -0,0 +1,39 @@
+package core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/virtue186/xchain/types"
+	"testing"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	s := NewMemoryStorage()
+
+	_, err := s.Get([]byte("missing"))
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.True(t, isNotFound(err))
+
+	assert.Nil(t, s.Put([]byte("k"), []byte("v")))
+	value, err := s.Get([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v"), value)
+	assert.Nil(t, s.Delete([]byte("k")))
+	_, err = s.Get([]byte("k"))
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// 不存在的账户读出为空账户
+	state := NewState(s)
+	account, err := state.Get(types.Address{})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), account.Balance)
+
+	block, err := NewBlock(&Header{Version: 1, Height: 0}, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, s.PutBlock(block))
+	hash, err := s.GetBlockHashByHeight(0)
+	assert.Nil(t, err)
+	assert.Equal(t, block.Hash(BlockHasher{}), hash)
+	stored, err := s.GetBlockByHash(hash)
+	assert.Nil(t, err)
+	assert.Equal(t, hash, stored.Hash(BlockHasher{}))
+}
@@ -0,0 +1,134 @@
+package core
+
+import (
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"github.com/virtue186/xchain/crypto"
+	"github.com/virtue186/xchain/types"
+	"math/big"
+)
+
+// 预编译合约占用前 19 字节为 0、最后一个字节非 0 的地址，
+// 发往这些地址的调用直接执行 Go 实现，而不是合约字节码
+var (
+	PrecompileSHA256      = precompileAddress(0x01)
+	PrecompileVerifyP256  = precompileAddress(0x02)
+	PrecompileMerkleProof = precompileAddress(0x03)
+)
+
+// 预编译合约的固定 gas 开销
+const (
+	GasSHA256      uint64 = 60
+	GasVerifyP256  uint64 = 3000
+	GasMerkleProof uint64 = 200
+)
+
+// maxMerkleProofDepth 限制了 Merkle 证明的层数
+const maxMerkleProofDepth = 64
+
+type precompile struct {
+	gas uint64
+	run func(input []byte) ([]byte, error)
+}
+
+var precompiles = map[types.Address]precompile{
+	PrecompileSHA256:      {GasSHA256, runSHA256},
+	PrecompileVerifyP256:  {GasVerifyP256, runVerifyP256},
+	PrecompileMerkleProof: {GasMerkleProof, runMerkleProof},
+}
+
+func precompileAddress(n byte) types.Address {
+	var addr types.Address
+	addr[len(addr)-1] = n
+	return addr
+}
+
+// IsPrecompile 判断 addr 是否落在预编译合约的保留地址范围内
+func IsPrecompile(addr types.Address) bool {
+	for _, b := range addr[:len(addr)-1] {
+		if b != 0 {
+			return false
+		}
+	}
+	return addr[len(addr)-1] != 0
+}
+
+// runPrecompile 执行 addr 上的预编译合约。保留范围内尚未分配的地址总是执行失败
+func runPrecompile(addr types.Address, input []byte, gas uint64) ([]byte, uint64, error) {
+	p, ok := precompiles[addr]
+	if !ok {
+		return nil, 0, fmt.Errorf("no precompile at reserved address %s", addr)
+	}
+	if gas < p.gas {
+		return nil, 0, ErrOutOfGas
+	}
+	ret, err := p.run(input)
+	if err != nil {
+		return nil, 0, err
+	}
+	return ret, gas - p.gas, nil
+}
+
+// runSHA256 返回 input 的 SHA-256 摘要
+func runSHA256(input []byte) ([]byte, error) {
+	h := sha256.Sum256(input)
+	return h[:], nil
+}
+
+// runVerifyP256 校验 P-256 签名。input 为
+// 压缩公钥(33 字节) || r(32 字节大端) || s(32 字节大端) || 消息，
+// 消息的哈希方式与 crypto.Signature.Verify 相同。签名有效时返回整数 1，否则返回 0
+func runVerifyP256(input []byte) ([]byte, error) {
+	const keyLen, scalarLen = 33, 32
+	if len(input) < keyLen+2*scalarLen {
+		return nil, fmt.Errorf("verify input too short: %d bytes", len(input))
+	}
+	pubKey := crypto.PublicKey(input[:keyLen])
+	sig := crypto.Signature{
+		R: new(big.Int).SetBytes(input[keyLen : keyLen+scalarLen]),
+		S: new(big.Int).SetBytes(input[keyLen+scalarLen : keyLen+2*scalarLen]),
+	}
+	msg := input[keyLen+2*scalarLen:]
+
+	// 无法解析的公钥视为签名无效
+	if x, _ := elliptic.UnmarshalCompressed(elliptic.P256(), pubKey); x == nil {
+		return serializeInt64(0), nil
+	}
+	return serializeInt64(int64(boolToInt(sig.Verify(pubKey, msg)))), nil
+}
+
+// runMerkleProof 校验 Merkle 证明。input 为
+// 根(32 字节) || 叶子哈希(32 字节) || 叶子下标(8 字节小端) || 自底向上的兄弟节点(每个 32 字节)，
+// 父节点为 sha256(左 || 右)。证明有效时返回整数 1，否则返回 0
+func runMerkleProof(input []byte) ([]byte, error) {
+	const headerLen = 32 + 32 + 8
+	if len(input) < headerLen || (len(input)-headerLen)%32 != 0 {
+		return nil, fmt.Errorf("invalid merkle proof length: %d bytes", len(input))
+	}
+	siblings := (len(input) - headerLen) / 32
+	if siblings > maxMerkleProofDepth {
+		return nil, fmt.Errorf("merkle proof too deep: %d levels", siblings)
+	}
+
+	root := input[:32]
+	node := append([]byte{}, input[32:64]...)
+	index := binary.LittleEndian.Uint64(input[64:72])
+	for i := 0; i < siblings; i++ {
+		sibling := input[headerLen+i*32 : headerLen+(i+1)*32]
+		var h [32]byte
+		if index&1 == 0 {
+			h = sha256.Sum256(append(node, sibling...))
+		} else {
+			h = sha256.Sum256(append(append([]byte{}, sibling...), node...))
+		}
+		node = h[:]
+		index >>= 1
+	}
+	// 下标超出了证明所能表示的范围
+	if index != 0 {
+		return serializeInt64(0), nil
+	}
+	return serializeInt64(int64(boolToInt(string(node) == string(root)))), nil
+}
@@ -0,0 +1,86 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"github.com/virtue186/xchain/crypto"
+	"github.com/virtue186/xchain/types"
+	"testing"
+)
+
+func TestPrecompileSHA256FromContract(t *testing.T) {
+	state := newTestStateCache(t)
+	contract := types.Address{1}
+
+	// 合约以 "abc" 调用 SHA-256 预编译并返回结果
+	code := pushBytes(PrecompileSHA256.ToSlice())
+	code = append(code, byte(InstrPushInt), 0, byte(InstrPushInt), 0)
+	code = append(code, pushBytes([]byte("abc"))...)
+	code = append(code, byte(InstrCall), byte(InstrPop), byte(InstrReturn))
+	assert.Nil(t, state.PutCode(contract, code))
+
+	ret, _, gasLeft, err := call(state, types.Address{}, contract, 0, nil, DefaultGasLimit, 0, nil)
+	assert.Nil(t, err)
+	want := sha256.Sum256([]byte("abc"))
+	assert.Equal(t, want[:], ret)
+	assert.Less(t, gasLeft, DefaultGasLimit-GasSHA256-GasCall)
+
+	_, _, gasLeft, err = call(state, types.Address{}, PrecompileSHA256, 0, nil, GasSHA256-1, 0, nil)
+	assert.ErrorIs(t, err, ErrOutOfGas)
+	assert.Equal(t, uint64(0), gasLeft)
+}
+
+func TestPrecompileVerifyP256(t *testing.T) {
+	key := crypto.GeneratePrivateKey()
+	msg := []byte("hello")
+	sig, err := key.Sign(msg)
+	assert.Nil(t, err)
+
+	input := append([]byte{}, key.PublicKey()...)
+	input = append(input, sig.R.FillBytes(make([]byte, 32))...)
+	input = append(input, sig.S.FillBytes(make([]byte, 32))...)
+	ret, err := runVerifyP256(append(input, msg...))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), deserializeInt64(ret))
+
+	ret, err = runVerifyP256(append(input, "other"...))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), deserializeInt64(ret))
+
+	_, err = runVerifyP256(input[:40])
+	assert.NotNil(t, err)
+}
+
+func TestPrecompileMerkleProof(t *testing.T) {
+	hash := func(b ...[]byte) []byte {
+		h := sha256.New()
+		for _, x := range b {
+			h.Write(x)
+		}
+		return h.Sum(nil)
+	}
+	leaves := [][]byte{hash([]byte("a")), hash([]byte("b")), hash([]byte("c")), hash([]byte("d"))}
+	left, right := hash(leaves[0], leaves[1]), hash(leaves[2], leaves[3])
+	root := hash(left, right)
+
+	proof := func(root []byte, index uint64, siblings ...[]byte) []byte {
+		input := append(append([]byte{}, root...), leaves[index]...)
+		input = binary.LittleEndian.AppendUint64(input, index)
+		for _, s := range siblings {
+			input = append(input, s...)
+		}
+		return input
+	}
+
+	ret, err := runMerkleProof(proof(root, 2, leaves[3], left))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), deserializeInt64(ret))
+
+	ret, err = runMerkleProof(proof(root, 2, leaves[2], left))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), deserializeInt64(ret))
+
+	_, err = runMerkleProof(proof(root, 2, leaves[3], left)[:100])
+	assert.NotNil(t, err)
+}
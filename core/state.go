@@ -1,6 +1,9 @@
 package core
 
-import "github.com/virtue186/xchain/types"
+import (
+	"errors"
+	"github.com/virtue186/xchain/types"
+)
 
 // State 管理所有账户的状态，并直接与持久化存储交互
 type State struct {
@@ -33,7 +36,7 @@ func (s *State) Get(addr types.Address) (*AccountState, error) {
 	if err != nil {
 		// 如果错误是因为键不存在，我们返回一个零值账户，而不是错误
 		// 这简化了上层逻辑，因为每个地址都“存在”，只是可能是空的
-		if err.Error() == "leveldb: not found" { // 依赖于具体的DB实现，不是很好
+		if isNotFound(err) {
 			return &AccountState{Address: addr, Balance: 0, Nonce: 0}, nil
 		}
 		return nil, err
@@ -47,12 +50,64 @@ func (s *State) Delete(addr types.Address) error {
 	return s.storage.Delete(accountKey(addr))
 }
 
+// GetStorage 读取合约 addr 在 key 下的存储值，不存在时返回 nil
+func (s *State) GetStorage(addr types.Address, key []byte) ([]byte, error) {
+	data, err := s.storage.Get(storageKey(addr, key))
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+// PutStorage 写入合约 addr 在 key 下的存储值
+func (s *State) PutStorage(addr types.Address, key, value []byte) error {
+	return s.storage.Put(storageKey(addr, key), value)
+}
+
+// GetCode 读取部署在 addr 上的合约字节码，普通账户返回 nil
+func (s *State) GetCode(addr types.Address) ([]byte, error) {
+	data, err := s.storage.Get(codeKey(addr))
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+// PutCode 将合约字节码写入 addr
+func (s *State) PutCode(addr types.Address, code []byte) error {
+	return s.storage.Put(codeKey(addr), code)
+}
+
+// isNotFound 判断存储层返回的错误是否表示键不存在
+func isNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound)
+}
+
 // --- 键名辅助函数 ---
 
 const (
 	accountPrefix = "a"
+	storagePrefix = "s"
+	codePrefix    = "c"
 )
 
 func accountKey(addr types.Address) []byte {
 	return append([]byte(accountPrefix), addr.ToSlice()...)
 }
+
+func storageKey(addr types.Address, key []byte) []byte {
+	b := make([]byte, 0, 1+len(addr)+len(key))
+	b = append(b, storagePrefix...)
+	b = append(b, addr.ToSlice()...)
+	return append(b, key...)
+}
+
+func codeKey(addr types.Address) []byte {
+	return append([]byte(codePrefix), addr.ToSlice()...)
+}
@@ -0,0 +1,166 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/virtue186/xchain/types"
+	"sort"
+)
+
+// StateDB 是合约执行时读写世界状态所需的最小接口
+// *State 直接落盘，*StateCache 则把写入暂存在内存中
+type StateDB interface {
+	Get(types.Address) (*AccountState, error)
+	Put(types.Address, *AccountState) error
+	GetStorage(types.Address, []byte) ([]byte, error)
+	PutStorage(types.Address, []byte, []byte) error
+	GetCode(types.Address) ([]byte, error)
+	PutCode(types.Address, []byte) error
+}
+
+// StateCache 是叠加在另一个 StateDB 之上的写缓存
+// 所有写入只在 Commit 时才会传递给上一层，丢弃缓存即可回滚
+// 嵌套的合约调用通过 Child 创建子缓存，从而实现“只回滚当前调用”
+// parent 为 nil 时，缓存之下是一个空的世界状态，适合在本地试运行合约
+type StateCache struct {
+	parent   StateDB
+	accounts map[types.Address]*AccountState
+	storage  map[types.Address]map[string][]byte
+	code     map[types.Address][]byte
+}
+
+// NewStateCache 创建一个以 parent 为底层的状态缓存
+func NewStateCache(parent StateDB) *StateCache {
+	return &StateCache{
+		parent:   parent,
+		accounts: make(map[types.Address]*AccountState),
+		storage:  make(map[types.Address]map[string][]byte),
+		code:     make(map[types.Address][]byte),
+	}
+}
+
+// Child 返回一个以当前缓存为底层的子缓存
+func (c *StateCache) Child() *StateCache {
+	return NewStateCache(c)
+}
+
+func (c *StateCache) Get(addr types.Address) (*AccountState, error) {
+	if acc, ok := c.accounts[addr]; ok {
+		// 返回副本，避免调用方在 Put 之前就修改了缓存内容
+		cp := *acc
+		return &cp, nil
+	}
+	if c.parent == nil {
+		return &AccountState{Address: addr}, nil
+	}
+	return c.parent.Get(addr)
+}
+
+func (c *StateCache) Put(addr types.Address, state *AccountState) error {
+	cp := *state
+	c.accounts[addr] = &cp
+	return nil
+}
+
+func (c *StateCache) GetStorage(addr types.Address, key []byte) ([]byte, error) {
+	if slots, ok := c.storage[addr]; ok {
+		if v, ok := slots[string(key)]; ok {
+			return v, nil
+		}
+	}
+	if c.parent == nil {
+		return nil, nil
+	}
+	return c.parent.GetStorage(addr, key)
+}
+
+func (c *StateCache) PutStorage(addr types.Address, key, value []byte) error {
+	slots, ok := c.storage[addr]
+	if !ok {
+		slots = make(map[string][]byte)
+		c.storage[addr] = slots
+	}
+	slots[string(key)] = value
+	return nil
+}
+
+func (c *StateCache) GetCode(addr types.Address) ([]byte, error) {
+	if code, ok := c.code[addr]; ok {
+		return code, nil
+	}
+	if c.parent == nil {
+		return nil, nil
+	}
+	return c.parent.GetCode(addr)
+}
+
+func (c *StateCache) PutCode(addr types.Address, code []byte) error {
+	c.code[addr] = code
+	return nil
+}
+
+// Commit 将缓存中的全部写入应用到上一层，并清空缓存
+func (c *StateCache) Commit() error {
+	if c.parent == nil {
+		return fmt.Errorf("state cache has no parent to commit to")
+	}
+	for addr, acc := range c.accounts {
+		if err := c.parent.Put(addr, acc); err != nil {
+			return err
+		}
+	}
+	for addr, slots := range c.storage {
+		for key, value := range slots {
+			if err := c.parent.PutStorage(addr, []byte(key), value); err != nil {
+				return err
+			}
+		}
+	}
+	for addr, code := range c.code {
+		if err := c.parent.PutCode(addr, code); err != nil {
+			return err
+		}
+	}
+	c.Discard()
+	return nil
+}
+
+// Discard 丢弃缓存中尚未提交的全部写入
+func (c *StateCache) Discard() {
+	c.accounts = make(map[types.Address]*AccountState)
+	c.storage = make(map[types.Address]map[string][]byte)
+	c.code = make(map[types.Address][]byte)
+}
+
+// StorageChange 描述了一个存储槽在缓存中的修改
+type StorageChange struct {
+	Address types.Address
+	Key     []byte
+	Old     []byte // 修改前的值，nil 表示原先不存在
+	New     []byte
+}
+
+// StorageChanges 返回缓存中尚未提交的全部存储修改，按地址和键排序
+func (c *StateCache) StorageChanges() ([]StorageChange, error) {
+	changes := []StorageChange{}
+	for addr, slots := range c.storage {
+		for key, value := range slots {
+			var old []byte
+			if c.parent != nil {
+				v, err := c.parent.GetStorage(addr, []byte(key))
+				if err != nil {
+					return nil, err
+				}
+				old = v
+			}
+			changes = append(changes, StorageChange{Address: addr, Key: []byte(key), Old: old, New: value})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if c := bytes.Compare(changes[i].Address[:], changes[j].Address[:]); c != 0 {
+			return c < 0
+		}
+		return bytes.Compare(changes[i].Key, changes[j].Key) < 0
+	})
+	return changes, nil
+}
@@ -0,0 +1,126 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/virtue186/xchain/types"
+)
+
+const journalPrefix = "j"
+
+// stateJournal 记录了一个区块修改过的数据在修改之前的值。
+// 从最新的区块开始依次把旧值写回当前状态，即可得到任意区块执行之前的状态
+type stateJournal struct {
+	Accounts []*AccountState `json:"accounts"`
+	Storage  []storageUndo   `json:"storage"`
+	Code     []codeUndo      `json:"code"`
+}
+
+type storageUndo struct {
+	Address types.Address `json:"address"`
+	Key     []byte        `json:"key"`
+	Value   []byte        `json:"value"` // nil 表示原先不存在
+}
+
+type codeUndo struct {
+	Address types.Address `json:"address"`
+	Code    []byte        `json:"code"`
+}
+
+// newStateJournal 根据缓存中尚未提交的写入，从底层读取它们的旧值
+func newStateJournal(c *StateCache) (*stateJournal, error) {
+	j := &stateJournal{}
+	for addr := range c.accounts {
+		old, err := c.parent.Get(addr)
+		if err != nil {
+			return nil, err
+		}
+		j.Accounts = append(j.Accounts, old)
+	}
+	for addr, slots := range c.storage {
+		for key := range slots {
+			old, err := c.parent.GetStorage(addr, []byte(key))
+			if err != nil {
+				return nil, err
+			}
+			j.Storage = append(j.Storage, storageUndo{Address: addr, Key: []byte(key), Value: old})
+		}
+	}
+	for addr := range c.code {
+		old, err := c.parent.GetCode(addr)
+		if err != nil {
+			return nil, err
+		}
+		j.Code = append(j.Code, codeUndo{Address: addr, Code: old})
+	}
+	return j, nil
+}
+
+// revert 把日志中的旧值写入 state
+func (j *stateJournal) revert(state StateDB) error {
+	for _, acc := range j.Accounts {
+		if err := state.Put(acc.Address, acc); err != nil {
+			return err
+		}
+	}
+	for _, s := range j.Storage {
+		if err := state.PutStorage(s.Address, s.Key, s.Value); err != nil {
+			return err
+		}
+	}
+	for _, c := range j.Code {
+		if err := state.PutCode(c.Address, c.Code); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func journalKey(blockHash types.Hash) []byte {
+	return append([]byte(journalPrefix), blockHash.ToSlice()...)
+}
+
+func (bc *BlockChain) putJournal(blockHash types.Hash, j *stateJournal) error {
+	data, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	return bc.store.Put(journalKey(blockHash), data)
+}
+
+func (bc *BlockChain) getJournal(blockHash types.Hash) (*stateJournal, error) {
+	data, err := bc.store.Get(journalKey(blockHash))
+	if err != nil {
+		if isNotFound(err) {
+			return nil, fmt.Errorf("state history for block %s is not available", blockHash)
+		}
+		return nil, err
+	}
+	j := new(stateJournal)
+	if err := json.Unmarshal(data, j); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// stateAt 返回区块 height 执行之前的状态，修改只保存在返回的缓存中。调用方需持有 insertLock
+func (bc *BlockChain) stateAt(height uint32) (*StateCache, error) {
+	state := NewStateCache(bc.State)
+	for h := bc.Height(); h >= height; h-- {
+		hash, err := bc.store.GetBlockHashByHeight(h)
+		if err != nil {
+			return nil, err
+		}
+		j, err := bc.getJournal(hash)
+		if err != nil {
+			return nil, err
+		}
+		if err := j.revert(state); err != nil {
+			return nil, err
+		}
+		if h == 0 {
+			break
+		}
+	}
+	return state, nil
+}
@@ -1,6 +1,12 @@
 package core
 
-import "github.com/virtue186/xchain/types"
+import (
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/virtue186/xchain/types"
+)
+
+// ErrNotFound 是 Storage 中找不到键时返回的错误，与 LevelDB 返回的错误相同
+var ErrNotFound = leveldb.ErrNotFound
 
 type Storage interface {
 	Close() error
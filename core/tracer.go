@@ -0,0 +1,122 @@
+package core
+
+import (
+	"fmt"
+	"github.com/virtue186/xchain/types"
+)
+
+// Tracer 接收 VM 执行过程中的事件，用于调试合约
+type Tracer interface {
+	// CaptureStep 在每条指令执行之前调用，gas 为执行前剩余的 gas，
+	// cost 为该指令的固定开销，stack 是从栈底到栈顶的快照
+	CaptureStep(addr types.Address, pc int, op Instruction, gas, cost uint64, depth int, stack []any)
+	// CaptureStorage 在合约写入存储之后调用
+	CaptureStorage(addr types.Address, key, value []byte)
+}
+
+// StorageWrite 是一次存储写入
+type StorageWrite struct {
+	Key   []byte
+	Value []byte
+}
+
+// StepLog 记录了一条指令执行前的 VM 状态
+type StepLog struct {
+	Address types.Address // 正在执行的合约
+	Pc      int
+	Op      Instruction
+	Gas     uint64
+	GasCost uint64
+	Depth   int
+	Stack   []any
+	Storage []StorageWrite // 这条指令产生的存储写入
+}
+
+// StructLogger 是把每一步都记录下来的 Tracer
+type StructLogger struct {
+	steps []*StepLog
+}
+
+func NewStructLogger() *StructLogger {
+	return &StructLogger{}
+}
+
+func (l *StructLogger) CaptureStep(addr types.Address, pc int, op Instruction, gas, cost uint64, depth int, stack []any) {
+	l.steps = append(l.steps, &StepLog{
+		Address: addr,
+		Pc:      pc,
+		Op:      op,
+		Gas:     gas,
+		GasCost: cost,
+		Depth:   depth,
+		Stack:   stack,
+	})
+}
+
+func (l *StructLogger) CaptureStorage(addr types.Address, key, value []byte) {
+	// 存储写入总是发生在最近记录的那条 store 指令中
+	if len(l.steps) == 0 {
+		return
+	}
+	last := l.steps[len(l.steps)-1]
+	last.Storage = append(last.Storage, StorageWrite{Key: key, Value: value})
+}
+
+// Steps 返回已记录的全部步骤
+func (l *StructLogger) Steps() []*StepLog {
+	return l.steps
+}
+
+// TraceTransaction 在已上链交易所在区块的父状态上，先重放同一区块中排在它之前的交易，
+// 再在跟踪器下重新执行这笔交易。创世区块没有执行记录，其中的交易无法跟踪
+func (bc *BlockChain) TraceTransaction(hash types.Hash) (*ExecutionResult, []*StepLog, error) {
+	// 查找交易要逐个读取区块，不持有锁，否则查找期间无法加入新区块
+	block, index, err := bc.findTransaction(hash)
+	if err != nil {
+		return nil, nil, err
+	}
+	if block.Height == 0 {
+		return nil, nil, fmt.Errorf("cannot trace transaction %s in the genesis block: genesis state has no history", hash)
+	}
+
+	// 新区块的状态先于区块头提交，只持有 stateLock 时可能看到新状态和旧高度，
+	// 持有 insertLock 保证状态与高度一致
+	bc.insertLock.Lock()
+	defer bc.insertLock.Unlock()
+	state, err := bc.stateAt(block.Height)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, tx := range block.Transactions[:index] {
+		if _, err := executeTransaction(state, tx, nil); err != nil {
+			return nil, nil, fmt.Errorf("failed to replay transaction %d of block %d: %w", i, block.Height, err)
+		}
+	}
+
+	logger := NewStructLogger()
+	res, err := executeTransaction(state, block.Transactions[index], logger)
+	if err != nil {
+		return nil, nil, err
+	}
+	return res, logger.Steps(), nil
+}
+
+// findTransaction 从最新的区块开始查找交易，返回所在区块及其在区块中的下标
+func (bc *BlockChain) findTransaction(hash types.Hash) (*Block, int, error) {
+	for h := int64(bc.Height()); h >= 0; h-- {
+		blockHash, err := bc.store.GetBlockHashByHeight(uint32(h))
+		if err != nil {
+			return nil, 0, err
+		}
+		block, err := bc.store.GetBlockByHash(blockHash)
+		if err != nil {
+			return nil, 0, err
+		}
+		for i, tx := range block.Transactions {
+			if tx.Hash(TxHasher{}) == hash {
+				return block, i, nil
+			}
+		}
+	}
+	return nil, 0, fmt.Errorf("transaction %s not found", hash)
+}
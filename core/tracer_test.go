@@ -0,0 +1,80 @@
+package core
+
+import (
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/virtue186/xchain/crypto"
+	"github.com/virtue186/xchain/types"
+	"testing"
+)
+
+func TestTraceTransaction(t *testing.T) {
+	storage, err := NewLeveldbStorage(t.TempDir())
+	assert.Nil(t, err)
+	t.Cleanup(func() { storage.Close() })
+
+	genesis, _ := NewBlock(&Header{Version: 1}, nil)
+	bc, err := NewBlockChain(log.NewNopLogger(), storage, genesis)
+	assert.Nil(t, err)
+
+	key := crypto.GeneratePrivateKey()
+	sender := key.PublicKey().Address()
+	assert.Nil(t, bc.State.Put(sender, &AccountState{Address: sender, Balance: 1000}))
+
+	nonce := uint64(0)
+	newTx := func(to types.Address, data []byte) *Transaction {
+		tx := NewTransaction(data)
+		tx.To = to
+		tx.Nonce = nonce
+		nonce++
+		assert.Nil(t, tx.Sign(key))
+		return tx
+	}
+	addBlock := func(txx ...*Transaction) {
+		prev, err := bc.GetHeader(bc.Height())
+		assert.Nil(t, err)
+		b, err := NewBlockFromPreHeader(prev, txx)
+		assert.Nil(t, err)
+		assert.Nil(t, b.Sign(key))
+		assert.Nil(t, bc.AddBlock(b))
+	}
+
+	// 合约每次被调用都把计数器 "n" 加一
+	code := append(pushBytes([]byte("n")), pushBytes([]byte("n"))...)
+	code = append(code, byte(InstrLoad), byte(InstrToInt), byte(InstrPushInt), 1, byte(InstrAdd), byte(InstrStore))
+	contract := ContractAddress(sender, 0)
+
+	addBlock(newTx(types.Address{}, code))
+	first, second := newTx(contract, nil), newTx(contract, nil)
+	addBlock(first, second)
+	addBlock(newTx(contract, nil))
+
+	n, _ := bc.State.GetStorage(contract, []byte("n"))
+	assert.Equal(t, int64(3), deserializeInt64(n))
+
+	// 重新执行时看到的应当是第二笔交易执行之前的状态
+	res, steps, err := bc.TraceTransaction(second.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.Nil(t, res.Err)
+	assert.Len(t, steps, 11) // 两次压入 "n" 各 3 条指令，之后是 load、toint、push、add、store
+	last := steps[len(steps)-1]
+	assert.Equal(t, InstrStore, last.Op)
+	assert.Equal(t, []any{[]byte("n"), 2}, last.Stack)
+	assert.Equal(t, []StorageWrite{{Key: []byte("n"), Value: serializeInt64(2)}}, last.Storage)
+
+	// 跟踪不会修改当前状态
+	n, _ = bc.State.GetStorage(contract, []byte("n"))
+	assert.Equal(t, int64(3), deserializeInt64(n))
+}
+
+func TestTraceGenesisTransaction(t *testing.T) {
+	key := crypto.GeneratePrivateKey()
+	tx := NewTransaction(nil)
+	assert.Nil(t, tx.Sign(key))
+	genesis, _ := NewBlock(&Header{Version: 1}, []*Transaction{tx})
+	bc, err := NewBlockChain(log.NewNopLogger(), NewMemoryStorage(), genesis)
+	assert.Nil(t, err)
+
+	_, _, err = bc.TraceTransaction(tx.Hash(TxHasher{}))
+	assert.ErrorContains(t, err, "genesis")
+}
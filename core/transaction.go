@@ -1,7 +1,6 @@
 package core
 
 import (
-	"encoding/json"
 	"fmt"
 	"github.com/virtue186/xchain/crypto"
 	"github.com/virtue186/xchain/types"
@@ -55,6 +54,7 @@ func (tx *Transaction) Sign(privateKey crypto.PrivateKey) error {
 
 	tx.From = privateKey.PublicKey()
 	tx.Signature = sign
+	tx.hash = types.Hash{} // 签名改变了交易哈希
 	return nil
 }
 
@@ -82,17 +82,6 @@ func (tx *Transaction) GetFirstSeen() int64 {
 	return tx.firstSeen
 }
 
-// encodeForSignature 是一个内部辅助方法，用于将需要签名的数据编码
-func (tx *Transaction) encodeForSignature() ([]byte, error) {
-	// 创建一个不包含签名和公钥的临时副本用于签名
-	// 这是为了防止签名数据包含上一次的签名结果
-	txCopy := *tx
-	txCopy.Signature = nil
-	txCopy.From = nil
-
-	return json.Marshal(&txCopy)
-}
-
 // Encode 将整个交易编码到写入器
 func (tx *Transaction) Encode(w io.Writer, enc Encoder[*Transaction]) error {
 	return enc.Encode(w, tx)
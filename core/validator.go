@@ -1,6 +1,12 @@
 package core
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidBlock 表示区块本身无效（签名、交易或数据哈希错误），而不是与本地链的高度或分叉不匹配
+var ErrInvalidBlock = errors.New("invalid block")
 
 type Validator interface {
 	ValidateBlock(*Block) error
@@ -34,7 +40,7 @@ func (v BlockValidator) ValidateBlock(b *Block) error {
 	}
 
 	if err := b.Verify(); err != nil {
-		return err
+		return fmt.Errorf("%w: %v", ErrInvalidBlock, err)
 	}
 	return nil
 }
@@ -1,8 +1,11 @@
 package core
 
 import (
+	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"github.com/virtue186/xchain/types"
 )
 
 type Instruction byte
@@ -14,13 +17,154 @@ const (
 	InstrPack     Instruction = 0x0d
 	InstrSub      Instruction = 0x0e
 	InstrStore    Instruction = 0x0f
+	InstrCall     Instruction = 0x10 // 调用另一个合约: addr, value, gas, input -> ret, success
+	InstrReturn   Instruction = 0x11 // 以栈顶的字节串作为返回值结束执行
+	InstrRevert   Instruction = 0x12 // 以栈顶的字节串作为原因回滚当前调用
+	InstrJump     Instruction = 0x13 // 无条件跳转到 2 字节立即数给出的位置
+	InstrJumpIf   Instruction = 0x14 // 弹出条件，非 0 时跳转到 2 字节立即数给出的位置
+	InstrPushInt8 Instruction = 0x15 // 压入 8 字节大端有符号整数立即数
+	InstrMul      Instruction = 0x16
+	InstrDiv      Instruction = 0x17
+	InstrMod      Instruction = 0x18
+	InstrEq       Instruction = 0x19 // 比较两个整数或两个字节串，相等压入 1，否则压入 0
+	InstrLt       Instruction = 0x1a
+	InstrGt       Instruction = 0x1b
+	InstrNot      Instruction = 0x1c // 0 变为 1，非 0 变为 0
+	InstrAnd      Instruction = 0x1d
+	InstrOr       Instruction = 0x1e
+	InstrLoad     Instruction = 0x1f // 读取合约存储: key -> value，不存在时为空字节串
+	InstrToInt    Instruction = 0x20 // 8 字节小端字节串转为整数，空字节串视为 0
+	InstrToBytes  Instruction = 0x21 // 整数转为 8 字节小端字节串，与 InstrStore 的编码一致
+	InstrConcat   Instruction = 0x22 // 拼接两个字节串
+	InstrLen      Instruction = 0x23 // 字节串的长度
+	InstrPop      Instruction = 0x24
+	InstrLoadLoc  Instruction = 0x25 // 压入 1 字节立即数编号的局部变量
+	InstrStoreLoc Instruction = 0x26 // 弹出栈顶并写入 1 字节立即数编号的局部变量
+	InstrCaller   Instruction = 0x27 // 压入调用方地址
+	InstrValue    Instruction = 0x28 // 压入随调用转入的金额
+	InstrInput    Instruction = 0x29 // 压入调用数据
+	InstrLog      Instruction = 0x2a // 记录事件: topic, data
+	InstrDup      Instruction = 0x2b // 复制栈顶元素
+	InstrSwap     Instruction = 0x2c // 交换栈顶的两个元素
+	InstrSlice    Instruction = 0x2d // 截取字节串: b, start, end -> b[start:end]
+)
+
+// instructionInfo 记录了每条指令的助记符和紧随其后的立即数长度，
+// 供汇编器、反汇编器等工具使用
+var instructionInfo = map[Instruction]struct {
+	name    string
+	operand int
+}{
+	InstrPushInt:  {"push", 1},
+	InstrAdd:      {"add", 0},
+	InstrPushByte: {"pushb", 1},
+	InstrPack:     {"pack", 0},
+	InstrSub:      {"sub", 0},
+	InstrStore:    {"store", 0},
+	InstrCall:     {"call", 0},
+	InstrReturn:   {"return", 0},
+	InstrRevert:   {"revert", 0},
+	InstrJump:     {"jump", 2},
+	InstrJumpIf:   {"jumpi", 2},
+	InstrPushInt8: {"push8", 8},
+	InstrMul:      {"mul", 0},
+	InstrDiv:      {"div", 0},
+	InstrMod:      {"mod", 0},
+	InstrEq:       {"eq", 0},
+	InstrLt:       {"lt", 0},
+	InstrGt:       {"gt", 0},
+	InstrNot:      {"not", 0},
+	InstrAnd:      {"and", 0},
+	InstrOr:       {"or", 0},
+	InstrLoad:     {"load", 0},
+	InstrToInt:    {"toint", 0},
+	InstrToBytes:  {"tobytes", 0},
+	InstrConcat:   {"concat", 0},
+	InstrLen:      {"len", 0},
+	InstrPop:      {"pop", 0},
+	InstrLoadLoc:  {"lload", 1},
+	InstrStoreLoc: {"lstore", 1},
+	InstrCaller:   {"caller", 0},
+	InstrValue:    {"callvalue", 0},
+	InstrInput:    {"input", 0},
+	InstrLog:      {"log", 0},
+	InstrDup:      {"dup", 0},
+	InstrSwap:     {"swap", 0},
+	InstrSlice:    {"slice", 0},
+}
+
+// String 返回指令的助记符
+func (i Instruction) String() string {
+	if info, ok := instructionInfo[i]; ok {
+		return info.name
+	}
+	return fmt.Sprintf("0x%02x", byte(i))
+}
+
+// OperandSize 返回指令立即数的字节数，未知指令返回 -1
+func (i Instruction) OperandSize() int {
+	if info, ok := instructionInfo[i]; ok {
+		return info.operand
+	}
+	return -1
+}
+
+// InstructionByName 根据助记符查找指令
+func InstructionByName(name string) (Instruction, bool) {
+	for instr, info := range instructionInfo {
+		if info.name == name {
+			return instr, true
+		}
+	}
+	return 0, false
+}
+
+const (
+	// DefaultGasLimit 是未指定 gas 时单次执行可用的 gas
+	DefaultGasLimit uint64 = 1_000_000
+	// MaxCallDepth 是合约之间嵌套调用的最大深度
+	MaxCallDepth = 64
+
+	GasDefault   uint64 = 1   // 普通栈操作
+	GasLoad      uint64 = 50  // 读取合约存储
+	GasStore     uint64 = 100 // 写入合约存储
+	GasLog       uint64 = 50  // 记录一条事件
+	GasCall      uint64 = 40  // 发起一次合约调用（不含被调用方消耗的 gas）
+	GasByte      uint64 = 1   // 拼接、打包、复制字节串时每产生 1 字节
+	GasStoreByte uint64 = 10  // 写入合约存储时键和值的每 1 字节
+
+	// MaxBytesLength 是栈上单个字节串的最大长度
+	MaxBytesLength = 64 * 1024
+)
+
+var (
+	ErrOutOfGas          = errors.New("out of gas")
+	ErrExecutionReverted = errors.New("execution reverted")
+	ErrMaxCallDepth      = errors.New("max call depth exceeded")
+	ErrBytesTooLong      = errors.New("byte string too long")
 )
 
 type VM struct {
 	data          []byte
 	pc            int    // 指向下一条要执行的字节的位置
 	stack         *Stack // 栈
-	contractState *State
+	contractState *StateCache
+	contract      Contract // 当前执行的合约上下文
+	gas           uint64   // 剩余可用的 gas
+	depth         int      // 当前调用深度，外部交易发起的调用为 0
+	returnData    []byte   // RETURN/REVERT 留下的数据
+	halted        bool
+	locals        [256]any // 局部变量槽
+	logs          []*Log   // 本次执行（含成功的子调用）产生的事件
+	tracer        Tracer   // 可以为 nil
+	jumpDests     []bool   // 每个位置是否是一条指令的开头，只能跳转到这些位置
+}
+
+// Log 是合约通过 InstrLog 记录的一条事件
+type Log struct {
+	Address types.Address // 产生事件的合约
+	Topic   []byte
+	Data    []byte
 }
 
 type Stack struct {
@@ -61,18 +205,75 @@ func NewStack(size int) *Stack {
 	}
 }
 
-func NewVm(data []byte, state *State) *VM {
+func NewVm(data []byte, state *StateCache) *VM {
+	return NewContractVm(data, state, Contract{}, DefaultGasLimit)
+}
+
+// NewContractVm 创建一个在指定合约上下文中执行 data 的虚拟机
+func NewContractVm(data []byte, state *StateCache, contract Contract, gas uint64) *VM {
 	return &VM{
 		data:          data,
 		stack:         NewStack(1024),
 		pc:            0,
 		contractState: state,
+		contract:      contract,
+		gas:           gas,
+		jumpDests:     jumpDests(data),
+	}
+}
+
+// jumpDests 标记字节码中每条指令开头的位置，立即数中的字节不能作为跳转目标
+func jumpDests(data []byte) []bool {
+	dests := make([]bool, len(data))
+	for pc := 0; pc < len(data); pc++ {
+		dests[pc] = true
+		if n := Instruction(data[pc]).OperandSize(); n > 0 {
+			pc += n
+		}
 	}
+	return dests
 }
 
-func (vm *VM) Run() error {
-	for vm.pc < len(vm.data) {
+// Gas 返回剩余可用的 gas
+func (vm *VM) Gas() uint64 {
+	return vm.gas
+}
+
+// Stack 按从栈底到栈顶的顺序返回当前栈上的全部元素
+func (vm *VM) Stack() []any {
+	items := make([]any, vm.stack.sp+1)
+	copy(items, vm.stack.data[:vm.stack.sp+1])
+	return items
+}
+
+// Logs 返回本次执行产生的事件
+func (vm *VM) Logs() []*Log {
+	return vm.logs
+}
+
+// SetTracer 设置逐条指令的跟踪器，子调用会沿用同一个跟踪器
+func (vm *VM) SetTracer(t Tracer) {
+	vm.tracer = t
+}
+
+// ReturnData 返回 RETURN 或 REVERT 留下的数据
+func (vm *VM) ReturnData() []byte {
+	return vm.returnData
+}
+
+func (vm *VM) Run() (err error) {
+	// 错误的字节码可能导致栈下溢或类型断言失败，这些都应当只让本次执行失败
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("vm panic at pc=%d: %v", vm.pc, r)
+		}
+	}()
+
+	for vm.pc < len(vm.data) && !vm.halted {
 		instr := vm.data[vm.pc]
+		if vm.tracer != nil {
+			vm.tracer.CaptureStep(vm.contract.Address, vm.pc, Instruction(instr), vm.gas, instrGas(Instruction(instr)), vm.depth, vm.Stack())
+		}
 		if err := vm.Exec(Instruction(instr)); err != nil {
 			return err // 如果 Exec 出错，立即返回
 		}
@@ -82,29 +283,45 @@ func (vm *VM) Run() error {
 }
 
 func (vm *VM) Exec(instr Instruction) error {
-	switch instr {
+	if err := vm.useGas(instrGas(instr)); err != nil {
+		return err
+	}
 
-	//case InstrStore:
-	//	// 假设栈顶是 value，次顶是 key
-	//	value := vm.stack.Pop()
-	//	fmt.Println(value)
-	//	key := vm.stack.Pop().([]byte)
-	//	fmt.Println(key)
-	//	var serializedValue []byte
-	//	switch v := value.(type) {
-	//	case int:
-	//		serializedValue = serializeInt64(int64(v))
-	//	default:
-	//		panic("TODO: unknown type")
-	//	}
-	//	if err := vm.contractState.Put(key, serializedValue); err != nil {
-	//		return err
-	//	}
-	//	vm.pc++
+	switch instr {
+	case InstrStore:
+		// 栈顶是 value，次顶是 key
+		value := vm.stack.Pop()
+		key, err := vm.popBytes()
+		if err != nil {
+			return err
+		}
+		var serializedValue []byte
+		switch v := value.(type) {
+		case int:
+			serializedValue = serializeInt64(int64(v))
+		case byte:
+			serializedValue = []byte{v}
+		case []byte:
+			serializedValue = v
+		default:
+			return fmt.Errorf("cannot store value of type %T", value)
+		}
+		if err := vm.useGas(GasStoreByte * uint64(len(key)+len(serializedValue))); err != nil {
+			return err
+		}
+		if err := vm.contractState.PutStorage(vm.contract.Address, key, serializedValue); err != nil {
+			return err
+		}
+		if vm.tracer != nil {
+			vm.tracer.CaptureStorage(vm.contract.Address, key, serializedValue)
+		}
+		vm.pc++
 
 	case InstrPushInt:
 		val := int(vm.data[vm.pc+1])
-		vm.stack.Push(val)
+		if err := vm.stack.Push(val); err != nil {
+			return err
+		}
 		vm.pc += 2
 	case InstrAdd:
 		a := vm.stack.Pop().(int)
@@ -113,13 +330,18 @@ func (vm *VM) Exec(instr Instruction) error {
 		vm.pc++
 	case InstrPushByte:
 		val := vm.data[vm.pc+1]
-		vm.stack.Push(val)
+		if err := vm.stack.Push(val); err != nil {
+			return err
+		}
 		vm.pc += 2
 	case InstrPack:
 		n := vm.stack.Pop().(int)
 		if n < 0 || n > vm.stack.sp+1 {
 			return fmt.Errorf("invalid pack length: %d", n)
 		}
+		if err := vm.useBytesGas(n); err != nil {
+			return err
+		}
 		b := make([]byte, n)
 		for i := n - 1; i >= 0; i-- {
 			b[i] = vm.stack.Pop().(byte)
@@ -131,6 +353,214 @@ func (vm *VM) Exec(instr Instruction) error {
 		a := vm.stack.Pop().(int)
 		vm.stack.Push(a - b)
 		vm.pc++
+
+	case InstrCall:
+		if err := vm.execCall(); err != nil {
+			return err
+		}
+		vm.pc++
+	case InstrReturn:
+		ret, err := vm.popBytes()
+		if err != nil {
+			return err
+		}
+		vm.returnData = ret
+		vm.halted = true
+		vm.pc++
+	case InstrRevert:
+		reason, err := vm.popBytes()
+		if err != nil {
+			return err
+		}
+		vm.returnData = reason
+		vm.halted = true
+		return ErrExecutionReverted
+	case InstrJump:
+		return vm.jump(vm.readUint16())
+	case InstrJumpIf:
+		target := vm.readUint16()
+		cond, err := vm.popInt()
+		if err != nil {
+			return err
+		}
+		if cond != 0 {
+			return vm.jump(target)
+		}
+		vm.pc += 3
+	case InstrPushInt8:
+		val := int(int64(binary.BigEndian.Uint64(vm.data[vm.pc+1 : vm.pc+9])))
+		if err := vm.stack.Push(val); err != nil {
+			return err
+		}
+		vm.pc += 9
+
+	case InstrMul, InstrDiv, InstrMod, InstrLt, InstrGt, InstrAnd, InstrOr:
+		b, err := vm.popInt()
+		if err != nil {
+			return err
+		}
+		a, err := vm.popInt()
+		if err != nil {
+			return err
+		}
+		res, err := intBinaryOp(instr, a, b)
+		if err != nil {
+			return fmt.Errorf("%w at pc=%d", err, vm.pc)
+		}
+		vm.stack.Push(res)
+		vm.pc++
+	case InstrEq:
+		b := vm.stack.Pop()
+		a := vm.stack.Pop()
+		eq, err := stackValuesEqual(a, b)
+		if err != nil {
+			return fmt.Errorf("%w at pc=%d", err, vm.pc)
+		}
+		vm.stack.Push(boolToInt(eq))
+		vm.pc++
+	case InstrNot:
+		a, err := vm.popInt()
+		if err != nil {
+			return err
+		}
+		vm.stack.Push(boolToInt(a == 0))
+		vm.pc++
+
+	case InstrLoad:
+		key, err := vm.popBytes()
+		if err != nil {
+			return err
+		}
+		value, err := vm.contractState.GetStorage(vm.contract.Address, key)
+		if err != nil {
+			return err
+		}
+		if value == nil {
+			value = []byte{}
+		}
+		vm.stack.Push(value)
+		vm.pc++
+	case InstrToInt:
+		b, err := vm.popBytes()
+		if err != nil {
+			return err
+		}
+		switch len(b) {
+		case 0:
+			vm.stack.Push(0)
+		case 8:
+			vm.stack.Push(int(deserializeInt64(b)))
+		default:
+			return fmt.Errorf("cannot convert %d bytes to int at pc=%d", len(b), vm.pc)
+		}
+		vm.pc++
+	case InstrToBytes:
+		a, err := vm.popInt()
+		if err != nil {
+			return err
+		}
+		vm.stack.Push(serializeInt64(int64(a)))
+		vm.pc++
+	case InstrConcat:
+		b, err := vm.popBytes()
+		if err != nil {
+			return err
+		}
+		a, err := vm.popBytes()
+		if err != nil {
+			return err
+		}
+		if err := vm.useBytesGas(len(a) + len(b)); err != nil {
+			return err
+		}
+		res := make([]byte, 0, len(a)+len(b))
+		vm.stack.Push(append(append(res, a...), b...))
+		vm.pc++
+	case InstrLen:
+		b, err := vm.popBytes()
+		if err != nil {
+			return err
+		}
+		vm.stack.Push(len(b))
+		vm.pc++
+	case InstrSlice:
+		end, err := vm.popInt()
+		if err != nil {
+			return err
+		}
+		start, err := vm.popInt()
+		if err != nil {
+			return err
+		}
+		b, err := vm.popBytes()
+		if err != nil {
+			return err
+		}
+		if start < 0 || end < start || end > len(b) {
+			return fmt.Errorf("slice bounds [%d:%d] out of range for length %d at pc=%d", start, end, len(b), vm.pc)
+		}
+		vm.stack.Push(b[start:end])
+		vm.pc++
+	case InstrPop:
+		vm.stack.Pop()
+		vm.pc++
+	case InstrDup:
+		top, err := vm.stack.Top()
+		if err != nil {
+			return err
+		}
+		if b, ok := top.([]byte); ok {
+			if err := vm.useBytesGas(len(b)); err != nil {
+				return err
+			}
+		}
+		if err := vm.stack.Push(top); err != nil {
+			return err
+		}
+		vm.pc++
+	case InstrSwap:
+		a := vm.stack.Pop()
+		b := vm.stack.Pop()
+		vm.stack.Push(a)
+		vm.stack.Push(b)
+		vm.pc++
+
+	case InstrLoadLoc:
+		if err := vm.stack.Push(vm.locals[vm.data[vm.pc+1]]); err != nil {
+			return err
+		}
+		vm.pc += 2
+	case InstrStoreLoc:
+		vm.locals[vm.data[vm.pc+1]] = vm.stack.Pop()
+		vm.pc += 2
+
+	case InstrCaller:
+		if err := vm.stack.Push(vm.contract.Caller.ToSlice()); err != nil {
+			return err
+		}
+		vm.pc++
+	case InstrValue:
+		if err := vm.stack.Push(int(vm.contract.Value)); err != nil {
+			return err
+		}
+		vm.pc++
+	case InstrInput:
+		if err := vm.stack.Push(vm.contract.Input); err != nil {
+			return err
+		}
+		vm.pc++
+	case InstrLog:
+		data, err := vm.popBytes()
+		if err != nil {
+			return err
+		}
+		topic, err := vm.popBytes()
+		if err != nil {
+			return err
+		}
+		vm.logs = append(vm.logs, &Log{Address: vm.contract.Address, Topic: topic, Data: data})
+		vm.pc++
+
 	default:
 		return fmt.Errorf("invalid instruction: 0x%x at pc=%d", instr, vm.pc)
 	}
@@ -138,6 +568,167 @@ func (vm *VM) Exec(instr Instruction) error {
 	return nil
 }
 
+// execCall 执行 InstrCall：依次弹出 input、gas、value、addr，
+// 在独立的 VM 中运行目标合约，然后压入返回数据和成功标志（1 或 0）。
+// 被调用方失败只会回滚它自己的状态修改，不会让调用方失败。
+func (vm *VM) execCall() error {
+	input, err := vm.popBytes()
+	if err != nil {
+		return err
+	}
+	gasReq, err := vm.popInt()
+	if err != nil {
+		return err
+	}
+	value, err := vm.popInt()
+	if err != nil {
+		return err
+	}
+	addr, err := vm.popBytes()
+	if err != nil {
+		return err
+	}
+	if len(addr) != len(types.Address{}) {
+		return fmt.Errorf("invalid call address length: %d", len(addr))
+	}
+	if gasReq < 0 || value < 0 {
+		return fmt.Errorf("invalid call arguments: gas=%d value=%d", gasReq, value)
+	}
+
+	// gas 为 0 表示把剩余的 gas 全部交给被调用方
+	gas := vm.gas
+	if gasReq > 0 && uint64(gasReq) < gas {
+		gas = uint64(gasReq)
+	}
+
+	ret, logs, gasLeft, callErr := call(vm.contractState, vm.contract.Address, types.AddressFromBytes(addr), uint64(value), input, gas, vm.depth+1, vm.tracer)
+	vm.gas -= gas - gasLeft
+
+	success := 1
+	if callErr != nil {
+		success = 0
+	} else {
+		vm.logs = append(vm.logs, logs...)
+	}
+	if err := vm.stack.Push(ret); err != nil {
+		return err
+	}
+	return vm.stack.Push(success)
+}
+
+// readUint16 读取当前指令之后的 2 字节大端立即数
+func (vm *VM) readUint16() int {
+	return int(binary.BigEndian.Uint16(vm.data[vm.pc+1 : vm.pc+3]))
+}
+
+func (vm *VM) jump(target int) error {
+	if target >= len(vm.data) || !vm.jumpDests[target] {
+		return fmt.Errorf("invalid jump target %d at pc=%d", target, vm.pc)
+	}
+	vm.pc = target
+	return nil
+}
+
+func (vm *VM) useGas(amount uint64) error {
+	if vm.gas < amount {
+		vm.gas = 0
+		return ErrOutOfGas
+	}
+	vm.gas -= amount
+	return nil
+}
+
+// useBytesGas 为产生长度为 n 的字节串收取 gas，超过 MaxBytesLength 时返回错误
+func (vm *VM) useBytesGas(n int) error {
+	if n > MaxBytesLength {
+		return fmt.Errorf("%w: %d bytes at pc=%d", ErrBytesTooLong, n, vm.pc)
+	}
+	return vm.useGas(GasByte * uint64(n))
+}
+
+func (vm *VM) popInt() (int, error) {
+	v, ok := vm.stack.Pop().(int)
+	if !ok {
+		return 0, fmt.Errorf("expected int on stack at pc=%d", vm.pc)
+	}
+	return v, nil
+}
+
+func (vm *VM) popBytes() ([]byte, error) {
+	v, ok := vm.stack.Pop().([]byte)
+	if !ok {
+		return nil, fmt.Errorf("expected bytes on stack at pc=%d", vm.pc)
+	}
+	return v, nil
+}
+
+// intBinaryOp 计算两个整数操作数的二元运算
+func intBinaryOp(instr Instruction, a, b int) (int, error) {
+	switch instr {
+	case InstrMul:
+		return a * b, nil
+	case InstrDiv, InstrMod:
+		if b == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		if instr == InstrDiv {
+			return a / b, nil
+		}
+		return a % b, nil
+	case InstrLt:
+		return boolToInt(a < b), nil
+	case InstrGt:
+		return boolToInt(a > b), nil
+	case InstrAnd:
+		return boolToInt(a != 0 && b != 0), nil
+	case InstrOr:
+		return boolToInt(a != 0 || b != 0), nil
+	}
+	return 0, fmt.Errorf("not an integer operation: %s", instr)
+}
+
+// stackValuesEqual 比较两个同类型的栈元素
+func stackValuesEqual(a, b any) (bool, error) {
+	switch av := a.(type) {
+	case int:
+		if bv, ok := b.(int); ok {
+			return av == bv, nil
+		}
+	case byte:
+		if bv, ok := b.(byte); ok {
+			return av == bv, nil
+		}
+	case []byte:
+		if bv, ok := b.([]byte); ok {
+			return bytes.Equal(av, bv), nil
+		}
+	}
+	return false, fmt.Errorf("cannot compare %T with %T", a, b)
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// instrGas 返回执行一条指令需要的基础 gas
+func instrGas(instr Instruction) uint64 {
+	switch instr {
+	case InstrLoad:
+		return GasLoad
+	case InstrLog:
+		return GasLog
+	case InstrStore:
+		return GasStore
+	case InstrCall:
+		return GasCall
+	default:
+		return GasDefault
+	}
+}
+
 func serializeInt64(value int64) []byte {
 	buf := make([]byte, 8)
 
@@ -1,11 +1,27 @@
 package core
 
 import (
-	"fmt"
 	"github.com/stretchr/testify/assert"
+	"github.com/virtue186/xchain/types"
 	"testing"
 )
 
+func newTestStateCache(t *testing.T) *StateCache {
+	storage, err := NewLeveldbStorage(t.TempDir())
+	assert.Nil(t, err)
+	t.Cleanup(func() { storage.Close() })
+	return NewStateCache(NewState(storage))
+}
+
+// pushBytes 生成把 b 作为字节串压栈的指令序列
+func pushBytes(b []byte) []byte {
+	code := []byte{}
+	for _, c := range b {
+		code = append(code, byte(InstrPushByte), c)
+	}
+	return append(code, byte(InstrPushInt), byte(len(b)), byte(InstrPack))
+}
+
 func TestVM_Run(t *testing.T) {
 
 	code := []byte{
@@ -19,12 +35,147 @@ func TestVM_Run(t *testing.T) {
 		byte(InstrAdd),
 		byte(InstrStore),
 	}
-	vm := NewVm(code, NewState())
-	vm.Run()
-	fmt.Println(vm.contractState)
-	value, err := vm.contractState.Get([]byte("foo"))
+	state := newTestStateCache(t)
+	vm := NewVm(code, state)
+	assert.Nil(t, vm.Run())
+	value, err := state.GetStorage(types.Address{}, []byte("foo"))
 	newvalue := deserializeInt64(value)
 	assert.Nil(t, err)
 	assert.Equal(t, newvalue, int64(5))
 
 }
+
+func TestVM_CallWithValue(t *testing.T) {
+	state := newTestStateCache(t)
+	caller := types.Address{1}
+	callee := types.Address{2}
+
+	// 被调用方写入 "k" = 7 并返回 "ok"
+	calleeCode := append(pushBytes([]byte("k")), byte(InstrPushInt), 7, byte(InstrStore))
+	calleeCode = append(calleeCode, pushBytes([]byte("ok"))...)
+	calleeCode = append(calleeCode, byte(InstrReturn))
+	assert.Nil(t, state.PutCode(callee, calleeCode))
+	assert.Nil(t, state.Put(caller, &AccountState{Address: caller, Balance: 100}))
+
+	code := pushBytes(callee.ToSlice())
+	code = append(code, byte(InstrPushInt), 30, byte(InstrPushInt), 0)
+	code = append(code, pushBytes(nil)...)
+	code = append(code, byte(InstrCall))
+
+	vm := NewContractVm(code, state, Contract{Address: caller}, DefaultGasLimit)
+	assert.Nil(t, vm.Run())
+
+	success := vm.stack.Pop()
+	ret := vm.stack.Pop()
+	assert.Equal(t, 1, success)
+	assert.Equal(t, []byte("ok"), ret)
+
+	value, err := state.GetStorage(callee, []byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7), deserializeInt64(value))
+
+	callerState, _ := state.Get(caller)
+	calleeState, _ := state.Get(callee)
+	assert.Equal(t, uint64(70), callerState.Balance)
+	assert.Equal(t, uint64(30), calleeState.Balance)
+}
+
+func TestVM_CallRevertOnlyRollsBackCallee(t *testing.T) {
+	state := newTestStateCache(t)
+	caller := types.Address{1}
+	callee := types.Address{2}
+
+	// 被调用方先写入存储，然后回滚
+	calleeCode := append(pushBytes([]byte("k")), byte(InstrPushInt), 7, byte(InstrStore))
+	calleeCode = append(calleeCode, pushBytes([]byte("no"))...)
+	calleeCode = append(calleeCode, byte(InstrRevert))
+	assert.Nil(t, state.PutCode(callee, calleeCode))
+	assert.Nil(t, state.Put(caller, &AccountState{Address: caller, Balance: 100}))
+
+	// 调用方在调用前后各写入一次存储
+	code := append(pushBytes([]byte("a")), byte(InstrPushInt), 1, byte(InstrStore))
+	code = append(code, pushBytes(callee.ToSlice())...)
+	code = append(code, byte(InstrPushInt), 30, byte(InstrPushInt), 0)
+	code = append(code, pushBytes(nil)...)
+	code = append(code, byte(InstrCall))
+	code = append(code, pushBytes([]byte("b"))...)
+	code = append(code, byte(InstrPushInt), 2, byte(InstrStore))
+
+	vm := NewContractVm(code, state, Contract{Address: caller}, DefaultGasLimit)
+	assert.Nil(t, vm.Run())
+
+	success := vm.stack.Pop()
+	ret := vm.stack.Pop()
+	assert.Equal(t, 0, success)
+	assert.Equal(t, []byte("no"), ret)
+
+	value, _ := state.GetStorage(callee, []byte("k"))
+	assert.Nil(t, value)
+	a, _ := state.GetStorage(caller, []byte("a"))
+	b, _ := state.GetStorage(caller, []byte("b"))
+	assert.Equal(t, int64(1), deserializeInt64(a))
+	assert.Equal(t, int64(2), deserializeInt64(b))
+
+	callerState, _ := state.Get(caller)
+	calleeState, _ := state.Get(callee)
+	assert.Equal(t, uint64(100), callerState.Balance)
+	assert.Equal(t, uint64(0), calleeState.Balance)
+}
+
+func TestVM_CallOutOfGas(t *testing.T) {
+	state := newTestStateCache(t)
+	callee := types.Address{2}
+
+	// 被调用方无限地写入存储直到 gas 耗尽
+	calleeCode := append(pushBytes([]byte("k")), byte(InstrPushInt), 1, byte(InstrStore))
+	for i := 0; i < 10; i++ {
+		calleeCode = append(calleeCode, calleeCode...)
+	}
+	assert.Nil(t, state.PutCode(callee, calleeCode))
+
+	code := pushBytes(callee.ToSlice())
+	code = append(code, byte(InstrPushInt), 0, byte(InstrPushInt), 200)
+	code = append(code, pushBytes(nil)...)
+	code = append(code, byte(InstrCall))
+
+	vm := NewContractVm(code, state, Contract{Address: types.Address{1}}, 10_000)
+	assert.Nil(t, vm.Run())
+	assert.Equal(t, 0, vm.stack.Pop())
+	assert.True(t, vm.Gas() > 10_000-1_000)
+
+	value, _ := state.GetStorage(callee, []byte("k"))
+	assert.Nil(t, value)
+}
+
+func TestVM_BytesDoublingLoop(t *testing.T) {
+	// 反复执行 dup; concat; jump，每一轮让栈顶字节串的长度翻倍
+	code := pushBytes([]byte("ab"))
+	loop := len(code)
+	code = append(code, byte(InstrDup), byte(InstrConcat), byte(InstrJump), byte(loop>>8), byte(loop))
+
+	// 长度超过上限时执行失败，消耗的 gas 与产生的字节数相当
+	vm := NewVm(code, newTestStateCache(t))
+	assert.ErrorIs(t, vm.Run(), ErrBytesTooLong)
+	assert.Less(t, DefaultGasLimit-vm.Gas(), uint64(5*MaxBytesLength))
+
+	// gas 较少时在达到上限之前耗尽
+	vm = NewContractVm(code, newTestStateCache(t), Contract{}, 10_000)
+	assert.ErrorIs(t, vm.Run(), ErrOutOfGas)
+	assert.Equal(t, uint64(0), vm.Gas())
+}
+
+func TestVM_JumpIntoOperand(t *testing.T) {
+	// push8 的立即数中恰好包含 push 7 的编码
+	operand := []byte{0, byte(InstrPushInt), 7, 0, 0, 0, 0, 0}
+	code := func(target byte) []byte {
+		return append([]byte{byte(InstrJump), 0, target, byte(InstrPushInt8)}, operand...)
+	}
+
+	vm := NewVm(code(3), newTestStateCache(t))
+	assert.Nil(t, vm.Run())
+	assert.Equal(t, 1, len(vm.Stack()))
+
+	// 跳到立即数中间会把数据当作指令执行，必须拒绝
+	vm = NewVm(code(5), newTestStateCache(t))
+	assert.ErrorContains(t, vm.Run(), "invalid jump target 5")
+}
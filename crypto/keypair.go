@@ -72,6 +72,9 @@ func (sig Signature) String() string {
 
 func (sig Signature) Verify(pubKey PublicKey, data []byte) bool {
 	x, y := elliptic.UnmarshalCompressed(elliptic.P256(), pubKey)
+	if x == nil || sig.R == nil || sig.S == nil {
+		return false
+	}
 	key := &ecdsa.PublicKey{
 		Curve: elliptic.P256(),
 		X:     x,
@@ -107,6 +110,7 @@ func NewPrivateKeyFromHex(hexKey string) (PrivateKey, error) {
 	}, nil
 }
 
+// String 返回 32 字节私钥的十六进制编码，可以由 NewPrivateKeyFromHex 解析
 func (k PrivateKey) String() string {
-	return hex.EncodeToString(k.key.D.Bytes())
+	return hex.EncodeToString(k.key.D.FillBytes(make([]byte, 32)))
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
@@ -12,12 +13,15 @@ import (
 	"github.com/virtue186/xchain/node"
 	"github.com/virtue186/xchain/types"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
 	"time"
 )
 
 // Genesis 定义了 genesis.json 文件的结构
 type Genesis struct {
+	ChainID      string                       `json:"chain_id"`
 	Header       *core.Header                 `json:"header"`
 	Transactions []*core.Transaction          `json:"transactions"`
 	Alloc        map[string]map[string]uint64 `json:"alloc"`
@@ -49,28 +53,31 @@ func main() {
 
 	// 3. 创建并启动三个节点
 	fmt.Println("Starting blockchain nodes...")
-	validatorTr, _ := makeNode("127.0.0.1:3000", "127.0.0.1:8000", &validatorKey, genesisData)
-	remoteATr, _ := makeNode("127.0.0.1:4000", "127.0.0.1:8001", nil, genesisData)
-	remoteBTr, _ := makeNode("127.0.0.1:5000", "127.0.0.1:8002", nil, genesisData)
-
-	// 4. 等待节点启动，然后建立P2P连接
-	time.Sleep(1 * time.Second)
-	fmt.Println("Connecting nodes...")
-	if err := remoteATr.Dial(string(validatorTr.Addr())); err != nil {
-		fmt.Printf("Error dialing validator from node A: %v\n", err)
-	}
-	if err := remoteBTr.Dial(string(validatorTr.Addr())); err != nil {
-		fmt.Printf("Error dialing validator from node B: %v\n", err)
+	// 节点 A 和 B 只知道验证者，彼此通过节点交换发现对方
+	bootnodes := []string{"127.0.0.1:3000"}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	nodes := []*node.Node{
+		makeNode(ctx, "127.0.0.1:3000", "127.0.0.1:8000", "127.0.0.1:9000", &validatorKey, genesisData, nil),
+		makeNode(ctx, "127.0.0.1:4000", "127.0.0.1:8001", "127.0.0.1:9001", nil, genesisData, bootnodes),
+		makeNode(ctx, "127.0.0.1:5000", "127.0.0.1:8002", "127.0.0.1:9002", nil, genesisData, bootnodes),
 	}
 
 	fmt.Println("Blockchain network is running. Use xchain-cli to interact.")
 
-	// 5. 永久阻塞，让节点持续运行
-	select {}
+	// 4. 阻塞直到收到 SIGINT 或 SIGTERM，然后依次关闭各节点
+	<-ctx.Done()
+	stop()
+	fmt.Println("Shutting down blockchain nodes...")
+	for _, n := range nodes {
+		if err := n.Stop(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to stop node: %v\n", err)
+		}
+	}
 }
 
-// makeNode 函数负责组装和初始化一个节点
-func makeNode(listenAddr, apiListenAddr string, pk *crypto.PrivateKey, genesisData *Genesis) (network.Transport, *node.Node) {
+// makeNode 函数负责组装和初始化一个节点，adminListenAddr 为空时不提供 admin 接口
+func makeNode(ctx context.Context, listenAddr, apiListenAddr, adminListenAddr string, pk *crypto.PrivateKey, genesisData *Genesis, bootnodes []string) *node.Node {
 	logger := log.NewLogfmtLogger(os.Stderr)
 	logger = log.With(logger, "node", listenAddr)
 
@@ -117,16 +124,43 @@ func makeNode(listenAddr, apiListenAddr string, pk *crypto.PrivateKey, genesisDa
 		}
 	}
 
-	// 初始化交易池、API服务器和节点
+	// 初始化交易池、网络、API服务器和节点
 	txPool := network.NewTxPool(1000)
-	apiServer := api.NewAPIServer(apiListenAddr, log.With(logger, "module", "api"), bc, txPool)
+	banList := network.NewBanList()
+
+	// 节点身份私钥保存在数据目录中，重启后节点 ID 保持不变
+	nodeKey, err := network.LoadOrCreateNodeKey(filepath.Join(dbPath, "nodekey"))
+	if err != nil {
+		panic(err)
+	}
 
+	genesisHash := genesisBlock.Hash(core.BlockHasher{})
 	opts := network.TCPTransportOpts{
 		ListenAddr:    listenAddr,
 		HandshakeFunc: network.NOPHandshakeFunc,
-		Decoder:       core.JSONDecoder[*network.Message]{},
+		Identity:      &nodeKey,
+		BanList:       banList,
+		// 握手时通告本节点所在的链和最新区块，不在同一条链上的节点会被断开
+		LocalInfo: func() network.PeerInfo {
+			best := bc.CurrentHeader()
+			return network.PeerInfo{
+				ChainID:     genesisData.ChainID,
+				GenesisHash: genesisHash,
+				BestHeight:  best.Height,
+				BestHash:    core.BlockHasher{}.Hash(best),
+			}
+		},
 	}
 	tr := network.NewTCPTransport(opts)
+	logger.Log("msg", "node identity loaded", "node_id", tr.ID())
+	if err := tr.ListenAndAccept(); err != nil {
+		panic(err)
+	}
+	apiServer := api.NewAPIServer(apiListenAddr, log.With(logger, "module", "api"), bc, txPool, banList, tr)
+	if adminListenAddr != "" {
+		// admin 接口没有鉴权，与公开的 API 分开监听，只绑定在本机地址上
+		apiServer.EnableAdmin(adminListenAddr)
+	}
 
 	nodeOpts := node.NodeOpts{
 		Logger:     logger,
@@ -136,6 +170,9 @@ func makeNode(listenAddr, apiListenAddr string, pk *crypto.PrivateKey, genesisDa
 		PrivateKey: pk,
 		BlockTime:  5 * time.Second,
 		APIServer:  apiServer,
+		Bootnodes:  bootnodes,
+		BanList:    banList,
+		DataDir:    dbPath,
 	}
 	nodeInstance, err := node.NewNode(nodeOpts)
 	if err != nil {
@@ -143,7 +180,7 @@ func makeNode(listenAddr, apiListenAddr string, pk *crypto.PrivateKey, genesisDa
 	}
 
 	// 启动节点的主逻辑
-	go nodeInstance.Start()
+	go nodeInstance.Start(ctx)
 
-	return tr, nodeInstance
+	return nodeInstance
 }
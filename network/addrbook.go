@@ -0,0 +1,255 @@
+package network
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// maxAddrs 是地址簿最多记录的地址数，防止节点交换把地址簿撑满
+	maxAddrs = 1000
+
+	minDialBackoff = time.Second
+	maxDialBackoff = 5 * time.Minute
+
+	maxAddrScore = 100
+	minAddrScore = -100
+)
+
+// AddrEntry 是地址簿中的一条记录
+type AddrEntry struct {
+	PeerAddr
+	LastSeen time.Time // 最近一次处于连接状态的时间
+	Failures int       // 连续拨号失败的次数
+	Score    int       // 成功连接加分，拨号失败减分
+	Outbound bool      // 是否曾由本节点主动连接，重连时优先
+	// lastAttempt 是最近一次拨号的时间，不持久化，重启后可以立即重连
+	lastAttempt time.Time
+}
+
+// NextDial 返回按指数退避计算的下一次允许拨号的时间
+func (e *AddrEntry) NextDial() time.Time {
+	backoff := maxDialBackoff
+	if e.Failures < 20 {
+		backoff = min(minDialBackoff<<e.Failures, maxDialBackoff)
+	}
+	return e.lastAttempt.Add(backoff)
+}
+
+// AddrBook 记录通过引导节点和节点交换得知的可拨号地址，以地址去重。
+// path 不为空时地址簿会保存到该文件，节点重启后据此恢复连接
+type AddrBook struct {
+	path string
+
+	lock  sync.RWMutex
+	addrs map[string]*AddrEntry
+	dirty bool
+}
+
+// NewAddrBook 创建一个只保存在内存中的地址簿
+func NewAddrBook() *AddrBook {
+	return &AddrBook{addrs: make(map[string]*AddrEntry)}
+}
+
+// LoadAddrBook 从 path 加载地址簿，文件不存在时返回空地址簿
+func LoadAddrBook(path string) (*AddrBook, error) {
+	b := NewAddrBook()
+	b.path = path
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []*AddrEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.Addr != "" && len(b.addrs) < maxAddrs {
+			b.addrs[e.Addr] = e
+		}
+	}
+	return b, nil
+}
+
+// Save 把有改动的地址簿写入文件，先写临时文件再改名，避免写到一半时损坏
+func (b *AddrBook) Save() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.path == "" || !b.dirty {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(b.entries(), "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := b.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, b.path); err != nil {
+		return err
+	}
+	b.dirty = false
+	return nil
+}
+
+// Add 记录一个地址，返回它是否是新地址。已知地址的节点 ID 会被更新，
+// 地址簿已满时淘汰最差的一个地址
+func (b *AddrBook) Add(addr PeerAddr) bool {
+	if addr.Addr == "" {
+		return false
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if known, ok := b.addrs[addr.Addr]; ok {
+		if addr.ID != "" && known.ID != addr.ID {
+			known.ID = addr.ID
+			b.dirty = true
+		}
+		return false
+	}
+	if len(b.addrs) >= maxAddrs {
+		b.evict()
+	}
+	b.addrs[addr.Addr] = &AddrEntry{PeerAddr: addr}
+	b.dirty = true
+	return true
+}
+
+// evict 删除最差的地址：失败次数最多，其次分数最低，再其次最久没有连接过。调用方需持有锁
+func (b *AddrBook) evict() {
+	var worst *AddrEntry
+	for _, e := range b.addrs {
+		if worst == nil || worseAddr(e, worst) {
+			worst = e
+		}
+	}
+	if worst != nil {
+		delete(b.addrs, worst.Addr)
+	}
+}
+
+func worseAddr(a, b *AddrEntry) bool {
+	if a.Failures != b.Failures {
+		return a.Failures > b.Failures
+	}
+	if a.Score != b.Score {
+		return a.Score < b.Score
+	}
+	if !a.LastSeen.Equal(b.LastSeen) {
+		return a.LastSeen.Before(b.LastSeen)
+	}
+	return a.Addr < b.Addr
+}
+
+// MarkAttempt 记录一次拨号
+func (b *AddrBook) MarkAttempt(addr string, now time.Time) {
+	b.update(addr, func(e *AddrEntry) { e.lastAttempt = now })
+}
+
+// MarkConnected 记录一次成功的连接，清零失败次数
+func (b *AddrBook) MarkConnected(addr PeerAddr, outbound bool, now time.Time) {
+	b.Add(addr)
+	b.update(addr.Addr, func(e *AddrEntry) {
+		e.LastSeen = now
+		e.Failures = 0
+		e.Score = min(e.Score+1, maxAddrScore)
+		e.Outbound = e.Outbound || outbound
+	})
+}
+
+// MarkSeen 更新仍处于连接状态的地址的最近连接时间
+func (b *AddrBook) MarkSeen(addr string, now time.Time) {
+	b.update(addr, func(e *AddrEntry) { e.LastSeen = now })
+}
+
+// MarkFailed 记录一次拨号失败，下一次拨号的等待时间随之加倍
+func (b *AddrBook) MarkFailed(addr string) {
+	b.update(addr, func(e *AddrEntry) {
+		e.Failures++
+		e.Score = max(e.Score-1, minAddrScore)
+	})
+}
+
+func (b *AddrBook) update(addr string, fn func(*AddrEntry)) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if e, ok := b.addrs[addr]; ok {
+		fn(e)
+		b.dirty = true
+	}
+}
+
+// Get 返回地址对应的记录
+func (b *AddrBook) Get(addr string) (AddrEntry, bool) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	e, ok := b.addrs[addr]
+	if !ok {
+		return AddrEntry{}, false
+	}
+	return *e, true
+}
+
+// Addrs 返回全部已知地址
+func (b *AddrBook) Addrs() []PeerAddr {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	addrs := make([]PeerAddr, 0, len(b.addrs))
+	for _, e := range b.addrs {
+		addrs = append(addrs, e.PeerAddr)
+	}
+	return addrs
+}
+
+// DialCandidates 返回 now 时已过退避时间的地址，曾经主动连接过的地址优先，其次按分数从高到低
+func (b *AddrBook) DialCandidates(now time.Time) []AddrEntry {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	var candidates []AddrEntry
+	for _, e := range b.addrs {
+		if !now.Before(e.NextDial()) {
+			candidates = append(candidates, *e)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, c := candidates[i], candidates[j]
+		if a.Outbound != c.Outbound {
+			return a.Outbound
+		}
+		if a.Score != c.Score {
+			return a.Score > c.Score
+		}
+		return a.Addr < c.Addr
+	})
+	return candidates
+}
+
+func (b *AddrBook) Len() int {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	return len(b.addrs)
+}
+
+// entries 按地址排序返回全部记录，调用方需持有锁
+func (b *AddrBook) entries() []*AddrEntry {
+	entries := make([]*AddrEntry, 0, len(b.addrs))
+	for _, e := range b.addrs {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Addr < entries[j].Addr })
+	return entries
+}
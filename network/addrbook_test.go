@@ -0,0 +1,108 @@
+package network
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/virtue186/xchain/core"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAddrBook(t *testing.T) {
+	book := NewAddrBook()
+	assert.True(t, book.Add(PeerAddr{Addr: "127.0.0.1:3000"}))
+	assert.False(t, book.Add(PeerAddr{ID: "abc", Addr: "127.0.0.1:3000"}))
+	assert.False(t, book.Add(PeerAddr{ID: "def"}))
+	assert.Equal(t, []PeerAddr{{ID: "abc", Addr: "127.0.0.1:3000"}}, book.Addrs())
+}
+
+func TestAddrBookEvict(t *testing.T) {
+	book := NewAddrBook()
+	now := time.Now()
+	for i := 0; i < maxAddrs; i++ {
+		addr := PeerAddr{Addr: fmt.Sprintf("10.0.0.%d:3000", i)}
+		book.MarkConnected(addr, false, now)
+	}
+	book.MarkFailed("10.0.0.7:3000")
+	book.MarkFailed("10.0.0.9:3000")
+	book.MarkFailed("10.0.0.9:3000")
+
+	// 地址簿已满时淘汰失败次数最多的地址，新地址不会被丢弃
+	assert.True(t, book.Add(PeerAddr{Addr: "new:3000"}))
+	assert.Equal(t, maxAddrs, book.Len())
+	_, ok := book.Get("10.0.0.9:3000")
+	assert.False(t, ok)
+
+	// 其余地址失败次数相同时淘汰最久没有连接过的
+	book.MarkFailed("10.0.0.7:3000")
+	book.MarkSeen("10.0.0.8:3000", now.Add(-time.Hour))
+	book.MarkFailed("10.0.0.8:3000")
+	book.MarkFailed("10.0.0.8:3000")
+	assert.True(t, book.Add(PeerAddr{Addr: "newer:3000"}))
+	_, ok = book.Get("10.0.0.8:3000")
+	assert.False(t, ok)
+	_, ok = book.Get("10.0.0.7:3000")
+	assert.True(t, ok)
+}
+
+func TestAddrBookBackoff(t *testing.T) {
+	book := NewAddrBook()
+	book.Add(PeerAddr{Addr: "a:1"})
+	book.MarkConnected(PeerAddr{ID: "b", Addr: "b:1"}, true, time.Now())
+
+	now := time.Now()
+	// 曾经主动连接过的地址排在前面
+	candidates := book.DialCandidates(now)
+	assert.Equal(t, 2, len(candidates))
+	assert.Equal(t, "b:1", candidates[0].Addr)
+
+	// 每失败一次，等待时间加倍
+	book.MarkAttempt("a:1", now)
+	book.MarkFailed("a:1")
+	book.MarkFailed("a:1")
+	book.MarkFailed("a:1")
+	e, _ := book.Get("a:1")
+	assert.Equal(t, now.Add(8*time.Second), e.NextDial())
+	assert.Equal(t, -3, e.Score)
+	assert.Equal(t, 1, len(book.DialCandidates(now.Add(7*time.Second))))
+	assert.Equal(t, 2, len(book.DialCandidates(now.Add(8*time.Second))))
+
+	for i := 0; i < 30; i++ {
+		book.MarkFailed("a:1")
+	}
+	e, _ = book.Get("a:1")
+	assert.Equal(t, now.Add(maxDialBackoff), e.NextDial())
+}
+
+func TestAddrBookPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.json")
+	book, err := LoadAddrBook(path)
+	assert.Nil(t, err)
+	seen := time.Now().Truncate(time.Second)
+	book.MarkConnected(PeerAddr{ID: "b", Addr: "b:1"}, true, seen)
+	book.MarkAttempt("b:1", seen)
+	assert.Nil(t, book.Save())
+
+	loaded, err := LoadAddrBook(path)
+	assert.Nil(t, err)
+	e, ok := loaded.Get("b:1")
+	assert.True(t, ok)
+	assert.Equal(t, NetAddr("b"), e.ID)
+	assert.True(t, e.Outbound)
+	assert.True(t, seen.Equal(e.LastSeen))
+	// 拨号时间不会被保存，重启后可以立即重连
+	assert.Equal(t, 1, len(loaded.DialCandidates(seen)))
+}
+
+func TestPeersMessageCodec(t *testing.T) {
+	msg := &PeersMessage{Peers: []PeerAddr{{ID: "abc", Addr: "127.0.0.1:3000"}, {Addr: "10.0.0.1:4000"}}}
+	for _, name := range []string{core.CodecJSON, core.CodecBinary} {
+		codec, _ := core.LookupCodec(name)
+		data, err := codec.Marshal(msg)
+		assert.Nil(t, err)
+		decoded := new(PeersMessage)
+		assert.Nil(t, codec.Unmarshal(data, decoded))
+		assert.Equal(t, msg, decoded, name)
+	}
+}
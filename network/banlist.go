@@ -0,0 +1,117 @@
+package network
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// BanEntry 是一条临时封禁记录，NodeID 和 IP 至少有一个不为空
+type BanEntry struct {
+	NodeID NetAddr
+	IP     string
+	Reason string
+	Until  time.Time
+}
+
+// BanList 记录被临时封禁的节点 ID 和 IP，过期的记录在查询时被清除
+type BanList struct {
+	lock  sync.Mutex
+	nodes map[NetAddr]*BanEntry
+	ips   map[string]*BanEntry
+}
+
+func NewBanList() *BanList {
+	return &BanList{
+		nodes: make(map[NetAddr]*BanEntry),
+		ips:   make(map[string]*BanEntry),
+	}
+}
+
+// Ban 在 duration 内封禁节点 ID 和 IP，为空的一项会被忽略
+func (b *BanList) Ban(id NetAddr, ip string, duration time.Duration, reason string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	e := &BanEntry{NodeID: id, IP: ip, Reason: reason, Until: time.Now().Add(duration)}
+	if id != "" {
+		b.nodes[id] = e
+	}
+	if ip != "" {
+		b.ips[ip] = e
+	}
+}
+
+// IsNodeBanned 返回节点 ID 是否处于封禁期
+func (b *BanList) IsNodeBanned(id NetAddr) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.active(b.nodes[id])
+}
+
+// IsIPBanned 返回 IP 是否处于封禁期
+func (b *BanList) IsIPBanned(ip string) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.active(b.ips[ip])
+}
+
+// Unban 解除 target 对应的节点 ID 或 IP 的封禁，返回是否找到了记录
+func (b *BanList) Unban(target string) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	e, ok := b.nodes[NetAddr(target)]
+	if !ok {
+		if e, ok = b.ips[target]; !ok {
+			return false
+		}
+	}
+	b.remove(e)
+	return true
+}
+
+// List 返回全部仍在封禁期的记录，按到期时间排序
+func (b *BanList) List() []BanEntry {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	seen := make(map[*BanEntry]bool)
+	var entries []BanEntry
+	add := func(e *BanEntry) {
+		if !seen[e] && b.active(e) {
+			seen[e] = true
+			entries = append(entries, *e)
+		}
+	}
+	for _, e := range b.nodes {
+		add(e)
+	}
+	for _, e := range b.ips {
+		add(e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Until.Before(entries[j].Until) })
+	return entries
+}
+
+// active 检查记录是否仍在封禁期，过期的记录会被删除。调用方需持有锁
+func (b *BanList) active(e *BanEntry) bool {
+	if e == nil {
+		return false
+	}
+	if time.Now().Before(e.Until) {
+		return true
+	}
+	b.remove(e)
+	return false
+}
+
+// remove 删除一条记录，只删除仍指向该记录的索引。调用方需持有锁
+func (b *BanList) remove(e *BanEntry) {
+	if b.nodes[e.NodeID] == e {
+		delete(b.nodes, e.NodeID)
+	}
+	if b.ips[e.IP] == e {
+		delete(b.ips, e.IP)
+	}
+}
@@ -0,0 +1,57 @@
+package network
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestBanList(t *testing.T) {
+	bans := NewBanList()
+	bans.Ban("node-a", "10.0.0.1", time.Hour, "invalid block")
+	bans.Ban("node-b", "", -time.Second, "expired")
+
+	assert.True(t, bans.IsNodeBanned("node-a"))
+	assert.True(t, bans.IsIPBanned("10.0.0.1"))
+	assert.False(t, bans.IsNodeBanned("node-b"))
+	assert.Equal(t, 1, len(bans.List()))
+
+	assert.True(t, bans.Unban("10.0.0.1"))
+	assert.False(t, bans.IsNodeBanned("node-a"))
+	assert.False(t, bans.Unban("node-a"))
+	assert.Equal(t, 0, len(bans.List()))
+}
+
+func TestServerPenalize(t *testing.T) {
+	s := NewServer(ServerOpts{BanThreshold: -50})
+	s.Penalize("node-a", PenaltyUndecodable, "bad message")
+	assert.Equal(t, -PenaltyUndecodable, s.Score("node-a"))
+	assert.False(t, s.BanList.IsNodeBanned("node-a"))
+
+	s.Penalize("node-a", PenaltyInvalidBlock, "bad block")
+	assert.True(t, s.BanList.IsNodeBanned("node-a"))
+	assert.Equal(t, 0, s.Score("node-a"))
+}
+
+func TestServerScoreRecovery(t *testing.T) {
+	s := NewServer(ServerOpts{})
+	s.Penalize("node-a", PenaltyUndecodable, "bad message")
+	s.Penalize("node-b", PenaltyUndecodable, "bad message")
+
+	// 每过 scoreRecoveryInterval 恢复 1 分
+	s.scores["node-a"].updated = time.Now().Add(-5 * scoreRecoveryInterval)
+	assert.Equal(t, 5-PenaltyUndecodable, s.Score("node-a"))
+	s.scores["node-a"].updated = time.Now().Add(-time.Hour)
+	assert.Equal(t, 0, s.Score("node-a"))
+
+	// 偶尔的违规不会累积成封禁
+	for i := 0; i < 10; i++ {
+		s.scores["node-b"].updated = time.Now().Add(-time.Duration(PenaltyUndecodable) * scoreRecoveryInterval)
+		s.Penalize("node-b", PenaltyUndecodable, "bad message")
+	}
+	assert.False(t, s.BanList.IsNodeBanned("node-b"))
+
+	// 没有连接的节点的分数被清理
+	s.sweepScores()
+	assert.Empty(t, s.scores)
+}
@@ -0,0 +1,38 @@
+package network
+
+import "time"
+
+// Clock 提供当前时间和定时器。节点默认使用系统时钟，在模拟网络中使用模拟器的虚拟时钟，
+// 这样请求超时、重试和重新拨号都随虚拟时间发生
+type Clock interface {
+	Now() time.Time
+	// NewTimer 在 d 之后向定时器的通道发送一次当时的时间
+	NewTimer(d time.Duration) Timer
+	// NewTicker 每隔 d 向定时器的通道发送一次时间，接收方来不及接收时丢弃
+	NewTicker(d time.Duration) Timer
+}
+
+// Timer 是 Clock 创建的定时器，不再使用时需要调用 Stop
+type Timer interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// SystemClock 是使用系统时间的 Clock
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                  { return time.Now() }
+func (systemClock) NewTimer(d time.Duration) Timer  { return systemTimer{time.NewTimer(d)} }
+func (systemClock) NewTicker(d time.Duration) Timer { return systemTicker{time.NewTicker(d)} }
+
+type systemTimer struct{ t *time.Timer }
+
+func (t systemTimer) C() <-chan time.Time { return t.t.C }
+func (t systemTimer) Stop()               { t.t.Stop() }
+
+type systemTicker struct{ t *time.Ticker }
+
+func (t systemTicker) C() <-chan time.Time { return t.t.C }
+func (t systemTicker) Stop()               { t.t.Stop() }
@@ -0,0 +1,60 @@
+package network
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"github.com/virtue186/xchain/core"
+	"github.com/virtue186/xchain/types"
+)
+
+// ShortTxID 返回交易在区块中的短 ID，即 sha256(区块哈希 || 交易哈希) 的前 8 字节。
+// 以区块哈希为盐，事先无法构造出与某个区块中的交易短 ID 相同的交易
+func ShortTxID(blockHash, txHash types.Hash) uint64 {
+	sum := sha256.Sum256(append(blockHash[:], txHash[:]...))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// NewCompactBlockMessage 把区块中的交易替换为短 ID
+func NewCompactBlockMessage(b *core.Block) *CompactBlockMessage {
+	hash := b.Hash(core.BlockHasher{})
+	ids := make([]uint64, len(b.Transactions))
+	for i, tx := range b.Transactions {
+		ids[i] = ShortTxID(hash, tx.Hash(core.TxHasher{}))
+	}
+	return &CompactBlockMessage{Header: b.Header, Validator: b.Validator, Signature: b.Signature, ShortIDs: ids}
+}
+
+// Hash 返回区块哈希
+func (m *CompactBlockMessage) Hash() types.Hash {
+	return core.BlockHasher{}.Hash(m.Header)
+}
+
+// Reconstruct 用交易池中的交易重建区块，返回的区块中缺少的交易为 nil，missing 是它们的下标。
+// 交易池中有多笔交易的短 ID 相同时无法确定是哪一笔，同样算作缺少
+func (m *CompactBlockMessage) Reconstruct(pool *TxPool) (block *core.Block, missing []uint32) {
+	hash := m.Hash()
+	wanted := make(map[uint64]*core.Transaction, len(m.ShortIDs))
+	for _, id := range m.ShortIDs {
+		wanted[id] = nil
+	}
+	ambiguous := make(map[uint64]bool)
+	for _, tx := range pool.All() {
+		id := ShortTxID(hash, tx.Hash(core.TxHasher{}))
+		if found, ok := wanted[id]; ok {
+			if found != nil {
+				ambiguous[id] = true
+			}
+			wanted[id] = tx
+		}
+	}
+
+	txx := make([]*core.Transaction, len(m.ShortIDs))
+	for i, id := range m.ShortIDs {
+		if tx := wanted[id]; tx != nil && !ambiguous[id] {
+			txx[i] = tx
+		} else {
+			missing = append(missing, uint32(i))
+		}
+	}
+	return &core.Block{Header: m.Header, Transactions: txx, Validator: m.Validator, Signature: m.Signature}, missing
+}
@@ -0,0 +1,42 @@
+package network
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/virtue186/xchain/core"
+	"github.com/virtue186/xchain/crypto"
+	"testing"
+)
+
+func TestCompactBlockReconstruct(t *testing.T) {
+	key := crypto.GeneratePrivateKey()
+	txx := make([]*core.Transaction, 4)
+	for i := range txx {
+		txx[i] = core.NewTransaction([]byte{byte(i)})
+		assert.Nil(t, txx[i].Sign(key))
+	}
+	dataHash, err := core.CalculateDataHash(txx)
+	assert.Nil(t, err)
+	block, err := core.NewBlock(&core.Header{Version: 1, Height: 1, DataHash: dataHash}, txx)
+	assert.Nil(t, err)
+	assert.Nil(t, block.Sign(key))
+
+	// 交易池中只有第 0 和第 2 笔交易，另有一笔不在区块中的交易
+	pool := NewTxPool(10)
+	pool.Add(txx[0])
+	pool.Add(txx[2])
+	other := core.NewTransaction([]byte("other"))
+	assert.Nil(t, other.Sign(key))
+	pool.Add(other)
+
+	rebuilt, missing := NewCompactBlockMessage(block).Reconstruct(pool)
+	assert.Equal(t, []uint32{1, 3}, missing)
+	assert.Equal(t, txx[0], rebuilt.Transactions[0])
+	assert.Nil(t, rebuilt.Transactions[1])
+	assert.Equal(t, block.Hash(core.BlockHasher{}), rebuilt.Hash(core.BlockHasher{}))
+
+	pool.Add(txx[1])
+	pool.Add(txx[3])
+	rebuilt, missing = NewCompactBlockMessage(block).Reconstruct(pool)
+	assert.Empty(t, missing)
+	assert.Nil(t, rebuilt.Verify())
+}
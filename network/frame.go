@@ -0,0 +1,63 @@
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// 网络上的每条消息都是一个帧：
+//
+//	长度 u32 大端 | 类型 u8 | 负载
+//
+// 长度包含类型字节和负载，负载就是 Message.Data 的原始字节。
+const (
+	frameHeaderSize = 4
+	// DefaultMaxFrameSize 是默认允许的最大帧长度
+	DefaultMaxFrameSize = 8 << 20
+)
+
+var (
+	ErrFrameTooLarge = errors.New("frame too large")
+	ErrEmptyFrame    = errors.New("empty frame")
+)
+
+// WriteFrame 把消息编码为一个帧写入 w
+func WriteFrame(w io.Writer, msg *Message, maxSize int) error {
+	size := 1 + len(msg.Data)
+	if size > maxSize {
+		return fmt.Errorf("%w: %d bytes exceeds limit of %d", ErrFrameTooLarge, size, maxSize)
+	}
+	buf := make([]byte, frameHeaderSize+size)
+	binary.BigEndian.PutUint32(buf, uint32(size))
+	buf[frameHeaderSize] = byte(msg.Header)
+	copy(buf[frameHeaderSize+1:], msg.Data)
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadFrame 从 r 中读取一个帧。长度超过 maxSize 的帧在读取负载之前就会被拒绝，
+// 此时连接上的数据已无法继续解析，调用方应当关闭连接
+func ReadFrame(r io.Reader, maxSize int) (*Message, error) {
+	var header [frameHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size == 0 {
+		return nil, ErrEmptyFrame
+	}
+	if uint64(size) > uint64(maxSize) {
+		return nil, fmt.Errorf("%w: %d bytes exceeds limit of %d", ErrFrameTooLarge, size, maxSize)
+	}
+
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return NewMessage(MessageType(buf[0]), buf[1:]), nil
+}
@@ -0,0 +1,44 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	buf := new(bytes.Buffer)
+	assert.Nil(t, WriteFrame(buf, NewMessage(MessageTypeTx, []byte("payload")), 64))
+	assert.Nil(t, WriteFrame(buf, NewMessage(MessageTypeGetStatus, nil), 64))
+
+	msg, err := ReadFrame(buf, 64)
+	assert.Nil(t, err)
+	assert.Equal(t, MessageTypeTx, msg.Header)
+	assert.Equal(t, []byte("payload"), msg.Data)
+
+	msg, err = ReadFrame(buf, 64)
+	assert.Nil(t, err)
+	assert.Equal(t, MessageTypeGetStatus, msg.Header)
+	assert.Empty(t, msg.Data)
+
+	_, err = ReadFrame(buf, 64)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestFrameRejectsMalformed(t *testing.T) {
+	assert.ErrorIs(t, WriteFrame(io.Discard, NewMessage(MessageTypeTx, make([]byte, 64)), 64), ErrFrameTooLarge)
+
+	// 声称有 1GB 的帧在读取负载之前就被拒绝
+	header := binary.BigEndian.AppendUint32(nil, 1<<30)
+	_, err := ReadFrame(bytes.NewReader(header), 64)
+	assert.ErrorIs(t, err, ErrFrameTooLarge)
+
+	_, err = ReadFrame(bytes.NewReader(make([]byte, 4)), 64)
+	assert.ErrorIs(t, err, ErrEmptyFrame)
+
+	truncated := append(binary.BigEndian.AppendUint32(nil, 10), byte(MessageTypeTx), 1, 2)
+	_, err = ReadFrame(bytes.NewReader(truncated), 64)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
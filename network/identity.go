@@ -0,0 +1,32 @@
+package network
+
+import (
+	"errors"
+	"github.com/virtue186/xchain/crypto"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// NodeIDFromPublicKey 返回身份公钥对应的节点 ID，即公钥地址的十六进制编码。
+// 连接建立后，节点以经过验证的节点 ID 作为对方的 NetAddr
+func NodeIDFromPublicKey(pub crypto.PublicKey) NetAddr {
+	return NetAddr(pub.Address().String())
+}
+
+// LoadOrCreateNodeKey 从 path 读取十六进制编码的节点身份私钥，文件不存在时生成新的私钥并写入
+func LoadOrCreateNodeKey(path string) (crypto.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return crypto.NewPrivateKeyFromHex(strings.TrimSpace(string(data)))
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return crypto.PrivateKey{}, err
+	}
+
+	key := crypto.GeneratePrivateKey()
+	if err := os.WriteFile(path, []byte(key.String()+"\n"), 0600); err != nil {
+		return crypto.PrivateKey{}, err
+	}
+	return key, nil
+}
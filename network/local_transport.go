@@ -1,63 +1,255 @@
 package network
 
-//
-//import (
-//	"bytes"
-//	"fmt"
-//	"sync"
-//)
-//
-//type LocalTransport struct {
-//	addr      NetAddr
-//	consumeCh chan RPC
-//	lock      sync.RWMutex
-//	peers     map[NetAddr]*LocalTransport
-//}
-//
-//func NewLocalTransport(addr NetAddr) Transport {
-//	return &LocalTransport{
-//		addr:      addr,
-//		consumeCh: make(chan RPC, 1024),
-//		peers:     make(map[NetAddr]*LocalTransport),
-//	}
-//
-//}
-//
-//func (t *LocalTransport) Consume() <-chan RPC {
-//	return t.consumeCh
-//}
-//func (t *LocalTransport) Connect(transport Transport) error {
-//	t.lock.Lock()
-//	defer t.lock.Unlock()
-//
-//	t.peers[transport.Addr()] = transport.(*LocalTransport)
-//	return nil
-//}
-//
-//func (t *LocalTransport) Addr() NetAddr {
-//	return t.addr
-//}
-//
-//func (t *LocalTransport) SendMessage(to NetAddr, payload []byte) error {
-//	t.lock.RLock()
-//	defer t.lock.RUnlock()
-//
-//	trans, ok := t.peers[to]
-//	if !ok {
-//		return fmt.Errorf("no peer send for %s", to)
-//	}
-//	trans.consumeCh <- RPC{
-//		From:    t.addr,
-//		Payload: bytes.NewReader(payload),
-//	}
-//	return nil
-//}
-//
-//func (t *LocalTransport) Broadcast(payload []byte) error {
-//	for _, peer := range t.peers {
-//		if err := t.SendMessage(peer.addr, payload); err != nil {
-//			return err
-//		}
-//	}
-//	return nil
-//}
+import (
+	"errors"
+	"fmt"
+	"github.com/virtue186/xchain/core"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LocalNetwork 把同一进程中的 LocalTransport 连接在一起，拨号按地址查找对方，不经过网络。
+// 节点 ID 就是地址，消息用 binary 编解码器编码，与 TCP 连接的默认编码相同
+type LocalNetwork struct {
+	lock       sync.RWMutex
+	transports map[NetAddr]*LocalTransport
+	sim        *Simulator // 不为空时消息由模拟器按链路条件投递
+}
+
+func NewLocalNetwork() *LocalNetwork {
+	return &LocalNetwork{transports: make(map[NetAddr]*LocalTransport)}
+}
+
+// NewTransport 创建一个地址为 addr 的 Transport 并加入网络
+func (n *LocalNetwork) NewTransport(addr NetAddr) *LocalTransport {
+	t := &LocalTransport{
+		addr:      addr,
+		network:   n,
+		codec:     core.BinaryCodec{},
+		consumeCh: make(chan RPC, 1024),
+		peerCh:    make(chan Peer, 16),
+		peers:     make(map[NetAddr]*localPeer),
+	}
+	n.lock.Lock()
+	n.transports[addr] = t
+	n.lock.Unlock()
+	return t
+}
+
+func (n *LocalNetwork) lookup(addr NetAddr) (*LocalTransport, bool) {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	t, ok := n.transports[addr]
+	return t, ok
+}
+
+func (n *LocalNetwork) remove(addr NetAddr) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	delete(n.transports, addr)
+}
+
+// LocalTransport 是进程内的 Transport，用于测试
+type LocalTransport struct {
+	addr      NetAddr
+	network   *LocalNetwork
+	codec     core.Codec
+	consumeCh chan RPC
+	peerCh    chan Peer
+
+	lock   sync.RWMutex
+	peers  map[NetAddr]*localPeer
+	closed bool
+
+	delivered atomic.Uint64 // 已放入消费通道的消息数
+}
+
+// localPeer 是 LocalTransport 中的一个连接，owner 是本端，remote 是对端
+type localPeer struct {
+	owner, remote *LocalTransport
+	outbound      bool
+}
+
+func (p *localPeer) Send(msgType MessageType, payload any) error {
+	return p.SendWithID(0, false, msgType, payload)
+}
+
+func (p *localPeer) SendWithID(id uint64, response bool, msgType MessageType, payload any) error {
+	data, err := p.owner.codec.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	msg := NewMessage(msgType, data)
+	msg.ID = id
+	msg.Response = response
+	rpc := RPC{From: p.owner.addr, Message: msg, Codec: p.owner.codec}
+	if sim := p.owner.network.sim; sim != nil {
+		sim.send(p.remote, rpc)
+		return nil
+	}
+	return p.remote.deliver(rpc)
+}
+
+func (p *localPeer) ID() NetAddr         { return p.remote.addr }
+func (p *localPeer) RemoteAddr() NetAddr { return p.remote.addr }
+func (p *localPeer) IsOutbound() bool    { return p.outbound }
+func (p *localPeer) RTT() time.Duration  { return 0 }
+
+func (p *localPeer) Info() *PeerInfo {
+	return &PeerInfo{ProtocolVersion: ProtocolVersion, NodeID: string(p.remote.addr), ListenAddr: string(p.remote.addr)}
+}
+
+// Close 断开连接的两端
+func (p *localPeer) Close() error {
+	return p.owner.Disconnect(p.remote.addr)
+}
+
+// deliver 把消息放入本端的消费通道，不会阻塞。本端已关闭或通道已满时返回错误
+func (t *LocalTransport) deliver(rpc RPC) error {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	if t.closed {
+		return fmt.Errorf("%s: transport closed", t.addr)
+	}
+	if _, ok := t.peers[rpc.From]; !ok {
+		return fmt.Errorf("%s: not connected to %s", t.addr, rpc.From)
+	}
+	// 先计数再放入通道，消费方取走消息时计数一定已经更新
+	t.delivered.Add(1)
+	select {
+	case t.consumeCh <- rpc:
+		return nil
+	default:
+		t.delivered.Add(^uint64(0))
+		return fmt.Errorf("%s: %w", t.addr, ErrPeerQueueFull)
+	}
+}
+
+// Delivered 返回已经投递给本端的消息数，测试用它和 Server.Handled 比较，判断消息是否都已处理
+func (t *LocalTransport) Delivered() uint64 {
+	return t.delivered.Load()
+}
+
+// Dial 连接网络中地址为 addr 的 Transport，双方都会收到节点事件
+func (t *LocalTransport) Dial(addr string) error {
+	remote, ok := t.network.lookup(NetAddr(addr))
+	if !ok {
+		return fmt.Errorf("%s: no transport at %s", t.addr, addr)
+	}
+	if remote == t {
+		return fmt.Errorf("connected to self")
+	}
+	if sim := t.network.sim; sim != nil && sim.partitioned(t.addr, remote.addr) {
+		return fmt.Errorf("%s: %s is unreachable", t.addr, addr)
+	}
+	if err := t.addPeer(&localPeer{owner: t, remote: remote, outbound: true}); err != nil {
+		return err
+	}
+	if err := remote.addPeer(&localPeer{owner: remote, remote: t}); err != nil {
+		t.removePeer(remote.addr)
+		return err
+	}
+	return nil
+}
+
+func (t *LocalTransport) addPeer(peer *localPeer) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.closed {
+		return fmt.Errorf("%s: transport closed", t.addr)
+	}
+	if _, ok := t.peers[peer.ID()]; ok {
+		return fmt.Errorf("already connected to %s", peer.ID())
+	}
+	t.peers[peer.ID()] = peer
+
+	select {
+	case t.peerCh <- peer:
+	default:
+	}
+	return nil
+}
+
+func (t *LocalTransport) removePeer(id NetAddr) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	_, ok := t.peers[id]
+	delete(t.peers, id)
+	return ok
+}
+
+func (t *LocalTransport) Consume() <-chan RPC {
+	return t.consumeCh
+}
+
+// Close 断开全部连接并退出网络
+func (t *LocalTransport) Close() error {
+	t.network.remove(t.addr)
+	for _, p := range t.Peers() {
+		t.Disconnect(p.ID())
+	}
+	t.lock.Lock()
+	t.closed = true
+	t.lock.Unlock()
+	return nil
+}
+
+func (t *LocalTransport) SendMessage(to NetAddr, msgType MessageType, payload any) error {
+	return t.SendWithID(to, 0, false, msgType, payload)
+}
+
+func (t *LocalTransport) SendWithID(to NetAddr, id uint64, response bool, msgType MessageType, payload any) error {
+	t.lock.RLock()
+	peer, ok := t.peers[to]
+	t.lock.RUnlock()
+	if !ok {
+		return fmt.Errorf("%s: could not find peer %s", t.addr, to)
+	}
+	return peer.SendWithID(id, response, msgType, payload)
+}
+
+// Broadcast 向全部节点发送消息，某个节点发送失败不影响其他节点
+func (t *LocalTransport) Broadcast(msgType MessageType, payload any) error {
+	var errs []error
+	for _, p := range t.Peers() {
+		if err := p.Send(msgType, payload); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (t *LocalTransport) Addr() NetAddr {
+	return t.addr
+}
+
+// ID 返回节点 ID，即地址
+func (t *LocalTransport) ID() NetAddr {
+	return t.addr
+}
+
+func (t *LocalTransport) PeerEvents() <-chan Peer {
+	return t.peerCh
+}
+
+func (t *LocalTransport) Peers() []Peer {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	peers := make([]Peer, 0, len(t.peers))
+	for _, p := range t.peers {
+		peers = append(peers, p)
+	}
+	return peers
+}
+
+// Disconnect 断开与 id 的连接的两端
+func (t *LocalTransport) Disconnect(id NetAddr) error {
+	if !t.removePeer(id) {
+		return fmt.Errorf("%s: could not find peer %s", t.addr, id)
+	}
+	if remote, ok := t.network.lookup(id); ok {
+		remote.removePeer(t.addr)
+	}
+	return nil
+}
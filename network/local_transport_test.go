@@ -0,0 +1,39 @@
+package network
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestLocalTransport(t *testing.T) {
+	net := NewLocalNetwork()
+	a := net.NewTransport("a")
+	b := net.NewTransport("b")
+
+	assert.Nil(t, a.Dial("b"))
+	assert.NotNil(t, a.Dial("b"))
+	assert.NotNil(t, a.Dial("a"))
+	assert.NotNil(t, a.Dial("c"))
+	assert.Equal(t, NetAddr("b"), (<-a.PeerEvents()).ID())
+	peer := <-b.PeerEvents()
+	assert.Equal(t, NetAddr("a"), peer.ID())
+	assert.False(t, peer.IsOutbound())
+
+	assert.Nil(t, b.SendWithID("a", 7, true, MessageTypeGetBlocks, &GetBlocksMessage{From: 3}))
+	rpc := <-a.Consume()
+	assert.Equal(t, NetAddr("b"), rpc.From)
+	assert.Equal(t, uint64(7), rpc.Message.ID)
+	assert.True(t, rpc.Message.Response)
+	msg := new(GetBlocksMessage)
+	assert.Nil(t, rpc.Codec.Unmarshal(rpc.Message.Data, msg))
+	assert.Equal(t, uint32(3), msg.From)
+
+	// 断开连接会移除两端
+	assert.Nil(t, peer.Close())
+	assert.Empty(t, a.Peers())
+	assert.Empty(t, b.Peers())
+	assert.NotNil(t, a.SendMessage("b", MessageTypeGetStatus, new(GetStatusMessage)))
+
+	assert.Nil(t, a.Close())
+	assert.NotNil(t, b.Dial("a"))
+}
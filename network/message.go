@@ -1,8 +1,12 @@
 package network
 
 import (
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"github.com/virtue186/xchain/core"
-	"io"
+	"github.com/virtue186/xchain/crypto"
+	"github.com/virtue186/xchain/types"
 )
 
 // StatusMessage 包含了节点的状态信息，这里主要是区块链的高度
@@ -25,15 +29,466 @@ type GetBlocksMessage struct {
 
 // BlocksMessage 用于响应 GetBlocksMessage，包含了具体的区块数据
 type BlocksMessage struct {
-	Blocks [][]byte // 序列化后的区块列表
+	Blocks []*core.Block
 }
 
-// Encode 将 Message 编码到指定的写入器
-func (m *Message) Encode(w io.Writer, enc core.Encoder[*Message]) error {
-	return enc.Encode(w, m)
+// PeerInfo 是节点在握手时通告的自身信息
+type PeerInfo struct {
+	ProtocolVersion uint32
+	ChainID         string
+	GenesisHash     types.Hash
+	BestHeight      uint32
+	BestHash        types.Hash
+	NodeID          string
+	ListenAddr      string // 其他节点可以拨号连接的地址，为空表示不接受连接
 }
 
-// Decode 从读取器解码 Message
-func (m *Message) Decode(r io.Reader, dec core.Decoder[*Message]) error {
-	return dec.Decode(r, m)
+// HandshakeMessage 是连接建立后双方交换的第一条消息，固定使用 JSON 编码
+type HandshakeMessage struct {
+	PeerInfo
+	Codecs []string // 按优先级排列的、本节点支持的编解码器
+}
+
+type GetPeersMessage struct{}
+
+// PeerAddr 是一个可以拨号连接的节点地址，ID 未知时为空
+type PeerAddr struct {
+	ID   NetAddr
+	Addr string
+}
+
+// PeersMessage 用于响应 GetPeersMessage，包含对方已连接的节点通告的监听地址
+type PeersMessage struct {
+	Peers []PeerAddr
+}
+
+// PingMessage 由 Transport 定期发送，对方以相同 Nonce 的 PongMessage 回复，用于测量往返时间和检测断开的连接
+type PingMessage struct {
+	Nonce uint64
+}
+
+type PongMessage struct {
+	Nonce uint64
+}
+
+// InvType 是通告条目的类型
+type InvType uint8
+
+const (
+	InvTypeTx           InvType = 1
+	InvTypeBlock        InvType = 2
+	InvTypeCompactBlock InvType = 3 // 只在数据请求中使用，对方以 CompactBlockMessage 发送区块
+)
+
+// MaxInvItems 是一条通告或数据请求最多包含的条目数
+const MaxInvItems = 1000
+
+// InvItem 用类型和哈希标识一笔交易或一个区块
+type InvItem struct {
+	Type InvType
+	Hash types.Hash
+}
+
+// InvMessage 通告本节点拥有的交易和区块，对方只请求自己缺少的条目
+type InvMessage struct {
+	Items []InvItem
+}
+
+// GetDataMessage 请求通告中的交易和区块，对方以普通的交易和区块消息发送
+type GetDataMessage struct {
+	Items []InvItem
+}
+
+// CompactBlockMessage 包含区块头、签名和每笔交易的短 ID，接收方用交易池中的交易重建区块，
+// 只请求交易池中没有的交易
+type CompactBlockMessage struct {
+	Header    *core.Header
+	Validator crypto.PublicKey
+	Signature *crypto.Signature
+	ShortIDs  []uint64
+}
+
+// GetBlockTxsMessage 请求重建区块时缺少的交易，Indexes 是交易在区块中的下标
+type GetBlockTxsMessage struct {
+	BlockHash types.Hash
+	Indexes   []uint32
+}
+
+// BlockTxsMessage 按请求的顺序包含缺少的交易
+type BlockTxsMessage struct {
+	BlockHash    types.Hash
+	Transactions []*core.Transaction
+}
+
+// validateBlock 检查解码得到的区块没有为 null 的区块头和交易
+func validateBlock(b *core.Block) error {
+	if b == nil || b.Header == nil {
+		return errors.New("block without header")
+	}
+	for i, tx := range b.Transactions {
+		if tx == nil {
+			return fmt.Errorf("transaction %d is null", i)
+		}
+	}
+	return nil
+}
+
+func (m *BlocksMessage) validate() error {
+	for i, b := range m.Blocks {
+		if err := validateBlock(b); err != nil {
+			return fmt.Errorf("block %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// validate 检查解码得到的紧凑区块是否完整，json 编码的消息中字段可能为 null
+func (m *CompactBlockMessage) validate() error {
+	if m.Header == nil {
+		return errors.New("compact block without header")
+	}
+	return nil
+}
+
+// validate 检查交易列表中没有 null
+func (m *BlockTxsMessage) validate() error {
+	for i, tx := range m.Transactions {
+		if tx == nil {
+			return fmt.Errorf("block transaction %d is null", i)
+		}
+	}
+	return nil
+}
+
+// 以下为各消息在 binary 编解码器下的编码，整数为大端序，变长字段为 4 字节长度 || 内容
+
+var errShortMessage = errors.New("message too short")
+
+func (m *GetStatusMessage) MarshalBinary() ([]byte, error) { return []byte{}, nil }
+
+func (m *GetStatusMessage) UnmarshalBinary(data []byte) error {
+	if len(data) != 0 {
+		return fmt.Errorf("unexpected %d bytes in getstatus message", len(data))
+	}
+	return nil
+}
+
+func (m *StatusMessage) MarshalBinary() ([]byte, error) {
+	buf := binary.BigEndian.AppendUint32(nil, uint32(len(m.ID)))
+	buf = append(buf, m.ID...)
+	return binary.BigEndian.AppendUint32(buf, m.CurrentHeight), nil
+}
+
+func (m *StatusMessage) UnmarshalBinary(data []byte) error {
+	id, rest, err := readBytes(data)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 4 {
+		return errShortMessage
+	}
+	m.ID = string(id)
+	m.CurrentHeight = binary.BigEndian.Uint32(rest)
+	return nil
+}
+
+func (m *GetBlocksMessage) MarshalBinary() ([]byte, error) {
+	buf := binary.BigEndian.AppendUint32(nil, m.From)
+	return binary.BigEndian.AppendUint32(buf, m.To), nil
+}
+
+func (m *GetBlocksMessage) UnmarshalBinary(data []byte) error {
+	if len(data) != 8 {
+		return errShortMessage
+	}
+	m.From = binary.BigEndian.Uint32(data)
+	m.To = binary.BigEndian.Uint32(data[4:])
+	return nil
+}
+
+func (m *BlocksMessage) MarshalBinary() ([]byte, error) {
+	buf := binary.BigEndian.AppendUint32(nil, uint32(len(m.Blocks)))
+	for _, b := range m.Blocks {
+		data, err := b.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(data)))
+		buf = append(buf, data...)
+	}
+	return buf, nil
+}
+
+func (m *BlocksMessage) UnmarshalBinary(data []byte) error {
+	if len(data) < 4 {
+		return errShortMessage
+	}
+	n := binary.BigEndian.Uint32(data)
+	data = data[4:]
+	if uint64(n) > uint64(len(data)) {
+		return errShortMessage
+	}
+	m.Blocks = make([]*core.Block, 0, n)
+	for i := uint32(0); i < n; i++ {
+		var b []byte
+		var err error
+		if b, data, err = readBytes(data); err != nil {
+			return err
+		}
+		block := new(core.Block)
+		if err := block.UnmarshalBinary(b); err != nil {
+			return fmt.Errorf("block %d: %w", i, err)
+		}
+		m.Blocks = append(m.Blocks, block)
+	}
+	if len(data) != 0 {
+		return fmt.Errorf("%d trailing bytes in blocks message", len(data))
+	}
+	return nil
+}
+
+func (m *GetPeersMessage) MarshalBinary() ([]byte, error) { return []byte{}, nil }
+
+func (m *GetPeersMessage) UnmarshalBinary(data []byte) error {
+	if len(data) != 0 {
+		return fmt.Errorf("unexpected %d bytes in getpeers message", len(data))
+	}
+	return nil
+}
+
+func (m *PeersMessage) MarshalBinary() ([]byte, error) {
+	buf := binary.BigEndian.AppendUint32(nil, uint32(len(m.Peers)))
+	for _, p := range m.Peers {
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(p.ID)))
+		buf = append(buf, p.ID...)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(p.Addr)))
+		buf = append(buf, p.Addr...)
+	}
+	return buf, nil
+}
+
+func (m *PeersMessage) UnmarshalBinary(data []byte) error {
+	if len(data) < 4 {
+		return errShortMessage
+	}
+	n := binary.BigEndian.Uint32(data)
+	data = data[4:]
+	if uint64(n) > uint64(len(data)) {
+		return errShortMessage
+	}
+	m.Peers = make([]PeerAddr, 0, n)
+	for i := uint32(0); i < n; i++ {
+		var id, addr []byte
+		var err error
+		if id, data, err = readBytes(data); err != nil {
+			return err
+		}
+		if addr, data, err = readBytes(data); err != nil {
+			return err
+		}
+		m.Peers = append(m.Peers, PeerAddr{ID: NetAddr(id), Addr: string(addr)})
+	}
+	if len(data) != 0 {
+		return fmt.Errorf("%d trailing bytes in peers message", len(data))
+	}
+	return nil
+}
+
+func (m *PingMessage) MarshalBinary() ([]byte, error) {
+	return binary.BigEndian.AppendUint64(nil, m.Nonce), nil
+}
+
+func (m *PingMessage) UnmarshalBinary(data []byte) error {
+	if len(data) != 8 {
+		return errShortMessage
+	}
+	m.Nonce = binary.BigEndian.Uint64(data)
+	return nil
+}
+
+func (m *PongMessage) MarshalBinary() ([]byte, error) {
+	return binary.BigEndian.AppendUint64(nil, m.Nonce), nil
+}
+
+func (m *PongMessage) UnmarshalBinary(data []byte) error {
+	if len(data) != 8 {
+		return errShortMessage
+	}
+	m.Nonce = binary.BigEndian.Uint64(data)
+	return nil
+}
+
+func (m *InvMessage) MarshalBinary() ([]byte, error) { return marshalInvItems(m.Items), nil }
+
+func (m *InvMessage) UnmarshalBinary(data []byte) error {
+	items, err := unmarshalInvItems(data)
+	m.Items = items
+	return err
+}
+
+func (m *GetDataMessage) MarshalBinary() ([]byte, error) { return marshalInvItems(m.Items), nil }
+
+func (m *GetDataMessage) UnmarshalBinary(data []byte) error {
+	items, err := unmarshalInvItems(data)
+	m.Items = items
+	return err
+}
+
+// MarshalBinary 编码为 4 字节长度 || 不含交易的区块编码 || 4 字节短 ID 数 || 每个 8 字节的短 ID
+func (m *CompactBlockMessage) MarshalBinary() ([]byte, error) {
+	block := &core.Block{Header: m.Header, Validator: m.Validator, Signature: m.Signature}
+	data, err := block.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	buf := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	buf = append(buf, data...)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(m.ShortIDs)))
+	for _, id := range m.ShortIDs {
+		buf = binary.BigEndian.AppendUint64(buf, id)
+	}
+	return buf, nil
+}
+
+func (m *CompactBlockMessage) UnmarshalBinary(data []byte) error {
+	b, rest, err := readBytes(data)
+	if err != nil {
+		return err
+	}
+	block := new(core.Block)
+	if err := block.UnmarshalBinary(b); err != nil {
+		return err
+	}
+	if len(block.Transactions) != 0 {
+		return fmt.Errorf("compact block carries %d transactions", len(block.Transactions))
+	}
+	if len(rest) < 4 {
+		return errShortMessage
+	}
+	n := binary.BigEndian.Uint32(rest)
+	rest = rest[4:]
+	if uint64(len(rest)) != uint64(n)*8 {
+		return fmt.Errorf("compact block with %d short ids has %d bytes", n, len(rest))
+	}
+	m.Header, m.Validator, m.Signature = block.Header, block.Validator, block.Signature
+	m.ShortIDs = make([]uint64, n)
+	for i := range m.ShortIDs {
+		m.ShortIDs[i] = binary.BigEndian.Uint64(rest[i*8:])
+	}
+	return nil
+}
+
+// MarshalBinary 编码为 32 字节区块哈希 || 4 字节下标数 || 每个 4 字节的下标
+func (m *GetBlockTxsMessage) MarshalBinary() ([]byte, error) {
+	buf := append([]byte{}, m.BlockHash[:]...)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(m.Indexes)))
+	for _, i := range m.Indexes {
+		buf = binary.BigEndian.AppendUint32(buf, i)
+	}
+	return buf, nil
+}
+
+func (m *GetBlockTxsMessage) UnmarshalBinary(data []byte) error {
+	if len(data) < 36 {
+		return errShortMessage
+	}
+	m.BlockHash = types.HashFromBytes(data[:32])
+	n := binary.BigEndian.Uint32(data[32:])
+	data = data[36:]
+	if uint64(len(data)) != uint64(n)*4 {
+		return fmt.Errorf("getblocktxs with %d indexes has %d bytes", n, len(data))
+	}
+	m.Indexes = make([]uint32, n)
+	for i := range m.Indexes {
+		m.Indexes[i] = binary.BigEndian.Uint32(data[i*4:])
+	}
+	return nil
+}
+
+// MarshalBinary 编码为 32 字节区块哈希 || 4 字节交易数 || 每笔交易的 4 字节长度 || 交易编码
+func (m *BlockTxsMessage) MarshalBinary() ([]byte, error) {
+	buf := append([]byte{}, m.BlockHash[:]...)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(m.Transactions)))
+	for _, tx := range m.Transactions {
+		data, err := tx.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(data)))
+		buf = append(buf, data...)
+	}
+	return buf, nil
+}
+
+func (m *BlockTxsMessage) UnmarshalBinary(data []byte) error {
+	if len(data) < 36 {
+		return errShortMessage
+	}
+	m.BlockHash = types.HashFromBytes(data[:32])
+	n := binary.BigEndian.Uint32(data[32:])
+	data = data[36:]
+	if uint64(n) > uint64(len(data)) {
+		return errShortMessage
+	}
+	m.Transactions = make([]*core.Transaction, 0, n)
+	for i := uint32(0); i < n; i++ {
+		var b []byte
+		var err error
+		if b, data, err = readBytes(data); err != nil {
+			return err
+		}
+		tx := new(core.Transaction)
+		if err := tx.UnmarshalBinary(b); err != nil {
+			return fmt.Errorf("transaction %d: %w", i, err)
+		}
+		m.Transactions = append(m.Transactions, tx)
+	}
+	if len(data) != 0 {
+		return fmt.Errorf("%d trailing bytes in blocktxs message", len(data))
+	}
+	return nil
+}
+
+// marshalInvItems 编码为 4 字节条目数 || 每个条目的 1 字节类型 || 32 字节哈希
+func marshalInvItems(items []InvItem) []byte {
+	buf := binary.BigEndian.AppendUint32(nil, uint32(len(items)))
+	for _, item := range items {
+		buf = append(buf, byte(item.Type))
+		buf = append(buf, item.Hash[:]...)
+	}
+	return buf
+}
+
+func unmarshalInvItems(data []byte) ([]InvItem, error) {
+	const itemSize = 1 + len(types.Hash{})
+	if len(data) < 4 {
+		return nil, errShortMessage
+	}
+	n := binary.BigEndian.Uint32(data)
+	data = data[4:]
+	if n > MaxInvItems {
+		return nil, fmt.Errorf("too many inventory items: %d", n)
+	}
+	if uint64(len(data)) != uint64(n)*uint64(itemSize) {
+		return nil, fmt.Errorf("inventory of %d items has %d bytes", n, len(data))
+	}
+	items := make([]InvItem, n)
+	for i := range items {
+		items[i].Type = InvType(data[0])
+		copy(items[i].Hash[:], data[1:itemSize])
+		data = data[itemSize:]
+	}
+	return items, nil
+}
+
+// readBytes 读取 4 字节长度 || 内容，返回内容和剩余的数据
+func readBytes(data []byte) ([]byte, []byte, error) {
+	if len(data) < 4 {
+		return nil, nil, errShortMessage
+	}
+	n := binary.BigEndian.Uint32(data)
+	if uint64(n) > uint64(len(data)-4) {
+		return nil, nil, errShortMessage
+	}
+	return data[4 : 4+n], data[4+n:], nil
 }
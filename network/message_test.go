@@ -0,0 +1,59 @@
+package network
+
+import (
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"github.com/virtue186/xchain/core"
+	"github.com/virtue186/xchain/crypto"
+	"testing"
+)
+
+func TestInvMessageBinary(t *testing.T) {
+	inv := &InvMessage{Items: []InvItem{{Type: InvTypeTx}, {Type: InvTypeBlock}}}
+	inv.Items[0].Hash[0] = 1
+	inv.Items[1].Hash[31] = 2
+
+	data, err := core.BinaryCodec{}.Marshal(inv)
+	assert.Nil(t, err)
+	assert.Equal(t, 4+2*33, len(data))
+	decoded := new(GetDataMessage)
+	assert.Nil(t, core.BinaryCodec{}.Unmarshal(data, decoded))
+	assert.Equal(t, inv.Items, decoded.Items)
+
+	assert.NotNil(t, decoded.UnmarshalBinary(data[:len(data)-1]))
+	tooMany := binary.BigEndian.AppendUint32(nil, MaxInvItems+1)
+	assert.NotNil(t, decoded.UnmarshalBinary(append(tooMany, make([]byte, (MaxInvItems+1)*33)...)))
+}
+
+func TestCompactBlockMessagesBinary(t *testing.T) {
+	key := crypto.GeneratePrivateKey()
+	tx := core.NewTransaction([]byte("data"))
+	assert.Nil(t, tx.Sign(key))
+	block, err := core.NewBlock(&core.Header{Version: 1, Height: 3}, []*core.Transaction{tx})
+	assert.Nil(t, err)
+	assert.Nil(t, block.Sign(key))
+
+	cb := NewCompactBlockMessage(block)
+	data, err := core.BinaryCodec{}.Marshal(cb)
+	assert.Nil(t, err)
+	decoded := new(CompactBlockMessage)
+	assert.Nil(t, core.BinaryCodec{}.Unmarshal(data, decoded))
+	assert.Equal(t, block.Hash(core.BlockHasher{}), decoded.Hash())
+	assert.Equal(t, cb.ShortIDs, decoded.ShortIDs)
+	assert.True(t, decoded.Signature.Verify(decoded.Validator, decoded.Header.Bytes()))
+
+	getTxs := &GetBlockTxsMessage{BlockHash: decoded.Hash(), Indexes: []uint32{0, 7}}
+	data, err = core.BinaryCodec{}.Marshal(getTxs)
+	assert.Nil(t, err)
+	decodedGet := new(GetBlockTxsMessage)
+	assert.Nil(t, core.BinaryCodec{}.Unmarshal(data, decodedGet))
+	assert.Equal(t, getTxs, decodedGet)
+
+	txs := &BlockTxsMessage{BlockHash: decoded.Hash(), Transactions: []*core.Transaction{tx}}
+	data, err = core.BinaryCodec{}.Marshal(txs)
+	assert.Nil(t, err)
+	decodedTxs := new(BlockTxsMessage)
+	assert.Nil(t, core.BinaryCodec{}.Unmarshal(data, decodedTxs))
+	assert.Equal(t, tx.Hash(core.TxHasher{}), decodedTxs.Transactions[0].Hash(core.TxHasher{}))
+	assert.NotNil(t, decodedTxs.UnmarshalBinary(data[:len(data)-1]))
+}
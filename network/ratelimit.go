@@ -0,0 +1,73 @@
+package network
+
+import "time"
+
+// RateLimit 描述一个令牌桶：每秒补充 Rate 个令牌，最多积攒 Burst 个
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// DefaultRateLimits 是每个节点按消息类型的默认限速，未列出的类型不限速
+var DefaultRateLimits = map[MessageType]RateLimit{
+	MessageTypeTx:           {Rate: 200, Burst: 400},
+	MessageTypeBlock:        {Rate: 10, Burst: 20},
+	MessageTypeGetStatus:    {Rate: 2, Burst: 5},
+	MessageTypeStatus:       {Rate: 2, Burst: 5},
+	MessageTypeGetBlocks:    {Rate: 5, Burst: 10},
+	MessageTypeBlocks:       {Rate: 5, Burst: 10},
+	MessageTypeGetPeers:     {Rate: 1, Burst: 3},
+	MessageTypePeers:        {Rate: 1, Burst: 3},
+	MessageTypePing:         {Rate: 1, Burst: 5},
+	MessageTypePong:         {Rate: 1, Burst: 5},
+	MessageTypeInv:          {Rate: 50, Burst: 100},
+	MessageTypeGetData:      {Rate: 50, Burst: 100},
+	MessageTypeCompactBlock: {Rate: 10, Burst: 20},
+	MessageTypeGetBlockTxs:  {Rate: 10, Burst: 20},
+	MessageTypeBlockTxs:     {Rate: 10, Burst: 20},
+}
+
+// tokenBucket 是一个令牌桶限速器，不是并发安全的
+type tokenBucket struct {
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit RateLimit, now time.Time) *tokenBucket {
+	return &tokenBucket{limit: limit, tokens: float64(limit.Burst), last: now}
+}
+
+// allow 在有令牌时消耗一个并返回 true
+func (b *tokenBucket) allow(now time.Time) bool {
+	b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate, float64(b.limit.Burst))
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// peerLimiter 按消息类型限制一个节点发来的消息，只在该节点的读协程中使用
+type peerLimiter struct {
+	limits  map[MessageType]RateLimit
+	buckets map[MessageType]*tokenBucket
+}
+
+func newPeerLimiter(limits map[MessageType]RateLimit) *peerLimiter {
+	return &peerLimiter{limits: limits, buckets: make(map[MessageType]*tokenBucket)}
+}
+
+func (l *peerLimiter) allow(t MessageType, now time.Time) bool {
+	b, ok := l.buckets[t]
+	if !ok {
+		limit, limited := l.limits[t]
+		if !limited {
+			return true
+		}
+		b = newTokenBucket(limit, now)
+		l.buckets[t] = b
+	}
+	return b.allow(now)
+}
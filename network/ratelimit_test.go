@@ -0,0 +1,41 @@
+package network
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(RateLimit{Rate: 2, Burst: 3}, now)
+
+	for i := 0; i < 3; i++ {
+		assert.True(t, b.allow(now))
+	}
+	assert.False(t, b.allow(now))
+
+	// 半秒补充一个令牌
+	now = now.Add(500 * time.Millisecond)
+	assert.True(t, b.allow(now))
+	assert.False(t, b.allow(now))
+
+	// 令牌不会超过 Burst
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		assert.True(t, b.allow(now))
+	}
+	assert.False(t, b.allow(now))
+}
+
+func TestPeerLimiter(t *testing.T) {
+	now := time.Now()
+	l := newPeerLimiter(map[MessageType]RateLimit{MessageTypeGetPeers: {Rate: 1, Burst: 1}})
+
+	assert.True(t, l.allow(MessageTypeGetPeers, now))
+	assert.False(t, l.allow(MessageTypeGetPeers, now))
+	// 未配置的类型不限速，各类型互不影响
+	for i := 0; i < 10; i++ {
+		assert.True(t, l.allow(MessageTypeTx, now))
+	}
+}
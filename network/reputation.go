@@ -0,0 +1,111 @@
+package network
+
+import (
+	"net"
+	"time"
+)
+
+// 各类违规行为扣除的分数
+const (
+	PenaltyUndecodable  = 20 // 无法解码的消息
+	PenaltyInvalidTx    = 10 // 签名或格式无效的交易
+	PenaltyInvalidBlock = 50 // 签名、交易或数据哈希无效的区块
+)
+
+const (
+	// DefaultBanThreshold 是默认的封禁阈值，分数降到阈值及以下的节点会被断开并封禁
+	DefaultBanThreshold = -100
+	// DefaultBanDuration 是默认的封禁时长
+	DefaultBanDuration = time.Hour
+)
+
+const (
+	// scoreRecoveryInterval 是节点分数向 0 恢复 1 分所需的时间，偶尔的小违规不会累积成封禁
+	scoreRecoveryInterval = time.Minute
+	// scoreSweepInterval 是清理已断开节点分数的间隔
+	scoreSweepInterval = time.Minute
+)
+
+// peerScore 是节点的分数和最近一次计算分数的时间
+type peerScore struct {
+	value   int
+	updated time.Time
+}
+
+// recover 让分数按经过的时间向 0 恢复
+func (p *peerScore) recover(now time.Time) {
+	steps := int(now.Sub(p.updated) / scoreRecoveryInterval)
+	if steps <= 0 {
+		return
+	}
+	p.value = min(p.value+steps, 0)
+	p.updated = p.updated.Add(time.Duration(steps) * scoreRecoveryInterval)
+}
+
+// Penalize 扣除节点的分数，分数降到阈值及以下时按节点 ID 和 IP 封禁该节点并断开连接
+func (s *Server) Penalize(id NetAddr, penalty int, reason string) {
+	now := time.Now()
+	s.scoreLock.Lock()
+	p, ok := s.scores[id]
+	if !ok {
+		p = &peerScore{updated: now}
+		s.scores[id] = p
+	}
+	p.recover(now)
+	p.value -= penalty
+	score := p.value
+	if score <= s.BanThreshold {
+		// 封禁期满或被解除封禁后节点从 0 分重新开始
+		delete(s.scores, id)
+	}
+	s.scoreLock.Unlock()
+
+	s.Logger.Log("msg", "penalized peer", "peer", id, "penalty", penalty, "score", score, "reason", reason)
+	if score > s.BanThreshold {
+		return
+	}
+
+	ip := ""
+	for _, tr := range s.Transports {
+		for _, p := range tr.Peers() {
+			if p.ID() == id {
+				ip, _, _ = net.SplitHostPort(string(p.RemoteAddr()))
+			}
+		}
+	}
+	s.BanList.Ban(id, ip, s.BanDuration, reason)
+	s.Logger.Log("msg", "banned peer", "peer", id, "ip", ip, "duration", s.BanDuration, "reason", reason)
+	for _, tr := range s.Transports {
+		tr.Disconnect(id)
+	}
+}
+
+// Score 返回节点当前的分数，新节点为 0
+func (s *Server) Score(id NetAddr) int {
+	s.scoreLock.Lock()
+	defer s.scoreLock.Unlock()
+	p, ok := s.scores[id]
+	if !ok {
+		return 0
+	}
+	p.recover(time.Now())
+	return p.value
+}
+
+// sweepScores 删除已经断开的节点和已经恢复到 0 分的节点的分数
+func (s *Server) sweepScores() {
+	connected := make(map[NetAddr]bool)
+	for _, p := range s.Peers() {
+		connected[p.ID()] = true
+	}
+
+	now := time.Now()
+	s.scoreLock.Lock()
+	defer s.scoreLock.Unlock()
+	for id, p := range s.scores {
+		p.recover(now)
+		if !connected[id] || p.value == 0 {
+			delete(s.scores, id)
+		}
+	}
+}
@@ -2,24 +2,39 @@ package network
 
 import (
 	"fmt"
+	"github.com/virtue186/xchain/core"
 )
 
 // MessageType 定义了消息的类型
 const (
-	MessageTypeTx        MessageType = 0x1
-	MessageTypeBlock     MessageType = 0x2
-	MessageTypeGetStatus MessageType = 0x3 // 新增: 请求获取节点状态
-	MessageTypeStatus    MessageType = 0x4 // 新增: 响应节点状态
-	MessageTypeGetBlocks MessageType = 0x5 // 新增: 请求获取区块
-	MessageTypeBlocks    MessageType = 0x6 // 新增: 响应区块请求
+	MessageTypeTx           MessageType = 0x1
+	MessageTypeBlock        MessageType = 0x2
+	MessageTypeGetStatus    MessageType = 0x3  // 新增: 请求获取节点状态
+	MessageTypeStatus       MessageType = 0x4  // 新增: 响应节点状态
+	MessageTypeGetBlocks    MessageType = 0x5  // 新增: 请求获取区块
+	MessageTypeBlocks       MessageType = 0x6  // 新增: 响应区块请求
+	MessageTypeHandshake    MessageType = 0x7  // 握手，只在连接建立时出现
+	MessageTypeAuth         MessageType = 0x8  // 身份认证和密钥交换，握手之前以明文发送
+	MessageTypeGetPeers     MessageType = 0x9  // 请求对方已连接的节点
+	MessageTypePeers        MessageType = 0xa  // 响应节点列表
+	MessageTypePing         MessageType = 0xb  // 心跳，由 Transport 处理，不会交给 Server
+	MessageTypePong         MessageType = 0xc  // 响应心跳
+	MessageTypeInv          MessageType = 0xd  // 通告本节点拥有的交易和区块的哈希
+	MessageTypeGetData      MessageType = 0xe  // 请求通告中缺少的交易和区块
+	MessageTypeCompactBlock MessageType = 0xf  // 只包含区块头和交易短 ID 的区块
+	MessageTypeGetBlockTxs  MessageType = 0x10 // 请求重建区块时缺少的交易
+	MessageTypeBlockTxs     MessageType = 0x11 // 响应缺少的交易
 )
 
 type MessageType byte
 
-// Message 是网络上传输的原始消息结构
+// Message 是网络上传输的原始消息结构。
+// ID 不为 0 时表示一个请求，对方以相同的 ID 回复并设置 Response，从而把响应与请求对应起来
 type Message struct {
-	Header MessageType
-	Data   []byte
+	Header   MessageType
+	ID       uint64
+	Response bool
+	Data     []byte
 }
 
 func NewMessage(header MessageType, data []byte) *Message {
@@ -39,12 +54,14 @@ func NOPHandshakeFunc(Peer) error { return nil }
 type RPC struct {
 	From    NetAddr
 	Message *Message
+	Codec   core.Codec // 解码 Message.Data 所用的编解码器，为空时使用 Server 的默认编解码器
 }
 
 // DecodedMessage 代表一个已经被解码、包含具体业务数据的消息
 type DecodedMessage struct {
 	From NetAddr
-	Data any // Data可以是*core.Transaction, *core.Block等
+	ID   uint64 // 请求 ID，回复时原样带回，为 0 时表示不需要关联响应
+	Data any    // Data可以是*core.Transaction, *core.Block等
 }
 
 // RPCProcessor 是处理已解码消息的接口
@@ -0,0 +1,198 @@
+package network
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/virtue186/xchain/crypto"
+	"io"
+	"math/big"
+)
+
+// 连接建立后，双方先用明文帧交换一条认证消息：
+//
+//	身份公钥 [33] | 临时 ECDH 公钥 [65] | 签名 R [32] | S [32]
+//
+// 签名由身份私钥对 authSignaturePrefix || 临时公钥 签出，证明临时公钥属于该身份。
+// 双方用临时密钥做 P-256 ECDH，再按方向派生两个 AES-256-GCM 密钥，之后的所有帧都加密传输：
+//
+//	长度 u32 大端 | 密文
+//
+// 密文解密后为 类型 u8 | 标志 u8 | 请求 ID u64 大端 | 负载，nonce 为每个方向上从 0 开始递增的计数器。
+// 标志的最低位表示该消息是一个响应。
+const (
+	authMessageSize = 33 + 65 + 32 + 32
+	secureOverhead  = 16 // AES-GCM 认证标签的长度
+	messageHeadSize = 1 + 1 + 8
+
+	flagResponse = 0x1
+)
+
+var authSignaturePrefix = []byte("xchain-p2p-auth-v1")
+
+var ErrDecrypt = errors.New("message authentication failed")
+
+// authMessage 是认证消息的内容
+type authMessage struct {
+	Identity  crypto.PublicKey
+	Ephemeral []byte
+	Signature *crypto.Signature
+}
+
+func newAuthMessage(identity crypto.PrivateKey, ephemeral *ecdh.PrivateKey) (*authMessage, error) {
+	pub := ephemeral.PublicKey().Bytes()
+	sig, err := identity.Sign(append(append([]byte{}, authSignaturePrefix...), pub...))
+	if err != nil {
+		return nil, err
+	}
+	return &authMessage{Identity: identity.PublicKey(), Ephemeral: pub, Signature: sig}, nil
+}
+
+func (m *authMessage) encode() []byte {
+	buf := make([]byte, 0, authMessageSize)
+	buf = append(buf, m.Identity...)
+	buf = append(buf, m.Ephemeral...)
+	buf = append(buf, m.Signature.R.FillBytes(make([]byte, 32))...)
+	return append(buf, m.Signature.S.FillBytes(make([]byte, 32))...)
+}
+
+// decodeAuthMessage 解析认证消息并验证签名
+func decodeAuthMessage(data []byte) (*authMessage, error) {
+	if len(data) != authMessageSize {
+		return nil, fmt.Errorf("invalid auth message size %d", len(data))
+	}
+	m := &authMessage{
+		Identity:  crypto.PublicKey(data[:33]),
+		Ephemeral: data[33:98],
+		Signature: &crypto.Signature{
+			R: new(big.Int).SetBytes(data[98:130]),
+			S: new(big.Int).SetBytes(data[130:]),
+		},
+	}
+	signed := append(append([]byte{}, authSignaturePrefix...), m.Ephemeral...)
+	if !m.Signature.Verify(m.Identity, signed) {
+		return nil, fmt.Errorf("invalid auth signature")
+	}
+	return m, nil
+}
+
+// secureConn 在底层连接上收发加密帧。握手后写入只在 TCPPeer 的写协程中进行，读取只在连接的读协程中进行
+type secureConn struct {
+	rw           io.ReadWriter
+	maxFrameSize int
+
+	sendAEAD, recvAEAD   cipher.AEAD
+	sendNonce, recvNonce uint64
+}
+
+// newSecureConn 由 ECDH 共享密钥派生双方向的密钥，initiator 表示本方是否主动发起连接
+func newSecureConn(rw io.ReadWriter, maxFrameSize int, ephemeral *ecdh.PrivateKey, remoteEphemeral []byte, initiator bool) (*secureConn, error) {
+	remote, err := ecdh.P256().NewPublicKey(remoteEphemeral)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ephemeral key: %w", err)
+	}
+	secret, err := ephemeral.ECDH(remote)
+	if err != nil {
+		return nil, err
+	}
+
+	// 两个方向的密钥都绑定了双方的临时公钥
+	initEph, respEph := ephemeral.PublicKey().Bytes(), remoteEphemeral
+	if !initiator {
+		initEph, respEph = respEph, initEph
+	}
+	deriveKey := func(label string) (cipher.AEAD, error) {
+		h := sha256.New()
+		h.Write([]byte(label))
+		h.Write(secret)
+		h.Write(initEph)
+		h.Write(respEph)
+		block, err := aes.NewCipher(h.Sum(nil))
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	}
+	initKey, err := deriveKey("xchain initiator")
+	if err != nil {
+		return nil, err
+	}
+	respKey, err := deriveKey("xchain responder")
+	if err != nil {
+		return nil, err
+	}
+
+	c := &secureConn{rw: rw, maxFrameSize: maxFrameSize, sendAEAD: initKey, recvAEAD: respKey}
+	if !initiator {
+		c.sendAEAD, c.recvAEAD = respKey, initKey
+	}
+	return c, nil
+}
+
+func nonce(counter uint64) []byte {
+	n := make([]byte, 12)
+	binary.BigEndian.PutUint64(n[4:], counter)
+	return n
+}
+
+// WriteMessage 加密并写入一个帧
+func (c *secureConn) WriteMessage(msg *Message) error {
+	size := messageHeadSize + len(msg.Data)
+	if size > c.maxFrameSize {
+		return fmt.Errorf("%w: %d bytes exceeds limit of %d", ErrFrameTooLarge, size, c.maxFrameSize)
+	}
+	var flags byte
+	if msg.Response {
+		flags |= flagResponse
+	}
+	plain := make([]byte, 0, size)
+	plain = append(plain, byte(msg.Header), flags)
+	plain = binary.BigEndian.AppendUint64(plain, msg.ID)
+	plain = append(plain, msg.Data...)
+
+	buf := make([]byte, frameHeaderSize, frameHeaderSize+size+secureOverhead)
+	buf = c.sendAEAD.Seal(buf, nonce(c.sendNonce), plain, nil)
+	c.sendNonce++
+	binary.BigEndian.PutUint32(buf, uint32(len(buf)-frameHeaderSize))
+	_, err := c.rw.Write(buf)
+	return err
+}
+
+// ReadMessage 读取并解密一个帧，认证失败时连接应当被关闭
+func (c *secureConn) ReadMessage() (*Message, error) {
+	var header [frameHeaderSize]byte
+	if _, err := io.ReadFull(c.rw, header[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size <= secureOverhead {
+		return nil, ErrEmptyFrame
+	}
+	if uint64(size) > uint64(c.maxFrameSize+secureOverhead) {
+		return nil, fmt.Errorf("%w: %d bytes exceeds limit of %d", ErrFrameTooLarge, size-secureOverhead, c.maxFrameSize)
+	}
+
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(c.rw, buf); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	plain, err := c.recvAEAD.Open(buf[:0], nonce(c.recvNonce), buf, nil)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	c.recvNonce++
+	if len(plain) < messageHeadSize {
+		return nil, ErrEmptyFrame
+	}
+	msg := NewMessage(MessageType(plain[0]), plain[messageHeadSize:])
+	msg.Response = plain[1]&flagResponse != 0
+	msg.ID = binary.BigEndian.Uint64(plain[2:messageHeadSize])
+	return msg, nil
+}
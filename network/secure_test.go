@@ -0,0 +1,56 @@
+package network
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"github.com/stretchr/testify/assert"
+	"github.com/virtue186/xchain/crypto"
+	"testing"
+)
+
+func TestAuthMessage(t *testing.T) {
+	identity := crypto.GeneratePrivateKey()
+	ephemeral, _ := ecdh.P256().GenerateKey(rand.Reader)
+	msg, err := newAuthMessage(identity, ephemeral)
+	assert.Nil(t, err)
+
+	data := msg.encode()
+	decoded, err := decodeAuthMessage(data)
+	assert.Nil(t, err)
+	assert.Equal(t, identity.PublicKey(), decoded.Identity)
+
+	// 替换临时公钥后签名不再有效
+	other, _ := ecdh.P256().GenerateKey(rand.Reader)
+	copy(data[33:98], other.PublicKey().Bytes())
+	_, err = decodeAuthMessage(data)
+	assert.NotNil(t, err)
+}
+
+func TestSecureConn(t *testing.T) {
+	a, _ := ecdh.P256().GenerateKey(rand.Reader)
+	b, _ := ecdh.P256().GenerateKey(rand.Reader)
+	wire := new(bytes.Buffer)
+	sender, err := newSecureConn(wire, 64, a, b.PublicKey().Bytes(), true)
+	assert.Nil(t, err)
+	receiver, err := newSecureConn(wire, 64, b, a.PublicKey().Bytes(), false)
+	assert.Nil(t, err)
+
+	assert.Nil(t, sender.WriteMessage(NewMessage(MessageTypeTx, []byte("secret payload"))))
+	assert.Nil(t, sender.WriteMessage(NewMessage(MessageTypeGetStatus, nil)))
+	assert.False(t, bytes.Contains(wire.Bytes(), []byte("secret payload")))
+
+	msg, err := receiver.ReadMessage()
+	assert.Nil(t, err)
+	assert.Equal(t, MessageTypeTx, msg.Header)
+	assert.Equal(t, []byte("secret payload"), msg.Data)
+	msg, err = receiver.ReadMessage()
+	assert.Nil(t, err)
+	assert.Equal(t, MessageTypeGetStatus, msg.Header)
+
+	// 被篡改的帧无法通过认证
+	assert.Nil(t, sender.WriteMessage(NewMessage(MessageTypeTx, []byte("payload"))))
+	wire.Bytes()[wire.Len()-1] ^= 1
+	_, err = receiver.ReadMessage()
+	assert.ErrorIs(t, err, ErrDecrypt)
+}
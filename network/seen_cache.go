@@ -0,0 +1,62 @@
+package network
+
+import (
+	"github.com/virtue186/xchain/types"
+	"sync"
+)
+
+// DefaultSeenCacheSize 是 SeenCache 默认记住的消息数
+const DefaultSeenCacheSize = 4096
+
+// SeenCache 记录最近见过的消息哈希，用于在转发时去重。
+// 容量满后最早加入的哈希被淘汰，内存占用不随时间增长
+type SeenCache struct {
+	lock   sync.Mutex
+	hashes map[types.Hash]struct{}
+	ring   []types.Hash
+	next   int
+}
+
+func NewSeenCache(size int) *SeenCache {
+	if size <= 0 {
+		size = DefaultSeenCacheSize
+	}
+	return &SeenCache{
+		hashes: make(map[types.Hash]struct{}, size),
+		ring:   make([]types.Hash, 0, size),
+	}
+}
+
+// Add 记录 hash，hash 之前没有见过时返回 true
+func (c *SeenCache) Add(hash types.Hash) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, ok := c.hashes[hash]; ok {
+		return false
+	}
+	if len(c.ring) < cap(c.ring) {
+		c.ring = append(c.ring, hash)
+	} else {
+		delete(c.hashes, c.ring[c.next])
+		c.ring[c.next] = hash
+		c.next = (c.next + 1) % len(c.ring)
+	}
+	c.hashes[hash] = struct{}{}
+	return true
+}
+
+// Contains 判断 hash 是否见过
+func (c *SeenCache) Contains(hash types.Hash) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	_, ok := c.hashes[hash]
+	return ok
+}
+
+// Len 返回记录的哈希数
+func (c *SeenCache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.hashes)
+}
@@ -0,0 +1,30 @@
+package network
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/virtue186/xchain/types"
+	"testing"
+)
+
+func TestSeenCache(t *testing.T) {
+	c := NewSeenCache(3)
+	hashes := make([]types.Hash, 5)
+	for i := range hashes {
+		hashes[i][0] = byte(i + 1)
+	}
+
+	assert.True(t, c.Add(hashes[0]))
+	assert.False(t, c.Add(hashes[0]))
+	assert.True(t, c.Add(hashes[1]))
+	assert.True(t, c.Add(hashes[2]))
+	assert.Equal(t, 3, c.Len())
+
+	// 容量满后淘汰最早的哈希
+	assert.True(t, c.Add(hashes[3]))
+	assert.False(t, c.Contains(hashes[0]))
+	assert.True(t, c.Add(hashes[4]))
+	assert.False(t, c.Contains(hashes[1]))
+	assert.True(t, c.Contains(hashes[2]))
+	assert.Equal(t, 3, c.Len())
+	assert.True(t, c.Add(hashes[0]))
+}
@@ -1,11 +1,16 @@
 package network
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/go-kit/log"
 	"github.com/virtue186/xchain/core"
 	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type ServerOpts struct {
@@ -13,14 +18,48 @@ type ServerOpts struct {
 	Logger       log.Logger
 	RPCProcessor RPCProcessor // 依赖注入！
 	Transports   []Transport
-	Encoder      core.Encoder[any] // 新增：统一的编码器
-	Decoder      core.Decoder[any] // 新增：统一的解码器
+	Codec        string // 默认编解码器的名字，用于解码未携带编解码器的 RPC，为空时使用 JSON
+	BanList      *BanList
+	BanThreshold int           // 为 0 时使用 DefaultBanThreshold
+	BanDuration  time.Duration // 为 0 时使用 DefaultBanDuration
+	// RequestTimeout 是等待一个请求响应的最长时间，为 0 时使用 DefaultRequestTimeout
+	RequestTimeout time.Duration
+	// Clock 用于请求超时，以及依赖 Server 的服务中的定时器，为空时使用 SystemClock
+	Clock Clock
+}
+
+const (
+	DefaultRequestTimeout = 10 * time.Second
+	// maxRequestAttempts 是 RequestAny 最多尝试的节点数
+	maxRequestAttempts = 3
+)
+
+var (
+	ErrRequestTimeout = errors.New("request timed out")
+	ErrServerStopped  = errors.New("server stopped")
+)
+
+// pendingRequest 是一个等待响应的请求，只接受来自 to 的响应
+type pendingRequest struct {
+	to NetAddr
+	ch chan *DecodedMessage
 }
 
 type Server struct {
 	ServerOpts
-	rpcCh  chan RPC
-	quitCh chan struct{}
+	codec core.Codec
+
+	scoreLock sync.Mutex
+	scores    map[NetAddr]*peerScore // 节点的分数，违规时被扣分，随时间恢复
+
+	requestLock sync.Mutex
+	nextRequest uint64
+	pending     map[uint64]*pendingRequest
+	waiting     atomic.Int64 // 还没有收到响应的请求数
+
+	rpcCh   chan RPC
+	handled atomic.Uint64 // 已经处理完的消息数
+	done    chan struct{} // Start 返回时关闭，等待中的请求随之失败
 }
 
 func NewServer(opts ServerOpts) *Server {
@@ -28,17 +67,38 @@ func NewServer(opts ServerOpts) *Server {
 		opts.Logger = log.NewLogfmtLogger(os.Stderr)
 		opts.Logger = log.With(opts.Logger, "ID", opts.ID)
 	}
-	if opts.Encoder == nil {
-		opts.Encoder = core.JSONEncoder[any]{}
+	if opts.Codec == "" {
+		opts.Codec = core.CodecJSON
+	}
+	codec, err := core.LookupCodec(opts.Codec)
+	if err != nil {
+		opts.Logger.Log("msg", "falling back to json codec", "err", err)
+		codec = core.JSONCodec{}
+	}
+
+	if opts.BanList == nil {
+		opts.BanList = NewBanList()
+	}
+	if opts.BanThreshold == 0 {
+		opts.BanThreshold = DefaultBanThreshold
+	}
+	if opts.BanDuration == 0 {
+		opts.BanDuration = DefaultBanDuration
+	}
+	if opts.RequestTimeout == 0 {
+		opts.RequestTimeout = DefaultRequestTimeout
 	}
-	if opts.Decoder == nil {
-		opts.Decoder = core.JSONDecoder[any]{}
+	if opts.Clock == nil {
+		opts.Clock = SystemClock
 	}
 
 	s := &Server{
 		ServerOpts: opts,
+		codec:      codec,
+		scores:     make(map[NetAddr]*peerScore),
+		pending:    make(map[uint64]*pendingRequest),
 		rpcCh:      make(chan RPC),
-		quitCh:     make(chan struct{}, 1),
+		done:       make(chan struct{}),
 	}
 	if s.RPCProcessor == nil {
 		s.RPCProcessor = &NOPRPCProcessor{}
@@ -47,97 +107,218 @@ func NewServer(opts ServerOpts) *Server {
 	return s
 }
 
-func (s *Server) Start() {
-	s.InitTransports()
-free:
+// Start 接收并处理各 Transport 的消息，直到 ctx 被取消。正在处理的消息会先处理完
+func (s *Server) Start(ctx context.Context) {
+	defer close(s.done)
+	s.InitTransports(ctx)
+	sweep := time.NewTicker(scoreSweepInterval)
+	defer sweep.Stop()
 	for {
 		select {
-		case rpc := <-s.rpcCh:
-			msg := rpc.Message
-			from := rpc.From
+		case <-sweep.C:
+			s.sweepScores()
 
-			decodedMsg, err := s.decodeMessageData(msg, from)
-			if err != nil {
-				s.Logger.Log("msg", "failed to decode message data", "err", err, "from", from)
-				continue
-			}
-			if err := s.RPCProcessor.ProcessMessage(decodedMsg); err != nil {
-				s.Logger.Log("msg", "failed to process message", "err", err, "from", from)
-			}
+		case rpc := <-s.rpcCh:
+			s.handleRPC(rpc)
+			s.handled.Add(1)
 
-		case <-s.quitCh:
-			break free
+		case <-ctx.Done():
+			s.Logger.Log("msg", "server is shutting down")
+			return
 		}
 	}
-	s.Logger.Log("msg", "server is shutting down")
 }
 
-func (s *Server) InitTransports() {
+// handleRPC 解码并处理一条消息，无法解码的消息会降低发送方的评分
+func (s *Server) handleRPC(rpc RPC) {
+	decodedMsg, err := s.decodeMessageData(rpc)
+	if err != nil {
+		s.Logger.Log("msg", "failed to decode message data", "err", err, "from", rpc.From)
+		s.Penalize(rpc.From, PenaltyUndecodable, err.Error())
+		return
+	}
+	if rpc.Message.Response {
+		s.deliverResponse(decodedMsg)
+		return
+	}
+	if err := s.RPCProcessor.ProcessMessage(decodedMsg); err != nil {
+		s.Logger.Log("msg", "failed to process message", "err", err, "from", rpc.From)
+	}
+}
+
+// Handled 返回已经处理完的消息数
+func (s *Server) Handled() uint64 {
+	return s.handled.Load()
+}
+
+func (s *Server) InitTransports(ctx context.Context) {
 	for _, transport := range s.Transports {
 		go func(tr Transport) {
-			for rpc := range tr.Consume() {
-				s.rpcCh <- rpc
+			for {
+				select {
+				case rpc := <-tr.Consume():
+					select {
+					case s.rpcCh <- rpc:
+					case <-ctx.Done():
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
 			}
 		}(transport)
 	}
 }
 
-func (s *Server) Broadcast(payload []byte) error {
+// Broadcast 向所有节点广播 payload，由 Transport 按各连接的编解码器编码
+func (s *Server) Broadcast(msgType MessageType, payload any) error {
 	for _, tr := range s.Transports {
-		if err := tr.Broadcast(payload); err != nil {
+		if err := tr.Broadcast(msgType, payload); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// Peers 返回各 Transport 的全部连接
+func (s *Server) Peers() []Peer {
+	var peers []Peer
+	for _, tr := range s.Transports {
+		peers = append(peers, tr.Peers()...)
+	}
+	return peers
+}
+
+// BroadcastExcept 向除 except 以外的所有节点发送 payload，用于转发收到的消息。
+// 某个节点发送失败不影响其他节点，返回全部错误
+func (s *Server) BroadcastExcept(except NetAddr, msgType MessageType, payload any) error {
+	var errs []error
+	for _, tr := range s.Transports {
+		for _, p := range tr.Peers() {
+			if p.ID() == except {
+				continue
+			}
+			if err := p.Send(msgType, payload); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
 // decodeMessageData 是 Server 的一个辅助方法，负责解码 Message.Data
-func (s *Server) decodeMessageData(msg *Message, from NetAddr) (*DecodedMessage, error) {
-	decodedMsg := &DecodedMessage{From: from}
+func (s *Server) decodeMessageData(rpc RPC) (*DecodedMessage, error) {
+	msg := rpc.Message
+	codec := rpc.Codec
+	if codec == nil {
+		codec = s.codec
+	}
+	decodedMsg := &DecodedMessage{From: rpc.From, ID: msg.ID}
 
 	switch msg.Header {
 	case MessageTypeTx:
 		tx := new(core.Transaction)
-		if err := s.Decoder.Decode(bytes.NewReader(msg.Data), tx); err != nil {
+		if err := codec.Unmarshal(msg.Data, tx); err != nil {
 			return nil, fmt.Errorf("decode transaction error: %w", err)
 		}
 		decodedMsg.Data = tx
 
 	case MessageTypeBlock:
 		b := new(core.Block)
-		if err := s.Decoder.Decode(bytes.NewReader(msg.Data), b); err != nil {
+		if err := codec.Unmarshal(msg.Data, b); err != nil {
 			return nil, fmt.Errorf("decode block error: %w", err)
 		}
+		if err := validateBlock(b); err != nil {
+			return nil, fmt.Errorf("invalid block message: %w", err)
+		}
 		decodedMsg.Data = b
 
 	case MessageTypeGetStatus:
 		getStatusMsg := new(GetStatusMessage)
-		if err := s.Decoder.Decode(bytes.NewReader(msg.Data), getStatusMsg); err != nil {
+		if err := codec.Unmarshal(msg.Data, getStatusMsg); err != nil {
 			return nil, fmt.Errorf("failed to decode getstatus message: %w", err)
 		}
 		decodedMsg.Data = getStatusMsg
 
 	case MessageTypeStatus:
 		statusMsg := new(StatusMessage)
-		if err := s.Decoder.Decode(bytes.NewReader(msg.Data), statusMsg); err != nil {
+		if err := codec.Unmarshal(msg.Data, statusMsg); err != nil {
 			return nil, fmt.Errorf("failed to decode status message: %w", err)
 		}
 		decodedMsg.Data = statusMsg
 
 	case MessageTypeGetBlocks:
 		getBlocksMsg := new(GetBlocksMessage)
-		if err := s.Decoder.Decode(bytes.NewReader(msg.Data), getBlocksMsg); err != nil {
+		if err := codec.Unmarshal(msg.Data, getBlocksMsg); err != nil {
 			return nil, fmt.Errorf("failed to decode getblocks message: %w", err)
 		}
 		decodedMsg.Data = getBlocksMsg
 
 	case MessageTypeBlocks:
 		blocksMsg := new(BlocksMessage)
-		if err := s.Decoder.Decode(bytes.NewReader(msg.Data), blocksMsg); err != nil {
+		if err := codec.Unmarshal(msg.Data, blocksMsg); err != nil {
 			return nil, fmt.Errorf("failed to decode blocks message: %w", err)
 		}
+		if err := blocksMsg.validate(); err != nil {
+			return nil, fmt.Errorf("invalid blocks message: %w", err)
+		}
 		decodedMsg.Data = blocksMsg
 
+	case MessageTypeGetPeers:
+		getPeersMsg := new(GetPeersMessage)
+		if err := codec.Unmarshal(msg.Data, getPeersMsg); err != nil {
+			return nil, fmt.Errorf("failed to decode getpeers message: %w", err)
+		}
+		decodedMsg.Data = getPeersMsg
+
+	case MessageTypePeers:
+		peersMsg := new(PeersMessage)
+		if err := codec.Unmarshal(msg.Data, peersMsg); err != nil {
+			return nil, fmt.Errorf("failed to decode peers message: %w", err)
+		}
+		decodedMsg.Data = peersMsg
+
+	case MessageTypeInv:
+		invMsg := new(InvMessage)
+		if err := codec.Unmarshal(msg.Data, invMsg); err != nil {
+			return nil, fmt.Errorf("failed to decode inv message: %w", err)
+		}
+		decodedMsg.Data = invMsg
+
+	case MessageTypeGetData:
+		getDataMsg := new(GetDataMessage)
+		if err := codec.Unmarshal(msg.Data, getDataMsg); err != nil {
+			return nil, fmt.Errorf("failed to decode getdata message: %w", err)
+		}
+		decodedMsg.Data = getDataMsg
+
+	case MessageTypeCompactBlock:
+		compactMsg := new(CompactBlockMessage)
+		if err := codec.Unmarshal(msg.Data, compactMsg); err != nil {
+			return nil, fmt.Errorf("failed to decode compact block message: %w", err)
+		}
+		if err := compactMsg.validate(); err != nil {
+			return nil, fmt.Errorf("invalid compact block message: %w", err)
+		}
+		decodedMsg.Data = compactMsg
+
+	case MessageTypeGetBlockTxs:
+		getBlockTxsMsg := new(GetBlockTxsMessage)
+		if err := codec.Unmarshal(msg.Data, getBlockTxsMsg); err != nil {
+			return nil, fmt.Errorf("failed to decode getblocktxs message: %w", err)
+		}
+		decodedMsg.Data = getBlockTxsMsg
+
+	case MessageTypeBlockTxs:
+		blockTxsMsg := new(BlockTxsMessage)
+		if err := codec.Unmarshal(msg.Data, blockTxsMsg); err != nil {
+			return nil, fmt.Errorf("failed to decode blocktxs message: %w", err)
+		}
+		if err := blockTxsMsg.validate(); err != nil {
+			return nil, fmt.Errorf("invalid blocktxs message: %w", err)
+		}
+		decodedMsg.Data = blockTxsMsg
+
 	default:
 		return nil, fmt.Errorf("unknown message header: %v", msg.Header)
 	}
@@ -145,14 +326,133 @@ func (s *Server) decodeMessageData(msg *Message, from NetAddr) (*DecodedMessage,
 	return decodedMsg, nil
 }
 
-func (s *Server) SendMessage(to NetAddr, payload []byte) error {
+// Request 向节点 to 发送一个请求并等待它的响应，超过 RequestTimeout 时返回 ErrRequestTimeout。
+// 响应由 Start 的消息循环交付，因此不能在 RPCProcessor 中同步调用
+func (s *Server) Request(to NetAddr, msgType MessageType, payload any) (*DecodedMessage, error) {
+	req := &pendingRequest{to: to, ch: make(chan *DecodedMessage, 1)}
+	s.requestLock.Lock()
+	s.nextRequest++
+	id := s.nextRequest
+	s.pending[id] = req
+	s.waiting.Add(1)
+	s.requestLock.Unlock()
+
+	defer s.takePending(id)
+
+	if err := s.sendWithID(to, id, false, msgType, payload); err != nil {
+		return nil, err
+	}
+	timer := s.Clock.NewTimer(s.RequestTimeout)
+	defer timer.Stop()
+	select {
+	case resp := <-req.ch:
+		return resp, nil
+	case <-timer.C():
+		return nil, fmt.Errorf("%w: %s did not respond in %s", ErrRequestTimeout, to, s.RequestTimeout)
+	case <-s.done:
+		return nil, ErrServerStopped
+	}
+}
+
+// RequestAny 先向 preferred 发送请求，失败时按往返时间从低到高换其他已连接的节点重试，
+// 最多尝试 maxRequestAttempts 个节点。accept 用来检查响应，返回错误时同样换下一个节点，可以为空
+func (s *Server) RequestAny(preferred NetAddr, msgType MessageType, payload any, accept func(*DecodedMessage) error) (*DecodedMessage, error) {
+	var others []Peer
+	for _, tr := range s.Transports {
+		for _, p := range tr.Peers() {
+			if p.ID() != preferred {
+				others = append(others, p)
+			}
+		}
+	}
+	sortByRTT(others)
+	candidates := []NetAddr{preferred}
+	for _, p := range others {
+		candidates = append(candidates, p.ID())
+	}
+
+	var err error
+	for i, to := range candidates {
+		if i == maxRequestAttempts {
+			break
+		}
+		var resp *DecodedMessage
+		if resp, err = s.Request(to, msgType, payload); err == nil && accept != nil {
+			err = accept(resp)
+		}
+		if err == nil {
+			return resp, nil
+		}
+		s.Logger.Log("msg", "request failed", "to", to, "type", msgType, "err", err)
+	}
+	return nil, err
+}
+
+// sortByRTT 按往返时间从低到高排序，尚未测量的节点排在最后
+func sortByRTT(peers []Peer) {
+	sort.SliceStable(peers, func(i, j int) bool {
+		a, b := peers[i].RTT(), peers[j].RTT()
+		if a == 0 || b == 0 {
+			return b == 0 && a != 0
+		}
+		return a < b
+	})
+}
+
+// Reply 回复请求 req，req 不是请求时作为普通消息发送
+func (s *Server) Reply(req *DecodedMessage, msgType MessageType, payload any) error {
+	if req.ID == 0 {
+		return s.SendMessage(req.From, msgType, payload)
+	}
+	return s.sendWithID(req.From, req.ID, true, msgType, payload)
+}
+
+// deliverResponse 把响应交给等待它的请求，过期或未请求过的响应被丢弃
+func (s *Server) deliverResponse(msg *DecodedMessage) {
+	s.requestLock.Lock()
+	req, ok := s.pending[msg.ID]
+	if ok && req.to == msg.From {
+		delete(s.pending, msg.ID)
+		s.waiting.Add(-1)
+	}
+	s.requestLock.Unlock()
+	if !ok || req.to != msg.From {
+		s.Logger.Log("msg", "dropping unexpected response", "from", msg.From, "id", msg.ID)
+		return
+	}
+	select {
+	case req.ch <- msg:
+	default:
+	}
+}
+
+// takePending 删除一个还没有收到响应的请求
+func (s *Server) takePending(id uint64) {
+	s.requestLock.Lock()
+	defer s.requestLock.Unlock()
+	if _, ok := s.pending[id]; ok {
+		delete(s.pending, id)
+		s.waiting.Add(-1)
+	}
+}
+
+// Waiting 返回已经发出、还没有收到响应也没有超时的请求数
+func (s *Server) Waiting() int64 {
+	return s.waiting.Load()
+}
+
+func (s *Server) SendMessage(to NetAddr, msgType MessageType, payload any) error {
+	return s.sendWithID(to, 0, false, msgType, payload)
+}
+
+func (s *Server) sendWithID(to NetAddr, id uint64, response bool, msgType MessageType, payload any) error {
 	for _, tr := range s.Transports {
 		// 这里假设 Transport 知道如何处理 NetAddr。
 		// TCPTransport 会在其 peer map 中查找。
 		// 如果一个 Server 连接了多个 transport, 需要 Transport 层能区分 peer。
 		// 当前设计是每个 Transport 维护自己的 peer 列表，所以我们尝试通过每个 transport 发送。
 		// 如果找到对应的 peer，SendMessage 会成功。
-		if err := tr.SendMessage(to, payload); err == nil {
+		if err := tr.SendWithID(to, id, response, msgType, payload); err == nil {
 			return nil
 		}
 	}
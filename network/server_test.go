@@ -0,0 +1,124 @@
+package network
+
+import (
+	"context"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/virtue186/xchain/core"
+	"testing"
+	"time"
+)
+
+type processorFunc func(*DecodedMessage) error
+
+func (f processorFunc) ProcessMessage(msg *DecodedMessage) error { return f(msg) }
+
+func TestServerRequest(t *testing.T) {
+	ta := NewTCPTransport(TCPTransportOpts{ListenAddr: "127.0.0.1:0", HandshakeFunc: NOPHandshakeFunc})
+	tb := NewTCPTransport(TCPTransportOpts{ListenAddr: "127.0.0.1:0", HandshakeFunc: NOPHandshakeFunc})
+	assert.Nil(t, ta.ListenAndAccept())
+	assert.Nil(t, tb.ListenAndAccept())
+	defer ta.Close()
+	defer tb.Close()
+
+	a := NewServer(ServerOpts{Logger: log.NewNopLogger(), Transports: []Transport{ta}, RequestTimeout: 200 * time.Millisecond})
+	var b *Server
+	b = NewServer(ServerOpts{
+		Logger:     log.NewNopLogger(),
+		Transports: []Transport{tb},
+		// b 只回复状态请求，不回复区块请求
+		RPCProcessor: processorFunc(func(msg *DecodedMessage) error {
+			if _, ok := msg.Data.(*GetStatusMessage); ok {
+				return b.Reply(msg, MessageTypeStatus, &StatusMessage{CurrentHeight: 42})
+			}
+			return nil
+		}),
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go a.Start(ctx)
+	go b.Start(ctx)
+
+	assert.Nil(t, ta.Dial(tb.listener.Addr().String()))
+	resp, err := a.Request(tb.ID(), MessageTypeGetStatus, new(GetStatusMessage))
+	assert.Nil(t, err)
+	assert.Equal(t, tb.ID(), resp.From)
+	assert.Equal(t, uint32(42), resp.Data.(*StatusMessage).CurrentHeight)
+
+	_, err = a.Request(tb.ID(), MessageTypeGetBlocks, &GetBlocksMessage{From: 1})
+	assert.ErrorIs(t, err, ErrRequestTimeout)
+
+	// accept 拒绝的响应同样算作失败
+	_, err = a.RequestAny(tb.ID(), MessageTypeGetStatus, new(GetStatusMessage), func(msg *DecodedMessage) error {
+		return ErrRequestTimeout
+	})
+	assert.NotNil(t, err)
+	assert.Empty(t, a.pending)
+}
+
+type rttPeer struct {
+	TCPPeer
+	id  NetAddr
+	rtt time.Duration
+}
+
+func (p *rttPeer) ID() NetAddr        { return p.id }
+func (p *rttPeer) RTT() time.Duration { return p.rtt }
+
+func TestSortByRTT(t *testing.T) {
+	peers := []Peer{
+		&rttPeer{id: "unmeasured"},
+		&rttPeer{id: "slow", rtt: 300 * time.Millisecond},
+		&rttPeer{id: "fast", rtt: 20 * time.Millisecond},
+	}
+	sortByRTT(peers)
+	var ids []NetAddr
+	for _, p := range peers {
+		ids = append(ids, p.ID())
+	}
+	assert.Equal(t, []NetAddr{"fast", "slow", "unmeasured"}, ids)
+}
+
+func TestServerBroadcastExcept(t *testing.T) {
+	net := NewLocalNetwork()
+	hub := net.NewTransport("hub")
+	peers := []*LocalTransport{net.NewTransport("a"), net.NewTransport("b"), net.NewTransport("c")}
+	for _, p := range peers {
+		assert.Nil(t, hub.Dial(string(p.Addr())))
+	}
+
+	s := NewServer(ServerOpts{Logger: log.NewNopLogger(), Transports: []Transport{hub}})
+	assert.Nil(t, s.BroadcastExcept("b", MessageTypeGetStatus, new(GetStatusMessage)))
+	assert.Equal(t, 1, len(peers[0].Consume()))
+	assert.Equal(t, 0, len(peers[1].Consume()))
+	assert.Equal(t, 1, len(peers[2].Consume()))
+}
+
+func TestServerRejectsNullFields(t *testing.T) {
+	processed := make(chan *DecodedMessage, 4)
+	s := NewServer(ServerOpts{
+		Logger: log.NewNopLogger(),
+		RPCProcessor: processorFunc(func(msg *DecodedMessage) error {
+			processed <- msg
+			return nil
+		}),
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Start(ctx)
+
+	// json 编码的消息可以把指针字段写成 null，处理时会解引用空指针
+	for _, c := range []struct {
+		msgType MessageType
+		data    string
+	}{
+		{MessageTypeCompactBlock, `{"Header":null,"ShortIDs":[1]}`},
+		{MessageTypeBlockTxs, `{"Transactions":[null]}`},
+		{MessageTypeBlock, `{"Header":null}`},
+		{MessageTypeBlocks, `{"Blocks":[{"Header":{},"Transactions":[null]}]}`},
+	} {
+		s.rpcCh <- RPC{From: "peer", Message: NewMessage(c.msgType, []byte(c.data)), Codec: core.JSONCodec{}}
+	}
+	assert.Eventually(t, func() bool { return s.Score("peer") == -4*PenaltyUndecodable }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 0, len(processed))
+}
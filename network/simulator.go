@@ -0,0 +1,307 @@
+package network
+
+import (
+	"container/heap"
+	"hash/fnv"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// LinkConfig 描述一个方向上的链路条件
+type LinkConfig struct {
+	Delay     time.Duration // 固定的传输延迟
+	Jitter    time.Duration // 在 [-Jitter, Jitter] 内均匀分布的额外延迟
+	DropRate  float64       // 消息被丢弃的概率
+	Bandwidth int           // 每秒可以传输的字节数，为 0 时不限制
+}
+
+// Simulator 是 LocalNetwork 的控制器，按链路条件延迟、丢弃消息，并可以把节点划分到互不连通的分区中。
+//
+// 模拟器使用虚拟时钟，消息只在 Advance 推进时钟时投递，它也实现了 Clock，定时器同样在 Advance 时触发。每条链路的随机数由种子和链路两端的地址派生，
+// 只要每条链路上发送消息的顺序相同，丢包和延迟就完全相同。同一条链路上的消息按发送顺序到达
+type Simulator struct {
+	net         *LocalNetwork
+	seed        int64
+	defaultLink LinkConfig
+
+	lock   sync.Mutex
+	now    time.Time
+	seq    uint64
+	queue  simQueue
+	timers []*simTimer
+	links  map[[2]NetAddr]*simLink
+	groups map[NetAddr]int // 节点所在的分区，未划分的节点都在分区 0
+}
+
+type simLink struct {
+	cfg       LinkConfig
+	rng       *rand.Rand
+	busyUntil time.Time // 带宽被占用到的时间
+	lastAt    time.Time // 最后一条消息的到达时间，保证消息按顺序到达
+}
+
+// simTimer 是虚拟时钟上的定时器，period 不为 0 时是 Ticker。
+// 触发过的一次性定时器保留到 Stop，以便 Drained 检查它的通道
+type simTimer struct {
+	sim    *Simulator
+	at     time.Time
+	period time.Duration
+	fired  bool
+	ch     chan time.Time
+}
+
+func (t *simTimer) C() <-chan time.Time { return t.ch }
+
+func (t *simTimer) Stop() {
+	t.sim.lock.Lock()
+	defer t.sim.lock.Unlock()
+	t.sim.removeTimer(t)
+}
+
+type simEvent struct {
+	at   time.Time
+	seq  uint64
+	from NetAddr
+	to   *LocalTransport
+	rpc  RPC
+}
+
+// NewSimulator 创建一个模拟网络，所有链路默认使用 defaultLink
+func NewSimulator(seed int64, defaultLink LinkConfig) *Simulator {
+	s := &Simulator{
+		net:         NewLocalNetwork(),
+		seed:        seed,
+		defaultLink: defaultLink,
+		now:         time.Unix(0, 0),
+		links:       make(map[[2]NetAddr]*simLink),
+		groups:      make(map[NetAddr]int),
+	}
+	s.net.sim = s
+	return s
+}
+
+// NewTransport 创建一个加入模拟网络的 Transport
+func (s *Simulator) NewTransport(addr NetAddr) *LocalTransport {
+	return s.net.NewTransport(addr)
+}
+
+// Now 返回虚拟时钟的当前时间
+func (s *Simulator) Now() time.Time {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.now
+}
+
+// NewTimer 在虚拟时间过去 d 之后触发一次
+func (s *Simulator) NewTimer(d time.Duration) Timer {
+	return s.addTimer(d, 0)
+}
+
+// NewTicker 每隔 d 的虚拟时间触发一次
+func (s *Simulator) NewTicker(d time.Duration) Timer {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	return s.addTimer(d, d)
+}
+
+// Drained 判断触发过的定时器是否都已经被接收，测试用它判断节点是否处理完了到期的定时器
+func (s *Simulator) Drained() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, t := range s.timers {
+		if len(t.ch) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Simulator) addTimer(d, period time.Duration) *simTimer {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	t := &simTimer{sim: s, at: s.now.Add(d), period: period, ch: make(chan time.Time, 1)}
+	s.timers = append(s.timers, t)
+	return t
+}
+
+// removeTimer 删除定时器，调用方需持有锁
+func (s *Simulator) removeTimer(t *simTimer) {
+	for i, other := range s.timers {
+		if other == t {
+			s.timers = append(s.timers[:i], s.timers[i+1:]...)
+			return
+		}
+	}
+}
+
+// nextTimer 返回最早到期的定时器，没有定时器时返回 nil，调用方需持有锁
+func (s *Simulator) nextTimer() *simTimer {
+	var next *simTimer
+	for _, t := range s.timers {
+		if !t.fired && (next == nil || t.at.Before(next.at)) {
+			next = t
+		}
+	}
+	return next
+}
+
+// fire 触发到期的定时器，Ticker 安排下一次触发，调用方需持有锁
+func (s *Simulator) fire(t *simTimer) {
+	select {
+	case t.ch <- t.at:
+	default:
+	}
+	if t.period > 0 {
+		t.at = t.at.Add(t.period)
+	} else {
+		t.fired = true
+	}
+}
+
+// SetLink 设置 a 和 b 之间两个方向上的链路条件
+func (s *Simulator) SetLink(a, b NetAddr, cfg LinkConfig) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.link(a, b).cfg = cfg
+	s.link(b, a).cfg = cfg
+}
+
+// Partition 把节点划分到互不连通的分区中，未列出的节点属于同一个分区。
+// 跨分区的消息被丢弃，包括已经在路上的消息，跨分区的拨号会失败
+func (s *Simulator) Partition(groups ...[]NetAddr) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.groups = make(map[NetAddr]int)
+	for i, group := range groups {
+		for _, addr := range group {
+			s.groups[addr] = i + 1
+		}
+	}
+}
+
+// Heal 取消全部分区
+func (s *Simulator) Heal() {
+	s.Partition()
+}
+
+// Pending 返回尚未投递的消息数
+func (s *Simulator) Pending() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.queue.Len()
+}
+
+// Advance 把虚拟时钟推进 d，按时间顺序投递期间到达的消息、触发期间到期的定时器，返回投递的消息数。
+// 消息和定时器同时到期时先触发定时器
+func (s *Simulator) Advance(d time.Duration) int {
+	s.lock.Lock()
+	target := s.now.Add(d)
+	s.lock.Unlock()
+
+	delivered := 0
+	for {
+		s.lock.Lock()
+		if t := s.nextTimer(); t != nil && !t.at.After(target) && (s.queue.Len() == 0 || !t.at.After(s.queue[0].at)) {
+			s.now = t.at
+			s.fire(t)
+			s.lock.Unlock()
+			continue
+		}
+		if s.queue.Len() == 0 || s.queue[0].at.After(target) {
+			s.now = target
+			s.lock.Unlock()
+			return delivered
+		}
+		ev := heap.Pop(&s.queue).(*simEvent)
+		s.now = ev.at
+		cut := s.isPartitioned(ev.from, ev.to.addr)
+		s.lock.Unlock()
+
+		if !cut && ev.to.deliver(ev.rpc) == nil {
+			delivered++
+		}
+	}
+}
+
+func (s *Simulator) partitioned(a, b NetAddr) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.isPartitioned(a, b)
+}
+
+func (s *Simulator) isPartitioned(a, b NetAddr) bool {
+	return s.groups[a] != s.groups[b]
+}
+
+// send 按链路条件安排一条消息的投递，被丢弃的消息发送方不会察觉
+func (s *Simulator) send(to *LocalTransport, rpc RPC) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	from := rpc.From
+	if s.isPartitioned(from, to.addr) {
+		return
+	}
+	l := s.link(from, to.addr)
+	if l.cfg.DropRate > 0 && l.rng.Float64() < l.cfg.DropRate {
+		return
+	}
+
+	start := s.now
+	if l.busyUntil.After(start) {
+		start = l.busyUntil
+	}
+	if l.cfg.Bandwidth > 0 {
+		size := messageHeadSize + len(rpc.Message.Data)
+		start = start.Add(time.Duration(int64(size) * int64(time.Second) / int64(l.cfg.Bandwidth)))
+		l.busyUntil = start
+	}
+	delay := l.cfg.Delay
+	if l.cfg.Jitter > 0 {
+		delay += time.Duration(l.rng.Int63n(int64(2*l.cfg.Jitter)+1)) - l.cfg.Jitter
+	}
+	at := start.Add(max(delay, 0))
+	if at.Before(l.lastAt) {
+		at = l.lastAt
+	}
+	l.lastAt = at
+
+	s.seq++
+	heap.Push(&s.queue, &simEvent{at: at, seq: s.seq, from: from, to: to, rpc: rpc})
+}
+
+// link 返回从 from 到 to 的链路，调用方需持有锁
+func (s *Simulator) link(from, to NetAddr) *simLink {
+	key := [2]NetAddr{from, to}
+	l, ok := s.links[key]
+	if !ok {
+		h := fnv.New64a()
+		h.Write([]byte(from))
+		h.Write([]byte{0})
+		h.Write([]byte(to))
+		l = &simLink{cfg: s.defaultLink, rng: rand.New(rand.NewSource(s.seed ^ int64(h.Sum64())))}
+		s.links[key] = l
+	}
+	return l
+}
+
+// simQueue 是按到达时间排序的事件队列，同时到达的事件按发送顺序排列
+type simQueue []*simEvent
+
+func (q simQueue) Len() int { return len(q) }
+func (q simQueue) Less(i, j int) bool {
+	if !q[i].at.Equal(q[j].at) {
+		return q[i].at.Before(q[j].at)
+	}
+	return q[i].seq < q[j].seq
+}
+func (q simQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *simQueue) Push(x any)   { *q = append(*q, x.(*simEvent)) }
+func (q *simQueue) Pop() any {
+	old := *q
+	ev := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return ev
+}
@@ -0,0 +1,107 @@
+package network
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// simTrace 在模拟网络中从 a 向 b 发送 n 条消息，返回每条消息到达时的虚拟时间，丢弃的消息为零值
+func simTrace(t *testing.T, seed int64, cfg LinkConfig, n int) []time.Time {
+	sim := NewSimulator(seed, cfg)
+	a := sim.NewTransport("a")
+	b := sim.NewTransport("b")
+	assert.Nil(t, a.Dial("b"))
+
+	arrivals := make([]time.Time, n)
+	for i := 0; i < n; i++ {
+		assert.Nil(t, a.SendMessage("b", MessageTypeGetBlocks, &GetBlocksMessage{From: uint32(i)}))
+	}
+	for sim.Pending() > 0 {
+		sim.Advance(time.Millisecond)
+		for len(b.Consume()) > 0 {
+			rpc := <-b.Consume()
+			msg := new(GetBlocksMessage)
+			assert.Nil(t, rpc.Codec.Unmarshal(rpc.Message.Data, msg))
+			arrivals[msg.From] = sim.Now()
+		}
+	}
+	return arrivals
+}
+
+func TestSimulatorDeterministic(t *testing.T) {
+	cfg := LinkConfig{Delay: 50 * time.Millisecond, Jitter: 20 * time.Millisecond, DropRate: 0.3}
+	first := simTrace(t, 42, cfg, 50)
+	assert.Equal(t, first, simTrace(t, 42, cfg, 50))
+	assert.NotEqual(t, first, simTrace(t, 43, cfg, 50))
+
+	dropped := 0
+	var last time.Time
+	for _, at := range first {
+		if at.IsZero() {
+			dropped++
+			continue
+		}
+		// 延迟在抖动范围内，消息按发送顺序到达
+		assert.False(t, at.Before(time.Unix(0, 0).Add(30*time.Millisecond)))
+		assert.False(t, at.After(time.Unix(0, 0).Add(71*time.Millisecond)))
+		assert.False(t, at.Before(last))
+		last = at
+	}
+	assert.True(t, dropped > 0 && dropped < 50)
+}
+
+func TestSimulatorBandwidth(t *testing.T) {
+	// 每条消息 18 字节，每秒 1800 字节，每条占用链路 10ms
+	arrivals := simTrace(t, 1, LinkConfig{Delay: 5 * time.Millisecond, Bandwidth: 1800}, 3)
+	for i, at := range arrivals {
+		assert.Equal(t, time.Unix(0, 0).Add(time.Duration(i+1)*10*time.Millisecond+5*time.Millisecond), at)
+	}
+}
+
+func TestSimulatorPartition(t *testing.T) {
+	sim := NewSimulator(1, LinkConfig{Delay: 10 * time.Millisecond})
+	a := sim.NewTransport("a")
+	b := sim.NewTransport("b")
+	c := sim.NewTransport("c")
+	assert.Nil(t, a.Dial("b"))
+
+	// 已经在路上的消息同样被丢弃
+	assert.Nil(t, a.SendMessage("b", MessageTypeGetStatus, new(GetStatusMessage)))
+	sim.Partition([]NetAddr{"a"}, []NetAddr{"b", "c"})
+	assert.Nil(t, a.SendMessage("b", MessageTypeGetStatus, new(GetStatusMessage)))
+	assert.Equal(t, 0, sim.Advance(time.Second))
+	assert.NotNil(t, c.Dial("a"))
+	assert.Nil(t, c.Dial("b"))
+
+	sim.Heal()
+	assert.Nil(t, a.SendMessage("b", MessageTypeGetStatus, new(GetStatusMessage)))
+	assert.Equal(t, 1, sim.Advance(time.Second))
+	assert.Equal(t, NetAddr("a"), (<-b.Consume()).From)
+}
+
+func TestSimulatorTimers(t *testing.T) {
+	sim := NewSimulator(1, LinkConfig{})
+	start := sim.Now()
+	timer := sim.NewTimer(30 * time.Millisecond)
+	ticker := sim.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+
+	sim.Advance(10 * time.Millisecond)
+	assert.Equal(t, 0, len(timer.C()))
+	assert.Equal(t, 0, len(ticker.C()))
+	assert.True(t, sim.Drained())
+
+	sim.Advance(20 * time.Millisecond)
+	assert.Equal(t, start.Add(30*time.Millisecond), <-timer.C())
+	assert.False(t, sim.Drained())
+	assert.Equal(t, start.Add(20*time.Millisecond), <-ticker.C())
+	assert.True(t, sim.Drained())
+
+	// 接收方来不及接收时 Ticker 丢弃多余的触发，停止后的定时器不再触发
+	timer.Stop()
+	sim.Advance(100 * time.Millisecond)
+	assert.Equal(t, start.Add(40*time.Millisecond), <-ticker.C())
+	assert.Equal(t, 0, len(timer.C()))
+	assert.True(t, sim.Drained())
+}
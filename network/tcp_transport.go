@@ -1,45 +1,249 @@
 package network
 
 import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
 	"fmt"
 	"github.com/sirupsen/logrus"
 	"github.com/virtue186/xchain/core"
+	"github.com/virtue186/xchain/crypto"
 	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// DefaultCodecs 是默认按优先级排列的编解码器
+var DefaultCodecs = []string{core.CodecBinary, core.CodecJSON}
+
+const (
+	// handshakeTimeout 是完成握手的最长时间
+	handshakeTimeout = 10 * time.Second
+	// dialTimeout 是建立 TCP 连接的最长时间
+	dialTimeout = 5 * time.Second
+	// writeTimeout 是写出一个帧的最长时间，超时的节点会被断开
+	writeTimeout = 10 * time.Second
+	// maxMissedPongs 是连续未收到心跳响应的次数上限，超过后断开该节点
+	maxMissedPongs = 3
+)
+
+// 连接数和每个节点入站队列的默认上限
+const (
+	DefaultMaxInbound    = 32
+	DefaultMaxOutbound   = 16
+	DefaultMaxConnsPerIP = 8
+	DefaultPeerQueueSize = 128
+	DefaultSendQueueSize = 256
+	DefaultPingInterval  = 15 * time.Second
+)
+
+var (
+	ErrPeerQueueFull = errors.New("peer inbound queue full")
+	ErrSendQueueFull = errors.New("peer send queue full")
+	ErrPeerClosed    = errors.New("peer closed")
+)
+
+// ProtocolVersion 是当前的 P2P 协议版本，版本不同的节点不会建立连接。
+// 版本 2 在加密帧中加入了请求 ID
+const ProtocolVersion uint32 = 2
+
 // TCPPeer 代表一个通过 TCP 连接的远端节点。
 type TCPPeer struct {
-	conn     net.Conn
-	outbound bool
+	conn         net.Conn
+	outbound     bool
+	maxFrameSize int
+	secure       *secureConn // 认证完成后的加密通道
+	id           NetAddr     // 经过验证的节点 ID
+	codec        core.Codec  // 握手时协商出的编解码器
+	info         *PeerInfo   // 对方在握手时通告的信息
+
+	// 握手完成后所有消息都经 sendCh 交给写协程依次写出，帧不会交错，慢节点也不会阻塞发送方
+	sendCh    chan *Message
+	closeCh   chan struct{}
+	closeOnce sync.Once
+
+	// inbox 缓存已读取但尚未交给 Transport 消费者的消息，满了说明该节点发得太快
+	inbox   chan RPC
+	limiter *peerLimiter
+
+	rtt         atomic.Int64 // 平滑后的往返时间，单位纳秒
+	pingLock    sync.Mutex
+	pingNonce   uint64
+	pingSent    time.Time // 未收到响应的心跳的发送时间，收到响应后清零
+	missedPongs int
 }
 
-func (p *TCPPeer) Send(payload []byte) error {
-	_, err := p.conn.Write(payload)
-	return err
+// Send 用协商出的编解码器编码 payload 并放入发送队列
+func (p *TCPPeer) Send(msgType MessageType, payload any) error {
+	return p.SendWithID(0, false, msgType, payload)
+}
+
+// SendWithID 与 Send 相同，但消息带有请求 ID
+func (p *TCPPeer) SendWithID(id uint64, response bool, msgType MessageType, payload any) error {
+	data, err := p.codec.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	msg := NewMessage(msgType, data)
+	msg.ID = id
+	msg.Response = response
+	return p.enqueue(msg)
+}
+
+// enqueue 把消息放入发送队列，不会阻塞。队列满说明对方读得太慢，该节点会被断开
+func (p *TCPPeer) enqueue(msg *Message) error {
+	select {
+	case <-p.closeCh:
+		return ErrPeerClosed
+	default:
+	}
+	select {
+	case p.sendCh <- msg:
+		return nil
+	default:
+		p.Close()
+		return ErrSendQueueFull
+	}
+}
+
+// writeLoop 依次写出发送队列中的消息，写入出错或超时时关闭连接
+func (p *TCPPeer) writeLoop() {
+	for {
+		select {
+		case msg := <-p.sendCh:
+			p.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := p.writeMessage(msg); err != nil {
+				logrus.Infof("failed to write to peer %s (%s): %v", p.conn.RemoteAddr(), p.id, err)
+				p.Close()
+				return
+			}
+		case <-p.closeCh:
+			return
+		}
+	}
+}
+
+// writeMessage 直接写出一条消息，只在握手阶段和写协程中调用
+func (p *TCPPeer) writeMessage(msg *Message) error {
+	if p.secure == nil {
+		// 只有认证消息以明文发送
+		return WriteFrame(p.conn, msg, p.maxFrameSize)
+	}
+	return p.secure.WriteMessage(msg)
 }
 
-// RemoteAddr 实现了 Peer 接口，返回远端节点的地址
+// readMessage 读取下一条消息，认证完成后的消息都经过解密
+func (p *TCPPeer) readMessage() (*Message, error) {
+	if p.secure == nil {
+		return ReadFrame(p.conn, p.maxFrameSize)
+	}
+	return p.secure.ReadMessage()
+}
+
+// ID 返回经过身份认证的节点 ID，发送消息时以它作为地址
+func (p *TCPPeer) ID() NetAddr {
+	return p.id
+}
+
+// Info 返回对方在握手时通告的信息
+func (p *TCPPeer) Info() *PeerInfo {
+	return p.info
+}
+
+// IsOutbound 返回连接是否由本节点发起
+func (p *TCPPeer) IsOutbound() bool {
+	return p.outbound
+}
+
+// RTT 返回平滑后的心跳往返时间，尚未测量时为 0
+func (p *TCPPeer) RTT() time.Duration {
+	return time.Duration(p.rtt.Load())
+}
+
+// ping 发送下一次心跳，返回此前连续未收到响应的次数
+func (p *TCPPeer) ping() (int, error) {
+	p.pingLock.Lock()
+	if !p.pingSent.IsZero() {
+		p.missedPongs++
+	}
+	p.pingNonce++
+	p.pingSent = time.Now()
+	nonce, missed := p.pingNonce, p.missedPongs
+	p.pingLock.Unlock()
+
+	return missed, p.Send(MessageTypePing, &PingMessage{Nonce: nonce})
+}
+
+// handlePong 记录一次往返时间，过期的响应被忽略。RTT 按 1/8 的权重平滑
+func (p *TCPPeer) handlePong(nonce uint64, now time.Time) {
+	p.pingLock.Lock()
+	defer p.pingLock.Unlock()
+	if nonce != p.pingNonce || p.pingSent.IsZero() {
+		return
+	}
+	sample := int64(now.Sub(p.pingSent))
+	p.pingSent = time.Time{}
+	p.missedPongs = 0
+
+	if rtt := p.rtt.Load(); rtt != 0 {
+		sample = rtt + (sample-rtt)/8
+	}
+	p.rtt.Store(sample)
+}
+
+// Codec 返回与该节点协商出的编解码器
+func (p *TCPPeer) Codec() core.Codec {
+	return p.codec
+}
+
+// RemoteAddr 实现了 Peer 接口，返回远端节点的 TCP 地址
 func (p *TCPPeer) RemoteAddr() NetAddr {
 	return NetAddr(p.conn.RemoteAddr().String())
 }
 
-func NewTCPPeer(conn net.Conn, outbound bool) *TCPPeer {
+func NewTCPPeer(conn net.Conn, outbound bool, maxFrameSize int) *TCPPeer {
 	return &TCPPeer{
-		conn:     conn,
-		outbound: outbound,
+		conn:         conn,
+		outbound:     outbound,
+		maxFrameSize: maxFrameSize,
+		sendCh:       make(chan *Message, DefaultSendQueueSize),
+		closeCh:      make(chan struct{}),
 	}
 }
 
+// Close 关闭连接并停止写协程，可以重复调用
 func (p *TCPPeer) Close() error {
-	return p.conn.Close()
+	var err error
+	p.closeOnce.Do(func() {
+		close(p.closeCh)
+		err = p.conn.Close()
+	})
+	return err
 }
 
 // TCPTransportOpts 包含了创建 TCPTransport 所需的配置项。
 type TCPTransportOpts struct {
 	ListenAddr    string
 	HandshakeFunc HandshakeFunc
-	Decoder       core.Decoder[*Message]
+	MaxFrameSize  int      // 允许收发的最大帧长度，为 0 时使用 DefaultMaxFrameSize
+	Codecs        []string // 按优先级排列的编解码器名字，为空时使用 DefaultCodecs
+	// LocalInfo 返回本节点在握手时通告的信息，ProtocolVersion 和 NodeID 由 Transport 填写
+	LocalInfo func() PeerInfo
+	// Identity 是节点的身份私钥，节点 ID 由它派生，为空时使用一个临时身份
+	Identity *crypto.PrivateKey
+	// BanList 中的 IP 和节点 ID 会在握手前后被拒绝，为空时不做检查
+	BanList *BanList
+	// 入站、出站连接数和同一 IP 的连接数上限，握手中的连接也计算在内，为 0 时使用默认值
+	MaxInbound    int
+	MaxOutbound   int
+	MaxConnsPerIP int
+	// RateLimits 是每个节点按消息类型的限速，超出的消息被丢弃，为空时使用 DefaultRateLimits
+	RateLimits map[MessageType]RateLimit
+	// PeerQueueSize 是每个节点入站队列的长度，队列满时断开该节点，为 0 时使用 DefaultPeerQueueSize
+	PeerQueueSize int
+	// PingInterval 是发送心跳的间隔，为 0 时使用 DefaultPingInterval
+	PingInterval time.Duration
 }
 
 // TCPTransport 实现了 Transport 接口，用于处理TCP网络通信。
@@ -49,16 +253,54 @@ type TCPTransport struct {
 	rpcCh    chan RPC
 	peerCh   chan Peer // 用于广播新连接的 Peer
 
-	lock  sync.RWMutex
-	peers map[NetAddr]*TCPPeer
+	lock   sync.RWMutex
+	peers  map[NetAddr]*TCPPeer
+	closed bool // Close 之后不再接受新的节点
+
+	// 已占用的连接名额，包括正在握手的连接
+	slotLock          sync.Mutex
+	inbound, outbound int
+	connsPerIP        map[string]int
 }
 
 func NewTCPTransport(opts TCPTransportOpts) *TCPTransport {
+	if opts.MaxFrameSize == 0 {
+		opts.MaxFrameSize = DefaultMaxFrameSize
+	}
+	if len(opts.Codecs) == 0 {
+		opts.Codecs = DefaultCodecs
+	}
+	if opts.LocalInfo == nil {
+		opts.LocalInfo = func() PeerInfo { return PeerInfo{} }
+	}
+	if opts.Identity == nil {
+		key := crypto.GeneratePrivateKey()
+		opts.Identity = &key
+	}
+	if opts.MaxInbound == 0 {
+		opts.MaxInbound = DefaultMaxInbound
+	}
+	if opts.MaxOutbound == 0 {
+		opts.MaxOutbound = DefaultMaxOutbound
+	}
+	if opts.MaxConnsPerIP == 0 {
+		opts.MaxConnsPerIP = DefaultMaxConnsPerIP
+	}
+	if opts.RateLimits == nil {
+		opts.RateLimits = DefaultRateLimits
+	}
+	if opts.PeerQueueSize == 0 {
+		opts.PeerQueueSize = DefaultPeerQueueSize
+	}
+	if opts.PingInterval == 0 {
+		opts.PingInterval = DefaultPingInterval
+	}
 	return &TCPTransport{
 		TCPTransportOpts: opts,
-		rpcCh:            make(chan RPC, 1024),
+		rpcCh:            make(chan RPC),      // 消息缓存在每个节点的入站队列中，共享通道不缓存，各节点轮流交付
 		peerCh:           make(chan Peer, 10), // 确保通道被初始化
 		peers:            make(map[NetAddr]*TCPPeer),
+		connsPerIP:       make(map[string]int),
 	}
 }
 
@@ -71,11 +313,28 @@ func (t *TCPTransport) Consume() <-chan RPC {
 	return t.rpcCh
 }
 
+// Close 停止监听并断开全部节点
 func (t *TCPTransport) Close() error {
+	t.lock.Lock()
+	t.closed = true
+	peers := t.peers
+	t.peers = make(map[NetAddr]*TCPPeer)
+	t.lock.Unlock()
+
+	for _, peer := range peers {
+		peer.Close()
+	}
+	if t.listener == nil {
+		return nil
+	}
 	return t.listener.Close()
 }
 
-func (t *TCPTransport) SendMessage(to NetAddr, payload []byte) error {
+func (t *TCPTransport) SendMessage(to NetAddr, msgType MessageType, payload any) error {
+	return t.SendWithID(to, 0, false, msgType, payload)
+}
+
+func (t *TCPTransport) SendWithID(to NetAddr, id uint64, response bool, msgType MessageType, payload any) error {
 	t.lock.RLock()
 	defer t.lock.RUnlock()
 
@@ -84,21 +343,126 @@ func (t *TCPTransport) SendMessage(to NetAddr, payload []byte) error {
 		return fmt.Errorf("%s: could not find peer %s", t.ListenAddr, to)
 	}
 
-	_, err := peer.conn.Write(payload)
-	return err
+	return peer.SendWithID(id, response, msgType, payload)
 }
 
 func (t *TCPTransport) Addr() NetAddr {
 	return NetAddr(t.ListenAddr)
 }
 
+// Peers 返回当前已完成握手的全部节点
+func (t *TCPTransport) Peers() []Peer {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	peers := make([]Peer, 0, len(t.peers))
+	for _, p := range t.peers {
+		peers = append(peers, p)
+	}
+	return peers
+}
+
+// Disconnect 断开与节点 id 的连接
+func (t *TCPTransport) Disconnect(id NetAddr) error {
+	t.lock.RLock()
+	peer, ok := t.peers[id]
+	t.lock.RUnlock()
+	if !ok {
+		return fmt.Errorf("%s: could not find peer %s", t.ListenAddr, id)
+	}
+	return peer.Close()
+}
+
+// isBanned 检查连接的远端 IP 是否被封禁
+func (t *TCPTransport) isBanned(addr net.Addr) bool {
+	if t.BanList == nil {
+		return false
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	return err == nil && t.BanList.IsIPBanned(host)
+}
+
+// acquireSlot 为一条新连接占用名额，超出连接数上限时返回错误
+func (t *TCPTransport) acquireSlot(addr net.Addr, outbound bool) error {
+	ip := hostOf(addr)
+
+	t.slotLock.Lock()
+	defer t.slotLock.Unlock()
+	if outbound && t.outbound >= t.MaxOutbound {
+		return fmt.Errorf("too many outbound connections (%d)", t.outbound)
+	}
+	if !outbound && t.inbound >= t.MaxInbound {
+		return fmt.Errorf("too many inbound connections (%d)", t.inbound)
+	}
+	if t.connsPerIP[ip] >= t.MaxConnsPerIP {
+		return fmt.Errorf("too many connections from %s (%d)", ip, t.connsPerIP[ip])
+	}
+	if outbound {
+		t.outbound++
+	} else {
+		t.inbound++
+	}
+	t.connsPerIP[ip]++
+	return nil
+}
+
+// releaseSlot 在连接关闭后归还名额
+func (t *TCPTransport) releaseSlot(addr net.Addr, outbound bool) {
+	ip := hostOf(addr)
+
+	t.slotLock.Lock()
+	defer t.slotLock.Unlock()
+	if outbound {
+		t.outbound--
+	} else {
+		t.inbound--
+	}
+	if t.connsPerIP[ip]--; t.connsPerIP[ip] <= 0 {
+		delete(t.connsPerIP, ip)
+	}
+}
+
+func hostOf(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// listenAddr 返回通告给其他节点的监听地址，未监听时为空
+func (t *TCPTransport) listenAddr() string {
+	if t.listener == nil {
+		return ""
+	}
+	return t.listener.Addr().String()
+}
+
+// ID 返回本节点的节点 ID
+func (t *TCPTransport) ID() NetAddr {
+	return NodeIDFromPublicKey(t.Identity.PublicKey())
+}
+
+// Dial 连接 addr 并完成握手，连接或握手失败时返回错误
 func (t *TCPTransport) Dial(addr string) error {
-	conn, err := net.Dial("tcp", addr)
+	conn, err := net.DialTimeout("tcp", addr, dialTimeout)
 	if err != nil {
 		return err
 	}
+	if t.isBanned(conn.RemoteAddr()) {
+		conn.Close()
+		return fmt.Errorf("%s is banned", addr)
+	}
+	if err := t.acquireSlot(conn.RemoteAddr(), true); err != nil {
+		conn.Close()
+		return err
+	}
 
-	go t.handleConn(conn, true)
+	peer, err := t.setupConn(conn, true)
+	if err != nil {
+		return err
+	}
+	go t.readLoop(peer)
 	return nil
 }
 
@@ -115,12 +479,23 @@ func (t *TCPTransport) ListenAndAccept() error {
 	return nil
 }
 
-func (t *TCPTransport) Broadcast(payload []byte) error {
+func (t *TCPTransport) Broadcast(msgType MessageType, payload any) error {
 	t.lock.RLock()
 	defer t.lock.RUnlock()
 
+	// 不同节点可能协商出不同的编解码器，每种编解码器只编码一次
+	encoded := make(map[string]*Message)
 	for _, peer := range t.peers {
-		if _, err := peer.conn.Write(payload); err != nil {
+		msg, ok := encoded[peer.codec.Name()]
+		if !ok {
+			data, err := peer.codec.Marshal(payload)
+			if err != nil {
+				return err
+			}
+			msg = NewMessage(msgType, data)
+			encoded[peer.codec.Name()] = msg
+		}
+		if err := peer.enqueue(msg); err != nil {
 			logrus.Errorf("failed to broadcast to peer %s: %v", peer.conn.RemoteAddr(), err)
 		}
 	}
@@ -130,54 +505,308 @@ func (t *TCPTransport) Broadcast(payload []byte) error {
 func (t *TCPTransport) startAcceptLoop() {
 	for {
 		conn, err := t.listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
 		if err != nil {
 			logrus.Errorf("TCP accept error: %s\n", err)
 			continue
 		}
-		go t.handleConn(conn, false)
+		if t.isBanned(conn.RemoteAddr()) {
+			logrus.Infof("rejecting connection from banned address %s", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+		if err := t.acquireSlot(conn.RemoteAddr(), false); err != nil {
+			logrus.Infof("rejecting connection from %s: %v", conn.RemoteAddr(), err)
+			conn.Close()
+			continue
+		}
+		go func() {
+			if peer, err := t.setupConn(conn, false); err == nil {
+				t.readLoop(peer)
+			}
+		}()
 	}
 }
 
-func (t *TCPTransport) handleConn(conn net.Conn, outbound bool) {
-	var err error
-	peer := NewTCPPeer(conn, outbound)
-	addr := NetAddr(conn.RemoteAddr().String())
+// setupConn 在新连接上完成握手，并将该节点添加到通讯录，失败时关闭连接并归还名额
+func (t *TCPTransport) setupConn(conn net.Conn, outbound bool) (*TCPPeer, error) {
+	peer := NewTCPPeer(conn, outbound, t.MaxFrameSize)
+	peer.inbox = make(chan RPC, t.PeerQueueSize)
+	peer.limiter = newPeerLimiter(t.RateLimits)
 
-	defer func() {
-		logrus.Infof("dropping peer connection: %s, reason: %v", addr, err)
-		t.removePeer(addr)
-		conn.Close()
-	}()
-
-	if err = t.HandshakeFunc(peer); err != nil {
-		return
+	err := t.handshake(peer)
+	if err == nil {
+		err = t.HandshakeFunc(peer)
+	}
+	if err == nil {
+		// 握手成功后，启动写协程，将该节点添加到通讯录并发出事件
+		go peer.writeLoop()
+		err = t.addPeer(peer)
+	}
+	if err != nil {
+		logrus.Infof("dropping peer connection: %s (%s), reason: %v", conn.RemoteAddr(), peer.id, err)
+		peer.Close()
+		t.releaseSlot(conn.RemoteAddr(), outbound)
+		return nil, err
 	}
+	return peer, nil
+}
+
+// readLoop 读取节点发来的消息放入该节点的入站队列，直到连接出错。
+// 超出限速的消息被丢弃，队列满时断开该节点，不会因为一个节点阻塞其他节点的消息
+func (t *TCPTransport) readLoop(peer *TCPPeer) {
+	go t.forward(peer)
+	go t.keepalive(peer)
 
-	// 握手成功后，将该节点添加到通讯录并发出事件
-	t.addPeer(peer)
+	var err error
+	defer func() {
+		logrus.Infof("dropping peer connection: %s (%s), reason: %v", peer.conn.RemoteAddr(), peer.id, err)
+		t.removePeer(peer)
+		peer.Close()
+		close(peer.inbox)
+		t.releaseSlot(peer.conn.RemoteAddr(), peer.outbound)
+	}()
 
 	for {
-		msg := new(Message)
-		err = t.Decoder.Decode(conn, msg)
+		// 过大、格式错误或认证失败的帧会导致连接被断开
+		var msg *Message
+		msg, err = peer.readMessage()
 		if err != nil {
 			return
 		}
 
+		if !peer.limiter.allow(msg.Header, time.Now()) {
+			logrus.Debugf("rate limit exceeded by peer %s, dropping message type %d", peer.id, msg.Header)
+			continue
+		}
+		if msg.Header == MessageTypePing || msg.Header == MessageTypePong {
+			if err = t.handleKeepalive(peer, msg); err != nil {
+				return
+			}
+			continue
+		}
+
 		rpc := RPC{
-			From:    addr,
+			From:    peer.id,
 			Message: msg,
+			Codec:   peer.codec,
+		}
+		select {
+		case peer.inbox <- rpc:
+		default:
+			err = ErrPeerQueueFull
+			return
 		}
-		t.rpcCh <- rpc
 	}
 }
 
-// addPeer 是一个线程安全的函数，用于添加一个新的对等节点到通讯录
-// 这是关键的修改点！
-func (t *TCPTransport) addPeer(peer *TCPPeer) {
+// keepalive 定期向节点发送心跳，连续多次未收到响应时断开该节点
+func (t *TCPTransport) keepalive(peer *TCPPeer) {
+	ticker := time.NewTicker(t.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			missed, err := peer.ping()
+			if missed > maxMissedPongs {
+				logrus.Infof("peer %s (%s) missed %d pongs, disconnecting", peer.conn.RemoteAddr(), peer.id, missed)
+				peer.Close()
+				return
+			}
+			if err != nil {
+				return
+			}
+		case <-peer.closeCh:
+			return
+		}
+	}
+}
+
+// handleKeepalive 回复心跳或记录心跳响应，无法解码时返回错误并断开连接
+func (t *TCPTransport) handleKeepalive(peer *TCPPeer, msg *Message) error {
+	if msg.Header == MessageTypePing {
+		ping := new(PingMessage)
+		if err := peer.codec.Unmarshal(msg.Data, ping); err != nil {
+			return fmt.Errorf("decode ping: %w", err)
+		}
+		return peer.Send(MessageTypePong, &PongMessage{Nonce: ping.Nonce})
+	}
+	pong := new(PongMessage)
+	if err := peer.codec.Unmarshal(msg.Data, pong); err != nil {
+		return fmt.Errorf("decode pong: %w", err)
+	}
+	peer.handlePong(pong.Nonce, time.Now())
+	return nil
+}
+
+// forward 把节点入站队列中的消息依次交给 Transport 的消费者。rpcCh 不带缓存，每个节点同时最多有一条消息在等待交付
+func (t *TCPTransport) forward(peer *TCPPeer) {
+	for rpc := range peer.inbox {
+		select {
+		case t.rpcCh <- rpc:
+		case <-peer.closeCh:
+			return
+		}
+	}
+}
+
+// handshake 先认证对方身份并建立加密通道，再交换节点信息和支持的编解码器，对方不在同一条链上时返回错误。
+// 握手消息固定使用 JSON 编码。编解码器以发起连接一方的优先级为准，双方因此总是选出同一个
+func (t *TCPTransport) handshake(peer *TCPPeer) error {
+	peer.conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer peer.conn.SetDeadline(time.Time{})
+
+	if err := t.authenticate(peer); err != nil {
+		return fmt.Errorf("authenticate: %w", err)
+	}
+
+	local := &HandshakeMessage{PeerInfo: t.LocalInfo(), Codecs: t.Codecs}
+	local.ProtocolVersion = ProtocolVersion
+	local.NodeID = string(t.ID())
+	local.ListenAddr = t.listenAddr()
+
+	bootstrap := core.JSONCodec{}
+	data, err := bootstrap.Marshal(local)
+	if err != nil {
+		return err
+	}
+	if err := peer.writeMessage(NewMessage(MessageTypeHandshake, data)); err != nil {
+		return err
+	}
+
+	msg, err := peer.readMessage()
+	if err != nil {
+		return err
+	}
+	if msg.Header != MessageTypeHandshake {
+		return fmt.Errorf("expected handshake, got message type %d", msg.Header)
+	}
+	remote := new(HandshakeMessage)
+	if err := bootstrap.Unmarshal(msg.Data, remote); err != nil {
+		return fmt.Errorf("decode handshake: %w", err)
+	}
+	if NetAddr(remote.NodeID) != peer.id {
+		return fmt.Errorf("node id %s does not match identity %s", remote.NodeID, peer.id)
+	}
+	if err := checkPeerInfo(&local.PeerInfo, &remote.PeerInfo); err != nil {
+		return err
+	}
+	remote.ListenAddr = resolveListenAddr(remote.ListenAddr, peer.conn.RemoteAddr())
+	peer.info = &remote.PeerInfo
+
+	preferred, other := t.Codecs, remote.Codecs
+	if !peer.outbound {
+		preferred, other = other, preferred
+	}
+	name, err := selectCodec(preferred, other)
+	if err != nil {
+		return err
+	}
+	peer.codec, err = core.LookupCodec(name)
+	return err
+}
+
+// authenticate 交换认证消息，验证对方的身份并建立加密通道
+func (t *TCPTransport) authenticate(peer *TCPPeer) error {
+	ephemeral, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	local, err := newAuthMessage(*t.Identity, ephemeral)
+	if err != nil {
+		return err
+	}
+	if err := peer.writeMessage(NewMessage(MessageTypeAuth, local.encode())); err != nil {
+		return err
+	}
+
+	msg, err := peer.readMessage()
+	if err != nil {
+		return err
+	}
+	if msg.Header != MessageTypeAuth {
+		return fmt.Errorf("expected auth message, got message type %d", msg.Header)
+	}
+	remote, err := decodeAuthMessage(msg.Data)
+	if err != nil {
+		return err
+	}
+	peer.id = NodeIDFromPublicKey(remote.Identity)
+	if peer.id == t.ID() {
+		return fmt.Errorf("connected to self")
+	}
+	if t.BanList != nil && t.BanList.IsNodeBanned(peer.id) {
+		return fmt.Errorf("node %s is banned", peer.id)
+	}
+
+	peer.secure, err = newSecureConn(peer.conn, t.MaxFrameSize, ephemeral, remote.Ephemeral, peer.outbound)
+	return err
+}
+
+// checkPeerInfo 检查对方是否与本节点使用相同的协议并位于同一条链上
+func checkPeerInfo(local, remote *PeerInfo) error {
+	if remote.ProtocolVersion != local.ProtocolVersion {
+		return fmt.Errorf("protocol version mismatch: peer %d, local %d", remote.ProtocolVersion, local.ProtocolVersion)
+	}
+	if remote.ChainID != local.ChainID {
+		return fmt.Errorf("chain id mismatch: peer %q, local %q", remote.ChainID, local.ChainID)
+	}
+	if remote.GenesisHash != local.GenesisHash {
+		return fmt.Errorf("genesis hash mismatch: peer %s, local %s", remote.GenesisHash, local.GenesisHash)
+	}
+	if local.NodeID != "" && remote.NodeID == local.NodeID {
+		return fmt.Errorf("connected to self")
+	}
+	return nil
+}
+
+// resolveListenAddr 把对方通告的未指定主机（如 ":3000" 或 "0.0.0.0:3000"）替换为连接的远端 IP
+func resolveListenAddr(advertised string, remote net.Addr) string {
+	host, port, err := net.SplitHostPort(advertised)
+	if err != nil {
+		return ""
+	}
+	if ip := net.ParseIP(host); host != "" && (ip == nil || !ip.IsUnspecified()) {
+		return advertised
+	}
+	tcpAddr, ok := remote.(*net.TCPAddr)
+	if !ok {
+		return ""
+	}
+	return net.JoinHostPort(tcpAddr.IP.String(), port)
+}
+
+// selectCodec 返回 preferred 中第一个同时出现在 other 中且已注册的编解码器
+func selectCodec(preferred, other []string) (string, error) {
+	for _, name := range preferred {
+		if _, err := core.LookupCodec(name); err != nil {
+			continue
+		}
+		for _, o := range other {
+			if o == name {
+				return name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no common codec between %v and %v", preferred, other)
+}
+
+// addPeer 是一个线程安全的函数，用于添加一个新的对等节点到通讯录。
+// 通讯录以节点 ID 为键，同一个节点只保留先建立的连接
+func (t *TCPTransport) addPeer(peer *TCPPeer) error {
 	t.lock.Lock()
 	defer t.lock.Unlock()
 
-	addr := NetAddr(peer.conn.RemoteAddr().String())
+	addr := peer.id
+	if t.closed {
+		return fmt.Errorf("transport closed")
+	}
+	if _, ok := t.peers[addr]; ok {
+		return fmt.Errorf("already connected to %s", addr)
+	}
 	t.peers[addr] = peer
 
 	// 将新建立的 Peer 发送到事件通道
@@ -188,11 +817,15 @@ func (t *TCPTransport) addPeer(peer *TCPPeer) {
 	default:
 		logrus.Warnf("peer channel is full, dropping peer event for %s", addr)
 	}
+	return nil
 }
 
-// removePeer 是一个线程安全的函数，用于从通讯录中移除一个对等节点
-func (t *TCPTransport) removePeer(addr NetAddr) {
+// removePeer 是一个线程安全的函数，用于从通讯录中移除一个对等节点。
+// 只移除 peer 本身，不会误删同一节点 ID 的另一条连接
+func (t *TCPTransport) removePeer(peer *TCPPeer) {
 	t.lock.Lock()
 	defer t.lock.Unlock()
-	delete(t.peers, addr)
+	if t.peers[peer.id] == peer {
+		delete(t.peers, peer.id)
+	}
 }
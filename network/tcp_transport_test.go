@@ -0,0 +1,224 @@
+package network
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/virtue186/xchain/core"
+	"github.com/virtue186/xchain/types"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSelectCodec(t *testing.T) {
+	name, err := selectCodec([]string{"binary", "json"}, []string{"json", "binary"})
+	assert.Nil(t, err)
+	assert.Equal(t, "binary", name)
+
+	name, err = selectCodec([]string{"fast", "binary", "json"}, []string{"json", "fast"})
+	assert.Nil(t, err)
+	assert.Equal(t, "json", name) // 未注册的编解码器会被跳过
+
+	_, err = selectCodec([]string{"binary"}, []string{"json"})
+	assert.NotNil(t, err)
+}
+
+func TestTCPTransportNegotiatesCodec(t *testing.T) {
+	a := NewTCPTransport(TCPTransportOpts{ListenAddr: "127.0.0.1:0", HandshakeFunc: NOPHandshakeFunc})
+	b := NewTCPTransport(TCPTransportOpts{
+		ListenAddr:    "127.0.0.1:0",
+		HandshakeFunc: NOPHandshakeFunc,
+		Codecs:        []string{core.CodecJSON},
+	})
+	assert.Nil(t, a.ListenAndAccept())
+	assert.Nil(t, b.ListenAndAccept())
+	defer a.Close()
+	defer b.Close()
+
+	// b 只支持 JSON，即使 a 更偏好 binary 也要退回 JSON
+	assert.Nil(t, a.Dial(b.listener.Addr().String()))
+	var peer Peer
+	select {
+	case peer = <-b.PeerEvents():
+	case <-time.After(time.Second):
+		t.Fatal("no peer connected")
+	}
+	assert.Equal(t, core.CodecJSON, peer.(*TCPPeer).Codec().Name())
+
+	assert.Nil(t, peer.Send(MessageTypeGetBlocks, &GetBlocksMessage{From: 3, To: 7}))
+	select {
+	case rpc := <-a.Consume():
+		assert.Equal(t, core.CodecJSON, rpc.Codec.Name())
+		msg := new(GetBlocksMessage)
+		assert.Nil(t, rpc.Codec.Unmarshal(rpc.Message.Data, msg))
+		assert.Equal(t, GetBlocksMessage{From: 3, To: 7}, *msg)
+	case <-time.After(time.Second):
+		t.Fatal("no message received")
+	}
+}
+
+func TestTCPTransportHandshakeChecksChain(t *testing.T) {
+	genesis := types.RandomHash()
+	newTransport := func(chainID string) *TCPTransport {
+		tr := NewTCPTransport(TCPTransportOpts{
+			ListenAddr:    "127.0.0.1:0",
+			HandshakeFunc: NOPHandshakeFunc,
+			LocalInfo: func() PeerInfo {
+				return PeerInfo{ChainID: chainID, GenesisHash: genesis, BestHeight: 5}
+			},
+		})
+		assert.Nil(t, tr.ListenAndAccept())
+		return tr
+	}
+	a := newTransport("main")
+	b := newTransport("main")
+	c := newTransport("test")
+	defer a.Close()
+	defer b.Close()
+	defer c.Close()
+
+	assert.Nil(t, a.Dial(b.listener.Addr().String()))
+	select {
+	case peer := <-b.PeerEvents():
+		assert.Equal(t, a.ID(), peer.ID())
+		assert.Equal(t, string(a.ID()), peer.Info().NodeID)
+		assert.Equal(t, ProtocolVersion, peer.Info().ProtocolVersion)
+		assert.Equal(t, uint32(5), peer.Info().BestHeight)
+	case <-time.After(time.Second):
+		t.Fatal("no peer connected")
+	}
+	select {
+	case peer := <-a.PeerEvents():
+		assert.Equal(t, b.ID(), peer.ID())
+	case <-time.After(time.Second):
+		t.Fatal("no peer connected")
+	}
+
+	// 不同链上的节点、自己以及已经连接的节点都会在握手时被拒绝
+	assert.NotNil(t, a.Dial(c.listener.Addr().String()))
+	assert.NotNil(t, a.Dial(a.listener.Addr().String()))
+	assert.NotNil(t, b.Dial(a.listener.Addr().String()))
+	select {
+	case peer := <-c.PeerEvents():
+		t.Fatalf("unexpected peer %s", peer.RemoteAddr())
+	case peer := <-a.PeerEvents():
+		t.Fatalf("unexpected peer %s", peer.RemoteAddr())
+	case peer := <-b.PeerEvents():
+		t.Fatalf("unexpected peer %s", peer.RemoteAddr())
+	case <-time.After(200 * time.Millisecond):
+	}
+	assert.Nil(t, a.SendMessage(b.ID(), MessageTypeGetStatus, new(GetStatusMessage)))
+}
+
+func TestCheckPeerInfo(t *testing.T) {
+	local := &PeerInfo{ProtocolVersion: 1, ChainID: "main", NodeID: "a"}
+	assert.Nil(t, checkPeerInfo(local, &PeerInfo{ProtocolVersion: 1, ChainID: "main", NodeID: "b"}))
+	assert.NotNil(t, checkPeerInfo(local, &PeerInfo{ProtocolVersion: 2, ChainID: "main", NodeID: "b"}))
+	assert.NotNil(t, checkPeerInfo(local, &PeerInfo{ProtocolVersion: 1, ChainID: "main", GenesisHash: types.Hash{1}, NodeID: "b"}))
+}
+
+func TestResolveListenAddr(t *testing.T) {
+	remote := &net.TCPAddr{IP: net.ParseIP("10.0.0.7"), Port: 51234}
+	assert.Equal(t, "127.0.0.1:3000", resolveListenAddr("127.0.0.1:3000", remote))
+	assert.Equal(t, "10.0.0.7:3000", resolveListenAddr(":3000", remote))
+	assert.Equal(t, "10.0.0.7:3000", resolveListenAddr("0.0.0.0:3000", remote))
+	assert.Equal(t, "", resolveListenAddr("", remote))
+}
+
+func TestTCPTransportConnectionLimits(t *testing.T) {
+	a := NewTCPTransport(TCPTransportOpts{ListenAddr: "127.0.0.1:0", HandshakeFunc: NOPHandshakeFunc, MaxInbound: 1})
+	assert.Nil(t, a.ListenAndAccept())
+	defer a.Close()
+	newClient := func() *TCPTransport {
+		return NewTCPTransport(TCPTransportOpts{HandshakeFunc: NOPHandshakeFunc})
+	}
+
+	b := newClient()
+	assert.Nil(t, b.Dial(a.listener.Addr().String()))
+	assert.NotNil(t, newClient().Dial(a.listener.Addr().String()))
+
+	// 连接断开后名额被归还
+	<-a.PeerEvents()
+	assert.Nil(t, b.Disconnect(a.ID()))
+	assert.Eventually(t, func() bool { return len(a.Peers()) == 0 }, time.Second, 10*time.Millisecond)
+	assert.Nil(t, newClient().Dial(a.listener.Addr().String()))
+}
+
+func TestTCPTransportPeerLimits(t *testing.T) {
+	a := NewTCPTransport(TCPTransportOpts{
+		ListenAddr:    "127.0.0.1:0",
+		HandshakeFunc: NOPHandshakeFunc,
+		RateLimits:    map[MessageType]RateLimit{MessageTypeGetPeers: {Rate: 0.001, Burst: 1}},
+		PeerQueueSize: 2,
+	})
+	b := NewTCPTransport(TCPTransportOpts{HandshakeFunc: NOPHandshakeFunc})
+	assert.Nil(t, a.ListenAndAccept())
+	defer a.Close()
+	assert.Nil(t, b.Dial(a.listener.Addr().String()))
+
+	// 超出限速的消息被丢弃
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, b.SendMessage(a.ID(), MessageTypeGetPeers, new(GetPeersMessage)))
+	}
+	assert.Nil(t, b.SendMessage(a.ID(), MessageTypeGetStatus, new(GetStatusMessage)))
+	assert.Equal(t, MessageTypeGetPeers, (<-a.Consume()).Message.Header)
+	assert.Equal(t, MessageTypeGetStatus, (<-a.Consume()).Message.Header)
+
+	// 不消费消息时入站队列被填满，该节点被断开
+	for i := 0; i < 10; i++ {
+		b.SendMessage(a.ID(), MessageTypeGetStatus, new(GetStatusMessage))
+	}
+	assert.Eventually(t, func() bool { return len(a.Peers()) == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestTCPPeerSendQueue(t *testing.T) {
+	// 对方从不读取，写协程阻塞在第一条消息上
+	conn, _ := net.Pipe()
+	peer := NewTCPPeer(conn, true, DefaultMaxFrameSize)
+	peer.codec = core.JSONCodec{}
+	go peer.writeLoop()
+
+	var err error
+	for i := 0; i < DefaultSendQueueSize+2 && err == nil; i++ {
+		err = peer.Send(MessageTypeGetStatus, new(GetStatusMessage))
+	}
+	assert.ErrorIs(t, err, ErrSendQueueFull)
+	assert.ErrorIs(t, peer.Send(MessageTypeGetStatus, new(GetStatusMessage)), ErrPeerClosed)
+}
+
+func TestTCPTransportKeepalive(t *testing.T) {
+	a := NewTCPTransport(TCPTransportOpts{ListenAddr: "127.0.0.1:0", HandshakeFunc: NOPHandshakeFunc, PingInterval: 20 * time.Millisecond})
+	b := NewTCPTransport(TCPTransportOpts{HandshakeFunc: NOPHandshakeFunc, PingInterval: time.Hour})
+	// c 丢弃所有心跳，相当于一个失去响应的节点
+	c := NewTCPTransport(TCPTransportOpts{
+		HandshakeFunc: NOPHandshakeFunc,
+		PingInterval:  time.Hour,
+		RateLimits:    map[MessageType]RateLimit{MessageTypePing: {}},
+	})
+	assert.Nil(t, a.ListenAndAccept())
+	defer a.Close()
+	assert.Nil(t, b.Dial(a.listener.Addr().String()))
+	assert.Nil(t, c.Dial(a.listener.Addr().String()))
+
+	assert.Eventually(t, func() bool {
+		peers := a.Peers()
+		return len(peers) == 1 && peers[0].ID() == b.ID() && peers[0].RTT() > 0
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestTCPPeerHandlePong(t *testing.T) {
+	peer := NewTCPPeer(nil, true, DefaultMaxFrameSize)
+	now := time.Now()
+	peer.pingNonce, peer.pingSent, peer.missedPongs = 1, now, 2
+
+	// 过期的响应不计入
+	peer.handlePong(2, now.Add(time.Second))
+	assert.Equal(t, time.Duration(0), peer.RTT())
+
+	peer.handlePong(1, now.Add(80*time.Millisecond))
+	assert.Equal(t, 80*time.Millisecond, peer.RTT())
+	assert.Equal(t, 0, peer.missedPongs)
+
+	peer.pingNonce, peer.pingSent = 2, now
+	peer.handlePong(2, now.Add(160*time.Millisecond))
+	assert.Equal(t, 90*time.Millisecond, peer.RTT())
+}
@@ -1,22 +1,35 @@
 package network
 
-import "io"
+import (
+	"io"
+	"time"
+)
 
 type NetAddr string
 
 // Peer 是对一个网络对等节点的通用接口
 type Peer interface {
-	io.Closer          // Peer应该可以被关闭
-	Send([]byte) error // Peer应该可以发送数据
-	RemoteAddr() NetAddr
+	io.Closer                    // Peer应该可以被关闭
+	Send(MessageType, any) error // 用与该 Peer 协商出的编解码器编码并发送消息
+	// SendWithID 发送带请求 ID 的消息，response 表示它是对该 ID 的回复
+	SendWithID(id uint64, response bool, msgType MessageType, payload any) error
+	ID() NetAddr         // 经过身份认证的节点 ID，作为 SendMessage 的地址
+	RemoteAddr() NetAddr // 对方的网络地址
+	Info() *PeerInfo     // 对方在握手时通告的信息
+	IsOutbound() bool    // 是否由本节点主动发起连接
+	RTT() time.Duration  // 平滑后的心跳往返时间，尚未测量时为 0
 }
 
 type Transport interface {
 	Dial(string) error
 	Consume() <-chan RPC
 	Close() error
-	SendMessage(NetAddr, []byte) error
-	Broadcast([]byte) error
+	SendMessage(NetAddr, MessageType, any) error
+	SendWithID(to NetAddr, id uint64, response bool, msgType MessageType, payload any) error
+	Broadcast(MessageType, any) error
 	Addr() NetAddr
+	ID() NetAddr // 本节点的节点 ID
 	PeerEvents() <-chan Peer
+	Peers() []Peer // 当前已完成握手的全部节点
+	Disconnect(NetAddr) error
 }
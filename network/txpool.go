@@ -37,6 +37,16 @@ func (p *TxPool) Contains(hash types.Hash) bool {
 	return p.all.Contains(hash)
 }
 
+// Get 返回交易池中哈希为 hash 的交易，不存在时返回 nil
+func (p *TxPool) Get(hash types.Hash) *core.Transaction {
+	return p.all.Get(hash)
+}
+
+// All 返回交易池中的全部交易
+func (p *TxPool) All() []*core.Transaction {
+	return p.all.All()
+}
+
 // Pending returns a slice of transactions that are in the pending pool
 func (p *TxPool) Pending() []*core.Transaction {
 	return p.pending.txx.Data
@@ -98,6 +108,14 @@ func (t *TxSortedMap) Remove(h types.Hash) {
 	delete(t.lookup, h)
 }
 
+// All 返回全部交易的副本
+func (t *TxSortedMap) All() []*core.Transaction {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return append([]*core.Transaction{}, t.txx.Data...)
+}
+
 func (t *TxSortedMap) Count() int {
 	t.lock.RLock()
 	defer t.lock.RUnlock()
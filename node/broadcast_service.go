@@ -1,81 +1,294 @@
 package node
 
 import (
-	"bytes"
+	"context"
 	"github.com/go-kit/log"
 	"github.com/virtue186/xchain/core"
 	"github.com/virtue186/xchain/network"
+	"github.com/virtue186/xchain/types"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+const (
+	relayQueueSize   = 256                     // 等待通告的区块和交易数上限，超出时丢弃新的消息
+	knownPerPeer     = 4096                    // 每个节点记住的已知条目数
+	getDataTimeout   = 5 * time.Second         // 请求的条目在这段时间内没有到达时，向下一个通告了它的节点重新请求
+	maxRequestedKeep = 4 * network.MaxInvItems // 请求记录超过这个数量时清理过期的记录
+	maxAnnouncers    = 8                       // 每个条目最多记住的备选通告节点数
+)
+
+// BroadcastService 以通告加请求的方式传播区块和交易：向节点发送哈希，节点只请求自己缺少的条目。
+// 它为每个节点记录对方已知的条目，不会向已经知道某个条目的节点再次通告
 type BroadcastService struct {
-	logger  log.Logger
-	server  *network.Server   // 持有Server引用，以调用其Broadcast方法
-	encoder core.Encoder[any] // 持有统一的编码器
+	logger log.Logger
+	server *network.Server // 持有Server引用，以调用其Broadcast方法
+	seen   *network.SeenCache
 
 	blockChan chan *core.Block
-	txChan    chan *core.Transaction
+	relayChan chan gossip
+	queued    atomic.Int64  // 放入 relayChan、还没有通告完的条目数
+	announced atomic.Uint64 // 已经通告的本节点区块数
+
+	lock           sync.Mutex
+	known          map[network.NetAddr]*network.SeenCache // 节点 -> 对方已知的条目
+	requested      map[types.Hash]*request                // 已经请求、尚未收到的条目
+	getDataTimeout time.Duration
+}
+
+// request 记录一个已经请求、尚未收到的条目
+type request struct {
+	item       network.InvItem
+	from       network.NetAddr   // 最近一次请求的节点
+	at         time.Time         // 最近一次请求的时间
+	announcers []network.NetAddr // 也通告了这个条目、还没有请求过的节点，按通告顺序排列
+}
+
+// gossip 是一条需要通告的区块或交易，不会通告给来源节点 from
+type gossip struct {
+	item network.InvItem
+	from network.NetAddr
 }
 
-func NewBroadcastService(l log.Logger, s *network.Server, e core.Encoder[any]) *BroadcastService {
+func NewBroadcastService(l log.Logger, s *network.Server) *BroadcastService {
 	return &BroadcastService{
-		logger:    l,
-		server:    s,
-		encoder:   e,
-		blockChan: make(chan *core.Block, 10),       // 使用带缓冲的channel
-		txChan:    make(chan *core.Transaction, 10), // 使用带缓冲的channel
+		logger:         l,
+		server:         s,
+		seen:           network.NewSeenCache(network.DefaultSeenCacheSize),
+		blockChan:      make(chan *core.Block, 10), // 使用带缓冲的channel
+		relayChan:      make(chan gossip, relayQueueSize),
+		known:          make(map[network.NetAddr]*network.SeenCache),
+		requested:      make(map[types.Hash]*request),
+		getDataTimeout: getDataTimeout,
 	}
 }
 
-// BlockBroadcastChan 返回一个只写的channel，供外部发送区块
+// BlockBroadcastChan 返回一个只写的channel，供外部发送本节点产生的区块
 func (bs *BroadcastService) BlockBroadcastChan() chan<- *core.Block {
 	return bs.blockChan
 }
 
-// TxBroadcastChan 返回一个只写的channel，供外部发送交易
-func (bs *BroadcastService) TxBroadcastChan() chan<- *core.Transaction {
-	return bs.txChan
+// MarkSeen 记录收到的区块或交易，第一次见到时返回 true，之后的重复消息应被忽略
+func (bs *BroadcastService) MarkSeen(hash types.Hash) bool {
+	bs.lock.Lock()
+	delete(bs.requested, hash)
+	bs.lock.Unlock()
+	return bs.seen.Add(hash)
+}
+
+// Seen 判断区块或交易是否已经见过
+func (bs *BroadcastService) Seen(hash types.Hash) bool {
+	return bs.seen.Contains(hash)
+}
+
+// MarkKnown 记录节点 peer 已经拥有 hash，之后不再向它通告
+func (bs *BroadcastService) MarkKnown(peer network.NetAddr, hash types.Hash) {
+	bs.knownBy(peer).Add(hash)
+}
+
+func (bs *BroadcastService) knownBy(peer network.NetAddr) *network.SeenCache {
+	bs.lock.Lock()
+	defer bs.lock.Unlock()
+	known, ok := bs.known[peer]
+	if !ok {
+		known = network.NewSeenCache(knownPerPeer)
+		bs.known[peer] = known
+	}
+	return known
+}
+
+// ShouldRequest 判断是否要向通告了 item 的节点 from 请求它。多个节点通告同一条目时只向第一个节点请求，
+// 其余节点被记下，请求在 getDataTimeout 内没有结果时由 retryRequests 依次向它们重新请求
+func (bs *BroadcastService) ShouldRequest(from network.NetAddr, item network.InvItem) bool {
+	bs.lock.Lock()
+	defer bs.lock.Unlock()
+
+	now := bs.server.Clock.Now()
+	if r, ok := bs.requested[item.Hash]; ok {
+		if now.Sub(r.at) < bs.getDataTimeout {
+			r.addAnnouncer(from)
+			return false
+		}
+		r.from, r.at = from, now
+		return true
+	}
+	if len(bs.requested) >= maxRequestedKeep {
+		for h, r := range bs.requested {
+			if now.Sub(r.at) >= bs.getDataTimeout {
+				delete(bs.requested, h)
+			}
+		}
+	}
+	bs.requested[item.Hash] = &request{item: item, from: from, at: now}
+	return true
+}
+
+func (r *request) addAnnouncer(peer network.NetAddr) {
+	if peer == r.from || len(r.announcers) >= maxAnnouncers {
+		return
+	}
+	for _, a := range r.announcers {
+		if a == peer {
+			return
+		}
+	}
+	r.announcers = append(r.announcers, peer)
+}
+
+// retryRequests 向下一个通告节点重新请求超时的条目，没有其他通告节点的条目被放弃，
+// 之后再收到通告时会重新请求
+func (bs *BroadcastService) retryRequests() {
+	now := bs.server.Clock.Now()
+	retries := make(map[network.NetAddr]*network.GetDataMessage)
+	bs.lock.Lock()
+	for h, r := range bs.requested {
+		if now.Sub(r.at) < bs.getDataTimeout {
+			continue
+		}
+		if len(r.announcers) == 0 {
+			delete(bs.requested, h)
+			continue
+		}
+		r.from, r.at = r.announcers[0], now
+		r.announcers = r.announcers[1:]
+		msg, ok := retries[r.from]
+		if !ok {
+			msg = new(network.GetDataMessage)
+			retries[r.from] = msg
+		}
+		msg.Items = append(msg.Items, r.item)
+	}
+	bs.lock.Unlock()
+
+	for peer, msg := range retries {
+		bs.logger.Log("msg", "retrying timed out requests", "to", peer, "count", len(msg.Items))
+		if err := bs.server.SendMessage(peer, network.MessageTypeGetData, msg); err != nil {
+			bs.logger.Log("msg", "failed to retry requests", "to", peer, "err", err)
+		}
+	}
+}
+
+// RelayBlock 向 from 以外的节点通告从 from 收到的有效区块
+func (bs *BroadcastService) RelayBlock(block *core.Block, from network.NetAddr) {
+	bs.relay(gossip{item: network.InvItem{Type: network.InvTypeBlock, Hash: block.Hash(core.BlockHasher{})}, from: from})
+}
+
+// RelayTx 向 from 以外的节点通告从 from 收到的有效交易
+func (bs *BroadcastService) RelayTx(tx *core.Transaction, from network.NetAddr) {
+	bs.relay(gossip{item: network.InvItem{Type: network.InvTypeTx, Hash: tx.Hash(core.TxHasher{})}, from: from})
 }
 
-// Start 启动广播服务的主循环
-func (bs *BroadcastService) Start() {
+// relay 把条目放入通告队列，不会阻塞消息处理；队列已满时丢弃，其他节点仍可以从别的路径收到
+func (bs *BroadcastService) relay(g gossip) {
+	bs.queued.Add(1)
+	select {
+	case bs.relayChan <- g:
+	default:
+		bs.queued.Add(-1)
+		bs.logger.Log("msg", "relay queue full, dropping announcement", "hash", g.item.Hash, "from", g.from)
+	}
+}
+
+// Start 启动广播服务的主循环，ctx 被取消后先通告已排队的区块和交易再返回
+func (bs *BroadcastService) Start(ctx context.Context) {
 	bs.logger.Log("msg", "starting broadcast service")
+	ticker := bs.server.Clock.NewTicker(bs.getDataTimeout / 2)
+	defer ticker.Stop()
 	for {
 		select {
+		case <-ctx.Done():
+			bs.drain()
+			return
+		case <-ticker.C():
+			bs.retryRequests()
 		case block := <-bs.blockChan:
-			if err := bs.broadcastBlock(block); err != nil {
-				bs.logger.Log("msg", "failed to broadcast block", "err", err)
-			}
-		case tx := <-bs.txChan:
-			if err := bs.broadcastTransaction(tx); err != nil {
-				bs.logger.Log("msg", "failed to broadcast transaction", "err", err)
-			}
+			bs.announce(bs.collect(bs.localBlock(block)))
+		case g := <-bs.relayChan:
+			bs.announce(bs.collect(g))
+		}
+	}
+}
+
+// drain 通告通道中剩余的区块和交易
+func (bs *BroadcastService) drain() {
+	for {
+		select {
+		case block := <-bs.blockChan:
+			bs.announce(bs.collect(bs.localBlock(block)))
+		case g := <-bs.relayChan:
+			bs.announce(bs.collect(g))
+		default:
+			return
 		}
 	}
 }
 
-func (bs *BroadcastService) broadcastBlock(block *core.Block) error {
-	bs.logger.Log("msg", "broadcasting new block", "hash", block.Hash(core.BlockHasher{}))
-	return bs.broadcast(network.MessageTypeBlock, block)
+// localBlock 把本节点产生的区块记为已见过，其他节点通告回来时不会再请求
+func (bs *BroadcastService) localBlock(block *core.Block) gossip {
+	hash := block.Hash(core.BlockHasher{})
+	bs.seen.Add(hash)
+	bs.logger.Log("msg", "broadcasting new block", "hash", hash)
+	return gossip{item: network.InvItem{Type: network.InvTypeBlock, Hash: hash}}
 }
 
-func (bs *BroadcastService) broadcastTransaction(tx *core.Transaction) error {
-	bs.logger.Log("msg", "broadcasting new transaction", "hash", tx.Hash(core.TxHasher{}))
-	return bs.broadcast(network.MessageTypeTx, tx)
+// collect 从 first 开始取出已经排队的条目，合并到一次通告中
+func (bs *BroadcastService) collect(first gossip) []gossip {
+	batch := []gossip{first}
+	for len(batch) < network.MaxInvItems {
+		select {
+		case block := <-bs.blockChan:
+			batch = append(batch, bs.localBlock(block))
+		case g := <-bs.relayChan:
+			batch = append(batch, g)
+		default:
+			return batch
+		}
+	}
+	return batch
 }
 
-// broadcast 是一个通用的辅助函数，负责编码和广播
-func (bs *BroadcastService) broadcast(msgType network.MessageType, data any) error {
-	buf := &bytes.Buffer{}
-	if err := bs.encoder.Encode(buf, data); err != nil {
-		return err
+// announce 向每个节点通告它尚未知道的条目，并清理已断开节点的记录
+func (bs *BroadcastService) announce(batch []gossip) {
+	peers := bs.server.Peers()
+	connected := make(map[network.NetAddr]bool, len(peers))
+	for _, p := range peers {
+		connected[p.ID()] = true
+		known := bs.knownBy(p.ID())
+		msg := new(network.InvMessage)
+		for _, g := range batch {
+			// 来源节点当然知道这个条目，记下但不通告
+			if known.Add(g.item.Hash) && g.from != p.ID() {
+				msg.Items = append(msg.Items, g.item)
+			}
+		}
+		if len(msg.Items) == 0 {
+			continue
+		}
+		if err := p.Send(network.MessageTypeInv, msg); err != nil {
+			bs.logger.Log("msg", "failed to announce inventory", "to", p.ID(), "err", err)
+		}
 	}
 
-	msg := network.NewMessage(msgType, buf.Bytes())
+	bs.lock.Lock()
+	for id := range bs.known {
+		if !connected[id] {
+			delete(bs.known, id)
+		}
+	}
+	bs.lock.Unlock()
 
-	finalBuf := &bytes.Buffer{}
-	if err := bs.encoder.Encode(finalBuf, msg); err != nil {
-		return err
+	// 本节点产生的区块没有来源节点，也不经过 relayChan
+	for _, g := range batch {
+		if g.from == "" {
+			bs.announced.Add(1)
+		} else {
+			bs.queued.Add(-1)
+		}
 	}
+}
 
-	return bs.server.Broadcast(finalBuf.Bytes())
+// idle 判断是否没有等待通告的区块和交易
+func (bs *BroadcastService) idle() bool {
+	return bs.queued.Load() == 0 && len(bs.blockChan) == 0
 }
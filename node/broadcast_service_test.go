@@ -0,0 +1,86 @@
+package node
+
+import (
+	"context"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/virtue186/xchain/network"
+	"github.com/virtue186/xchain/types"
+	"testing"
+	"time"
+)
+
+func TestBroadcastServiceAnnounce(t *testing.T) {
+	net := network.NewLocalNetwork()
+	hub := net.NewTransport("hub")
+	a, b := net.NewTransport("a"), net.NewTransport("b")
+	assert.Nil(t, hub.Dial("a"))
+	assert.Nil(t, hub.Dial("b"))
+	bs := NewBroadcastService(log.NewNopLogger(), network.NewServer(network.ServerOpts{Transports: []network.Transport{hub}}))
+
+	var h1, h2 types.Hash
+	h1[0], h2[0] = 1, 2
+	tx := network.InvItem{Type: network.InvTypeTx, Hash: h1}
+	block := network.InvItem{Type: network.InvTypeBlock, Hash: h2}
+
+	// a 已经知道 h1，h2 来自 b
+	bs.MarkKnown("a", h1)
+	bs.announce([]gossip{{item: tx}, {item: block, from: "b"}})
+	assert.Equal(t, []network.InvItem{block}, receiveInv(t, a))
+	assert.Equal(t, []network.InvItem{tx}, receiveInv(t, b))
+
+	// 已经通告过的条目不再通告
+	bs.announce([]gossip{{item: tx}, {item: block}})
+	assert.Equal(t, 0, len(a.Consume()))
+	assert.Equal(t, 0, len(b.Consume()))
+
+	assert.True(t, bs.ShouldRequest("a", tx))
+	assert.False(t, bs.ShouldRequest("b", tx))
+	bs.MarkSeen(h1)
+	assert.True(t, bs.Seen(h1))
+}
+
+func TestBroadcastServiceRetryRequest(t *testing.T) {
+	net := network.NewLocalNetwork()
+	hub := net.NewTransport("hub")
+	a, b := net.NewTransport("a"), net.NewTransport("b")
+	assert.Nil(t, hub.Dial("a"))
+	assert.Nil(t, hub.Dial("b"))
+	bs := NewBroadcastService(log.NewNopLogger(), network.NewServer(network.ServerOpts{Transports: []network.Transport{hub}}))
+	bs.getDataTimeout = 50 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go bs.Start(ctx)
+
+	var h types.Hash
+	h[0] = 1
+	item := network.InvItem{Type: network.InvTypeCompactBlock, Hash: h}
+
+	// a 先通告，只向 a 请求；a 一直不响应，超时后向 b 重新请求
+	assert.True(t, bs.ShouldRequest("a", item))
+	assert.False(t, bs.ShouldRequest("b", item))
+	assert.False(t, bs.ShouldRequest("a", item))
+	var rpc network.RPC
+	select {
+	case rpc = <-b.Consume():
+	case <-time.After(time.Second):
+		t.Fatal("no request sent to the second announcer")
+	}
+	assert.Equal(t, network.MessageTypeGetData, rpc.Message.Header)
+	req := new(network.GetDataMessage)
+	assert.Nil(t, rpc.Codec.Unmarshal(rpc.Message.Data, req))
+	assert.Equal(t, []network.InvItem{item}, req.Items)
+	assert.Equal(t, 0, len(a.Consume()))
+
+	// b 也不响应时放弃，之后的通告会重新请求
+	assert.Eventually(t, func() bool { return bs.ShouldRequest("a", item) }, time.Second, 10*time.Millisecond)
+}
+
+func receiveInv(t *testing.T, tr *network.LocalTransport) []network.InvItem {
+	assert.Equal(t, 1, len(tr.Consume()))
+	rpc := <-tr.Consume()
+	assert.Equal(t, network.MessageTypeInv, rpc.Message.Header)
+	inv := new(network.InvMessage)
+	assert.Nil(t, rpc.Codec.Unmarshal(rpc.Message.Data, inv))
+	return inv.Items
+}
@@ -1,29 +1,59 @@
 package node
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
 	"github.com/go-kit/log"
 	"github.com/virtue186/xchain/core"
 	"github.com/virtue186/xchain/network"
+	"github.com/virtue186/xchain/types"
+	"sync"
 	"time"
 )
 
 type ChainService struct {
-	logger        log.Logger
-	blockChain    *core.BlockChain
-	txPool        *network.TxPool
-	txBroadcaster chan<- *core.Transaction
-	server        *network.Server
+	logger      log.Logger
+	blockChain  *core.BlockChain
+	txPool      *network.TxPool
+	broadcaster *BroadcastService
+	server      *network.Server
+
+	syncLock    sync.Mutex
+	syncing     bool
+	syncPending network.NetAddr // 同步期间又被要求与之同步的节点，当前同步结束后继续
+
+	// blockLock 串行化区块的加入和孤块的连接，消息循环和同步协程都会加入区块
+	blockLock sync.Mutex
+
+	partial      map[types.Hash]*partialBlock // 正在等待缺少交易的紧凑区块，只在消息循环中访问
+	partialOrder []types.Hash                 // partial 中的区块按加入顺序排列，用于淘汰
+	orphans      *orphanPool
 }
 
-func NewChainService(bc *core.BlockChain, txPool *network.TxPool, logger log.Logger, txb chan<- *core.Transaction, server *network.Server) *ChainService {
+// partialBlock 是用交易池重建了一部分的紧凑区块
+type partialBlock struct {
+	from    network.NetAddr
+	block   *core.Block
+	missing []uint32
+}
+
+const (
+	maxBlocksPerRequest = 100 // 一次区块请求最多返回的区块数
+	maxPartialBlocks    = 16  // 同时等待缺少交易的紧凑区块数
+)
+
+// errBlockAhead 表示区块高于当前高度加一，本节点缺少中间的区块，需要同步
+var errBlockAhead = errors.New("block is ahead of the chain")
+
+func NewChainService(bc *core.BlockChain, txPool *network.TxPool, logger log.Logger, bs *BroadcastService, server *network.Server) *ChainService {
 	return &ChainService{
-		blockChain:    bc,
-		txPool:        txPool,
-		logger:        logger,
-		txBroadcaster: txb,
-		server:        server,
+		blockChain:  bc,
+		txPool:      txPool,
+		logger:      logger,
+		broadcaster: bs,
+		server:      server,
+		partial:     make(map[types.Hash]*partialBlock),
+		orphans:     newOrphanPool(defaultMaxOrphans),
 	}
 }
 
@@ -35,22 +65,218 @@ func (s *ChainService) OnPeer(p *network.TCPPeer) error {
 func (s *ChainService) ProcessMessage(msg *network.DecodedMessage) error {
 	switch t := msg.Data.(type) {
 	case *core.Transaction:
-		return s.ProcessTransaction(t)
+		// 同一笔交易会从多个节点收到，只处理和转发第一次
+		hash := t.Hash(core.TxHasher{})
+		s.broadcaster.MarkKnown(msg.From, hash)
+		if !s.broadcaster.MarkSeen(hash) {
+			return nil
+		}
+		if err := s.ProcessTransaction(t); err != nil {
+			s.server.Penalize(msg.From, network.PenaltyInvalidTx, err.Error())
+			return err
+		}
+		s.broadcaster.RelayTx(t, msg.From)
+		return nil
 	case *core.Block:
-		return s.ProcessBlock(t)
+		return s.acceptBlock(msg.From, t)
+	case *network.CompactBlockMessage:
+		return s.handleCompactBlock(msg.From, t)
+	case *network.GetBlockTxsMessage:
+		return s.handleGetBlockTxs(msg.From, t)
+	case *network.BlockTxsMessage:
+		return s.handleBlockTxs(msg.From, t)
+	case *network.InvMessage:
+		return s.handleInvMessage(msg.From, t)
+	case *network.GetDataMessage:
+		return s.handleGetDataMessage(msg.From, t)
 	case *network.GetStatusMessage:
-		return s.handleGetStatusMessage(msg.From, t)
-	case *network.StatusMessage:
-		return s.handleStatusMessage(msg.From, t)
+		return s.handleGetStatusMessage(msg)
 	case *network.GetBlocksMessage:
-		return s.handleGetBlocksMessage(msg.From, t)
-	case *network.BlocksMessage:
-		return s.handleBlocksMessage(msg.From, t)
+		return s.handleGetBlocksMessage(msg, t)
+	case *network.StatusMessage, *network.BlocksMessage:
+		// 状态和区块只作为请求的响应出现，由 Server.Request 交付
+		return fmt.Errorf("unsolicited %T from %s", t, msg.From)
 	default:
 		return fmt.Errorf("chain service received unknown message type: %T", t)
 	}
 }
 
+// acceptBlock 把从 from 收到的区块加到链上并转发，然后接上等待它的孤块。
+// 先于父区块到达的区块放入孤块池，并返回 errBlockAhead 以便从对方同步缺少的区块。
+// 接不到链上的区块不记为见过，之后从其他节点再次收到时还可以处理和转发
+func (s *ChainService) acceptBlock(from network.NetAddr, block *core.Block) error {
+	hash := block.Hash(core.BlockHasher{})
+	s.broadcaster.MarkKnown(from, hash)
+	if s.broadcaster.Seen(hash) {
+		return nil
+	}
+	s.blockLock.Lock()
+	defer s.blockLock.Unlock()
+	if err := s.ProcessBlock(block); err != nil {
+		if errors.Is(err, core.ErrInvalidBlock) {
+			s.broadcaster.MarkSeen(hash)
+		}
+		s.penalizeInvalidBlock(from, err)
+		if block.Height > s.blockChain.Height()+1 {
+			return s.addOrphan(from, block, err)
+		}
+		return err
+	}
+	s.broadcaster.MarkSeen(hash)
+	s.broadcaster.RelayBlock(block, from)
+	s.connectOrphans(hash)
+	return nil
+}
+
+// addOrphan 校验签名后把区块放入孤块池。区块已经在池中时说明缺少的区块正在获取，不再返回 errBlockAhead
+func (s *ChainService) addOrphan(from network.NetAddr, block *core.Block, cause error) error {
+	// 孤块无法按链上状态校验，至少保证签名和交易列表有效，避免池中塞满伪造的区块
+	if err := block.Verify(); err != nil {
+		s.broadcaster.MarkSeen(block.Hash(core.BlockHasher{}))
+		s.server.Penalize(from, network.PenaltyInvalidBlock, err.Error())
+		return err
+	}
+	if !s.orphans.add(block, from) {
+		return nil
+	}
+	s.logger.Log("msg", "added orphan block", "hash", block.Hash(core.BlockHasher{}), "height", block.Height, "parent", block.PrevBlockHash, "orphans", s.orphans.len())
+	return fmt.Errorf("%w: %v", errBlockAhead, cause)
+}
+
+// connectOrphans 把等待 parent 的孤块接到链上，再依次处理它们的子孙，最后清理已经过时的孤块。
+// 调用方需持有 blockLock
+func (s *ChainService) connectOrphans(parent types.Hash) {
+	queue := []types.Hash{parent}
+	for len(queue) > 0 {
+		children := s.orphans.take(queue[0])
+		queue = queue[1:]
+		for _, o := range children {
+			if err := s.ProcessBlock(o.block); err != nil {
+				s.logger.Log("msg", "failed to connect orphan block", "hash", o.hash, "height", o.block.Height, "err", err)
+				if errors.Is(err, core.ErrInvalidBlock) {
+					s.broadcaster.MarkSeen(o.hash)
+				}
+				s.penalizeInvalidBlock(o.from, err)
+				continue
+			}
+			s.logger.Log("msg", "connected orphan block", "hash", o.hash, "height", o.block.Height)
+			s.broadcaster.MarkSeen(o.hash)
+			s.broadcaster.RelayBlock(o.block, o.from)
+			queue = append(queue, o.hash)
+		}
+	}
+	s.orphans.prune(s.blockChain.Height())
+}
+
+// handleCompactBlock 用交易池重建紧凑区块，交易齐全时直接处理，否则向发送方请求缺少的交易
+func (s *ChainService) handleCompactBlock(from network.NetAddr, cb *network.CompactBlockMessage) error {
+	hash := cb.Hash()
+	s.broadcaster.MarkKnown(from, hash)
+	if s.broadcaster.Seen(hash) || s.blockChain.HasBlock(hash) {
+		return nil
+	}
+	if p, ok := s.partial[hash]; ok {
+		if p.from == from {
+			// 已经在向它请求这个区块缺少的交易
+			return nil
+		}
+		// 请求超时后又从其他节点收到了这个区块，改为向新的发送方请求缺少的交易
+		s.takePartial(hash)
+	}
+	block, missing := cb.Reconstruct(s.txPool)
+	if len(missing) == 0 {
+		return s.acceptReconstructed(from, block)
+	}
+
+	for len(s.partial) >= maxPartialBlocks && len(s.partialOrder) > 0 {
+		// 发送方一直不响应时，最早的条目由这里淘汰，区块可以从全区块或同步中再次得到
+		s.takePartial(s.partialOrder[0])
+	}
+	s.partial[hash] = &partialBlock{from: from, block: block, missing: missing}
+	s.partialOrder = append(s.partialOrder, hash)
+	s.logger.Log("msg", "requesting missing block transactions", "hash", hash, "missing", len(missing), "total", len(block.Transactions), "from", from)
+	return s.server.SendMessage(from, network.MessageTypeGetBlockTxs, &network.GetBlockTxsMessage{BlockHash: hash, Indexes: missing})
+}
+
+// handleBlockTxs 用收到的交易补全紧凑区块
+func (s *ChainService) handleBlockTxs(from network.NetAddr, resp *network.BlockTxsMessage) error {
+	p, ok := s.partial[resp.BlockHash]
+	if !ok || p.from != from {
+		return fmt.Errorf("unsolicited block transactions for %s from %s", resp.BlockHash, from)
+	}
+	s.takePartial(resp.BlockHash)
+	if len(resp.Transactions) != len(p.missing) {
+		s.server.Penalize(from, network.PenaltyInvalidBlock, "wrong number of block transactions")
+		return fmt.Errorf("expected %d block transactions, got %d", len(p.missing), len(resp.Transactions))
+	}
+	for i, idx := range p.missing {
+		p.block.Transactions[idx] = resp.Transactions[i]
+	}
+	// 交易池中的交易与区块中的交易短 ID 相同的概率可以忽略，补全之后与区块头不符说明对方发来的交易是错的。
+	// 不再向它请求这个区块，区块没有记为见过，仍可以从其他节点得到
+	dataHash, err := core.CalculateDataHash(p.block.Transactions)
+	if err != nil {
+		return err
+	}
+	if dataHash != p.block.DataHash {
+		s.server.Penalize(from, network.PenaltyInvalidBlock, "block transactions do not match data hash")
+		return fmt.Errorf("block transactions for %s from %s do not match data hash", resp.BlockHash, from)
+	}
+	return s.acceptBlock(from, p.block)
+}
+
+// takePartial 从等待缺少交易的紧凑区块中删除 hash
+func (s *ChainService) takePartial(hash types.Hash) {
+	delete(s.partial, hash)
+	for i, h := range s.partialOrder {
+		if h == hash {
+			s.partialOrder = append(s.partialOrder[:i], s.partialOrder[i+1:]...)
+			break
+		}
+	}
+}
+
+// acceptReconstructed 处理重建的区块。交易与区块头不符时可能是短 ID 冲突，不能认定对方作恶，
+// 改为请求完整的区块
+func (s *ChainService) acceptReconstructed(from network.NetAddr, block *core.Block) error {
+	dataHash, err := core.CalculateDataHash(block.Transactions)
+	if err != nil {
+		return err
+	}
+	if dataHash != block.DataHash {
+		hash := block.Hash(core.BlockHasher{})
+		s.logger.Log("msg", "reconstructed block does not match, requesting full block", "hash", hash, "from", from)
+		return s.server.SendMessage(from, network.MessageTypeGetData, &network.GetDataMessage{
+			Items: []network.InvItem{{Type: network.InvTypeBlock, Hash: hash}},
+		})
+	}
+	return s.acceptBlock(from, block)
+}
+
+// handleGetBlockTxs 按下标回复区块中的交易
+func (s *ChainService) handleGetBlockTxs(from network.NetAddr, req *network.GetBlockTxsMessage) error {
+	block, err := s.blockChain.GetBlockByHash(req.BlockHash)
+	if err != nil {
+		return fmt.Errorf("block transactions requested for unknown block %s: %w", req.BlockHash, err)
+	}
+	resp := &network.BlockTxsMessage{BlockHash: req.BlockHash, Transactions: make([]*core.Transaction, 0, len(req.Indexes))}
+	for _, idx := range req.Indexes {
+		if int(idx) >= len(block.Transactions) {
+			return fmt.Errorf("transaction index %d out of range in block %s", idx, req.BlockHash)
+		}
+		resp.Transactions = append(resp.Transactions, block.Transactions[idx])
+	}
+	return s.server.SendMessage(from, network.MessageTypeBlockTxs, resp)
+}
+
+// penalizeInvalidBlock 只在区块本身无效时扣分，重复或高度不匹配的区块不算违规
+func (s *ChainService) penalizeInvalidBlock(from network.NetAddr, err error) {
+	if errors.Is(err, core.ErrInvalidBlock) {
+		s.server.Penalize(from, network.PenaltyInvalidBlock, err.Error())
+	}
+}
+
+// ProcessTransaction 把交易加入交易池，只有交易签名无效时才返回错误。交易由调用方负责转发
 func (s *ChainService) ProcessTransaction(tx *core.Transaction) error {
 
 	hash := tx.Hash(core.TxHasher{})
@@ -68,9 +294,6 @@ func (s *ChainService) ProcessTransaction(tx *core.Transaction) error {
 		"hash", hash,
 		"mempoolPending", s.txPool.PendingCount(),
 	)
-	go func() {
-		s.txBroadcaster <- tx
-	}()
 	s.txPool.Add(tx)
 	return nil
 }
@@ -83,149 +306,187 @@ func (s *ChainService) ProcessBlock(block *core.Block) error {
 
 	s.txPool.Flush(block.Transactions)
 	s.logger.Log("msg", "flushed mempool", "count", len(block.Transactions))
-
-	// TODO  未实现广播收到的区块
-
 	return nil
 }
 
-// handleGetStatusMessage 当收到状态请求时，回复自己的状态
-func (s *ChainService) handleGetStatusMessage(from network.NetAddr, data *network.GetStatusMessage) error {
-	s.logger.Log("msg", "received get_status message", "from", from)
-	statusMsg := &network.StatusMessage{
-		ID:            s.server.ID,
-		CurrentHeight: s.blockChain.Height(),
+// handleInvMessage 记录对方拥有的条目，并向它请求本节点缺少、且没有向其他节点请求过的条目。
+// 已经向其他节点请求过的条目只记下对方，请求超时后再向它请求
+func (s *ChainService) handleInvMessage(from network.NetAddr, inv *network.InvMessage) error {
+	req := new(network.GetDataMessage)
+	for _, item := range inv.Items {
+		s.broadcaster.MarkKnown(from, item.Hash)
+		if s.has(item) {
+			continue
+		}
+		if item.Type == network.InvTypeBlock {
+			// 区块中的交易通常已经通过交易广播收到，请求紧凑区块
+			item.Type = network.InvTypeCompactBlock
+		}
+		if !s.broadcaster.ShouldRequest(from, item) {
+			continue
+		}
+		req.Items = append(req.Items, item)
+	}
+	if len(req.Items) == 0 {
+		return nil
 	}
+	return s.server.SendMessage(from, network.MessageTypeGetData, req)
+}
 
-	buf := new(bytes.Buffer)
-	if err := s.server.Encoder.Encode(buf, statusMsg); err != nil {
-		return err
+// has 判断本节点是否已经有某个条目
+func (s *ChainService) has(item network.InvItem) bool {
+	if s.broadcaster.Seen(item.Hash) {
+		return true
 	}
-	msg := network.NewMessage(network.MessageTypeStatus, buf.Bytes())
-	finalBuf := new(bytes.Buffer)
-	if err := s.server.Encoder.Encode(finalBuf, msg); err != nil {
-		return err
+	switch item.Type {
+	case network.InvTypeTx:
+		return s.txPool.Contains(item.Hash)
+	case network.InvTypeBlock:
+		return s.blockChain.HasBlock(item.Hash)
+	default:
+		// 不认识的类型不请求
+		return true
 	}
-
-	s.logger.Log("msg", "sending status message", "to", from, "height", statusMsg.CurrentHeight)
-	return s.server.SendMessage(from, finalBuf.Bytes())
 }
 
-// handleStatusMessage 收到对方的状态后，决策是否需要同步
-func (s *ChainService) handleStatusMessage(from network.NetAddr, data *network.StatusMessage) error {
-	s.logger.Log("msg", "received status message", "from", from, "peerHeight", data.CurrentHeight)
+// handleGetDataMessage 以普通的交易和区块消息发送对方请求的条目，本节点没有的条目被忽略
+func (s *ChainService) handleGetDataMessage(from network.NetAddr, req *network.GetDataMessage) error {
+	for _, item := range req.Items {
+		var err error
+		switch item.Type {
+		case network.InvTypeTx:
+			tx := s.txPool.Get(item.Hash)
+			if tx == nil {
+				continue
+			}
+			err = s.server.SendMessage(from, network.MessageTypeTx, tx)
+		case network.InvTypeBlock:
+			block, getErr := s.blockChain.GetBlockByHash(item.Hash)
+			if getErr != nil {
+				continue
+			}
+			err = s.server.SendMessage(from, network.MessageTypeBlock, block)
+		case network.InvTypeCompactBlock:
+			block, getErr := s.blockChain.GetBlockByHash(item.Hash)
+			if getErr != nil {
+				continue
+			}
+			err = s.server.SendMessage(from, network.MessageTypeCompactBlock, network.NewCompactBlockMessage(block))
+		default:
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		s.broadcaster.MarkKnown(from, item.Hash)
+	}
+	return nil
+}
 
-	myHeight := s.blockChain.Height()
-	if data.CurrentHeight > myHeight {
-		s.logger.Log("msg", "starting sync with peer", "peerHeight", data.CurrentHeight, "myHeight", myHeight)
-		// 直接调用封装好的方法来请求区块
-		return s.requestBlocks(from, myHeight+1)
+// handleGetStatusMessage 当收到状态请求时，回复自己的状态
+func (s *ChainService) handleGetStatusMessage(req *network.DecodedMessage) error {
+	s.logger.Log("msg", "received get_status message", "from", req.From)
+	statusMsg := &network.StatusMessage{
+		ID:            s.server.ID,
+		CurrentHeight: s.blockChain.Height(),
 	}
 
-	s.logger.Log("msg", "no sync needed", "from", from)
-	return nil
+	s.logger.Log("msg", "sending status message", "to", req.From, "height", statusMsg.CurrentHeight)
+	return s.server.Reply(req, network.MessageTypeStatus, statusMsg)
 }
 
-// handleGetBlocksMessage 收到区块请求，从数据库读取并发送回去
-func (s *ChainService) handleGetBlocksMessage(from network.NetAddr, data *network.GetBlocksMessage) error {
-	s.logger.Log("msg", "received get_blocks message", "from", from, "start", data.From)
+// handleGetBlocksMessage 收到区块请求，从数据库读取并发送回去，没有区块时回复空列表
+func (s *ChainService) handleGetBlocksMessage(req *network.DecodedMessage, data *network.GetBlocksMessage) error {
+	s.logger.Log("msg", "received get_blocks message", "from", req.From, "start", data.From)
 
-	// 一次最多发送100个区块
-	blocks, err := s.blockChain.GetBlocks(data.From, 100)
+	blocks, err := s.blockChain.GetBlocks(data.From, maxBlocksPerRequest)
 	if err != nil {
 		return err
 	}
 
-	if len(blocks) == 0 {
-		s.logger.Log("msg", "no blocks to send", "from", from, "start", data.From)
+	s.logger.Log("msg", "sending blocks", "to", req.From, "count", len(blocks))
+	return s.server.Reply(req, network.MessageTypeBlocks, &network.BlocksMessage{Blocks: blocks})
+}
+
+// SyncWith 向节点 peer 请求状态，对方更高时按批请求区块直到追上。
+// 某一批请求失败时会换其他节点重试。同一时间只进行一次同步，
+// 同步期间的新请求不会丢失，当前同步结束后会与最近一次请求的节点再同步一次
+func (s *ChainService) SyncWith(peer network.NetAddr) error {
+	s.syncLock.Lock()
+	if s.syncing {
+		s.syncPending = peer
+		s.syncLock.Unlock()
 		return nil
 	}
-
-	s.logger.Log("msg", "sending blocks", "to", from, "count", len(blocks))
-
-	blocksData := make([][]byte, len(blocks))
-	for i, block := range blocks {
-		buf := new(bytes.Buffer)
-		if err := block.Encode(buf, core.JSONEncoder[*core.Block]{}); err != nil { // 假设 Block 有 Encode 方法
+	s.syncing = true
+	s.syncLock.Unlock()
+
+	for {
+		err := s.syncWith(peer)
+		// 同步得到的区块可能是孤块的父区块
+		s.blockLock.Lock()
+		s.connectOrphans(core.BlockHasher{}.Hash(s.blockChain.CurrentHeader()))
+		s.blockLock.Unlock()
+
+		s.syncLock.Lock()
+		next := s.syncPending
+		s.syncPending = ""
+		if next == "" {
+			s.syncing = false
+			s.syncLock.Unlock()
 			return err
 		}
-		blocksData[i] = buf.Bytes()
-	}
-
-	blocksMsg := &network.BlocksMessage{
-		Blocks: blocksData,
+		s.syncLock.Unlock()
+		if err != nil {
+			s.logger.Log("msg", "sync failed", "with", peer, "err", err)
+		}
+		peer = next
 	}
+}
 
-	buf := new(bytes.Buffer)
-	if err := s.server.Encoder.Encode(buf, blocksMsg); err != nil {
-		return err
-	}
-	msg := network.NewMessage(network.MessageTypeBlocks, buf.Bytes())
-	finalBuf := new(bytes.Buffer)
-	if err := s.server.Encoder.Encode(finalBuf, msg); err != nil {
+func (s *ChainService) syncWith(peer network.NetAddr) error {
+	resp, err := s.server.Request(peer, network.MessageTypeGetStatus, new(network.GetStatusMessage))
+	if err != nil {
 		return err
 	}
-
-	return s.server.SendMessage(from, finalBuf.Bytes())
-}
-
-// handleBlocksMessage 收到区块数据，进行验证并添加到本地区块链
-func (s *ChainService) handleBlocksMessage(from network.NetAddr, data *network.BlocksMessage) error {
-	s.logger.Log("msg", "received blocks message", "from", from, "count", len(data.Blocks))
-
-	if len(data.Blocks) == 0 {
-		s.logger.Log("msg", "peer sent empty blocks message, sync might be complete", "from", from)
-		return nil
+	status, ok := resp.Data.(*network.StatusMessage)
+	if !ok {
+		return fmt.Errorf("unexpected response %T to get_status", resp.Data)
 	}
-
-	for _, blockData := range data.Blocks {
-		block, err := core.DecodeBlock(blockData)
+	s.logger.Log("msg", "received status", "from", peer, "peerHeight", status.CurrentHeight, "myHeight", s.blockChain.Height())
+
+	for s.blockChain.Height() < status.CurrentHeight {
+		from := s.blockChain.Height() + 1
+		s.logger.Log("msg", "requesting blocks", "to", peer, "fromHeight", from)
+		resp, err := s.server.RequestAny(peer, network.MessageTypeGetBlocks, &network.GetBlocksMessage{From: from}, func(resp *network.DecodedMessage) error {
+			if blocks, ok := resp.Data.(*network.BlocksMessage); !ok || len(blocks.Blocks) == 0 {
+				return fmt.Errorf("no blocks from height %d", from)
+			}
+			return nil
+		})
 		if err != nil {
 			return err
 		}
 
-		if err := s.blockChain.AddBlock(block); err != nil {
-			s.logger.Log("msg", "failed to add synced block, stopping sync with this peer.", "err", err, "height", block.Height)
-			// 如果一个区块验证失败，则立即停止处理后续区块，并停止向该节点同步
+		if err := s.addSyncedBlocks(resp.From, resp.Data.(*network.BlocksMessage).Blocks); err != nil {
 			return err
 		}
 	}
 
-	// --- 完善后的持续同步逻辑 ---
-
-	// 定义每批次请求的最大数量 (与 handleGetBlocksMessage 中的一致)
-	const maxBlocksPerRequest = 100
-
-	// 如果我们收到的区块数量等于我们一次请求的最大数量，
-	// 那么有很高的概率对方还有更多的区块。
-	if len(data.Blocks) == maxBlocksPerRequest {
-		s.logger.Log("msg", "finished processing batch, requesting next batch", "from", from)
-
-		// 立即请求下一批区块，无缝衔接
-		return s.requestBlocks(from, s.blockChain.Height()+1)
-	}
-
-	// 如果收到的区块数量小于最大值，说明我们很可能已经追上了对方的链。
-	// 同步流程自然结束。
-	s.logger.Log("msg", "sync likely complete with peer", "from", from, "newHeight", s.blockChain.Height())
+	s.logger.Log("msg", "sync complete", "with", peer, "height", s.blockChain.Height())
 	return nil
 }
 
-func (s *ChainService) requestBlocks(to network.NetAddr, fromHeight uint32) error {
-	getBlocksMsg := &network.GetBlocksMessage{
-		From: fromHeight,
-	}
-
-	buf := new(bytes.Buffer)
-	if err := s.server.Encoder.Encode(buf, getBlocksMsg); err != nil {
-		return err
-	}
-	msg := network.NewMessage(network.MessageTypeGetBlocks, buf.Bytes())
-	finalBuf := new(bytes.Buffer)
-	if err := s.server.Encoder.Encode(finalBuf, msg); err != nil {
-		return err
+// addSyncedBlocks 把同步得到的一批区块加到链上，期间不会与消息循环中的区块加入和孤块连接交错
+func (s *ChainService) addSyncedBlocks(from network.NetAddr, blocks []*core.Block) error {
+	s.blockLock.Lock()
+	defer s.blockLock.Unlock()
+	for _, block := range blocks {
+		if err := s.blockChain.AddBlock(block); err != nil {
+			s.logger.Log("msg", "failed to add synced block", "err", err, "height", block.Height, "from", from)
+			s.penalizeInvalidBlock(from, err)
+			return err
+		}
 	}
-
-	s.logger.Log("msg", "sending get_blocks message", "to", to, "fromHeight", fromHeight)
-	return s.server.SendMessage(to, finalBuf.Bytes())
+	return nil
 }
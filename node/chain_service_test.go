@@ -0,0 +1,147 @@
+package node
+
+import (
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/virtue186/xchain/core"
+	"github.com/virtue186/xchain/crypto"
+	"github.com/virtue186/xchain/network"
+	"github.com/virtue186/xchain/types"
+	"testing"
+	"time"
+)
+
+// mineBlocks 在与测试节点相同的创世区块之后出 n 个区块
+func mineBlocks(t *testing.T, key crypto.PrivateKey, n int) []*core.Block {
+	genesis, err := core.NewBlock(&core.Header{Version: 1}, nil)
+	assert.Nil(t, err)
+	bc, err := core.NewBlockChain(log.NewNopLogger(), core.NewMemoryStorage(), genesis)
+	assert.Nil(t, err)
+
+	blocks := make([]*core.Block, n)
+	for i := range blocks {
+		prev, err := bc.GetHeader(bc.Height())
+		assert.Nil(t, err)
+		b, err := core.NewBlockFromPreHeader(prev, nil)
+		assert.Nil(t, err)
+		assert.Nil(t, b.Sign(key))
+		assert.Nil(t, bc.AddBlock(b))
+		blocks[i] = b
+	}
+	return blocks
+}
+
+func TestChainServiceOrphanBlocks(t *testing.T) {
+	n, bc := newTestNode(t, network.NewLocalNetwork(), "node", nil)
+	blocks := mineBlocks(t, crypto.GeneratePrivateKey(), 4)
+
+	// 后面的区块先到，放入孤块池并请求同步；对方不存在，同步失败
+	for i := 3; i >= 1; i-- {
+		err := n.ProcessMessage(&network.DecodedMessage{From: "peer", Data: blocks[i]})
+		assert.ErrorIs(t, err, errBlockAhead)
+	}
+	assert.Nil(t, n.ProcessMessage(&network.DecodedMessage{From: "peer", Data: blocks[3]}))
+	assert.Equal(t, uint32(0), bc.Height())
+	assert.Equal(t, 3, n.chainService.orphans.len())
+
+	// 父区块到达后孤块依次接到链上
+	assert.Nil(t, n.ProcessMessage(&network.DecodedMessage{From: "peer", Data: blocks[0]}))
+	assert.Eventually(t, func() bool { return bc.Height() == 4 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 0, n.chainService.orphans.len())
+
+	// 签名无效的孤块不会进入孤块池
+	forged := mineBlocks(t, crypto.GeneratePrivateKey(), 6)[5]
+	forged.Signature = blocks[0].Signature
+	err := n.ProcessMessage(&network.DecodedMessage{From: "forger", Data: forged})
+	assert.NotNil(t, err)
+	assert.NotErrorIs(t, err, errBlockAhead)
+	assert.Equal(t, 0, n.chainService.orphans.len())
+}
+
+// newCompactTestBlock 在创世区块之后出一个包含 txx 的区块
+func newCompactTestBlock(t *testing.T, bc *core.BlockChain, txx ...*core.Transaction) *core.Block {
+	prev, err := bc.GetHeader(0)
+	assert.Nil(t, err)
+	b, err := core.NewBlockFromPreHeader(prev, txx)
+	assert.Nil(t, err)
+	assert.Nil(t, b.Sign(crypto.GeneratePrivateKey()))
+	return b
+}
+
+func TestChainServiceBadBlockTxs(t *testing.T) {
+	net := network.NewLocalNetwork()
+	n, bc := newTestNode(t, net, "node", nil)
+	assert.Nil(t, net.NewTransport("peer").Dial("node"))
+	s := n.chainService
+
+	block := newCompactTestBlock(t, bc, newScenarioTx(t))
+	hash := block.Hash(core.BlockHasher{})
+	assert.Nil(t, s.ProcessMessage(&network.DecodedMessage{From: "peer", Data: network.NewCompactBlockMessage(block)}))
+	assert.Contains(t, s.partial, hash)
+
+	// 对方发来的交易与区块头不符，扣分且不再向它请求
+	bad := &network.BlockTxsMessage{BlockHash: hash, Transactions: []*core.Transaction{newScenarioTx(t)}}
+	assert.NotNil(t, s.ProcessMessage(&network.DecodedMessage{From: "peer", Data: bad}))
+	assert.Equal(t, -network.PenaltyInvalidBlock, n.server.Score("peer"))
+	assert.NotContains(t, s.partial, hash)
+	assert.False(t, n.broadcastService.Seen(hash))
+
+	// 区块仍然可以从其他节点收到
+	assert.Nil(t, s.ProcessMessage(&network.DecodedMessage{From: "other", Data: block}))
+	assert.Equal(t, uint32(1), bc.Height())
+}
+
+func TestChainServicePartialBlocksEvictOldest(t *testing.T) {
+	net := network.NewLocalNetwork()
+	n, bc := newTestNode(t, net, "node", nil)
+	assert.Nil(t, net.NewTransport("peer").Dial("node"))
+	s := n.chainService
+
+	hashes := make([]types.Hash, maxPartialBlocks+1)
+	for i := range hashes {
+		block := newCompactTestBlock(t, bc, newScenarioTx(t))
+		hashes[i] = block.Hash(core.BlockHasher{})
+		assert.Nil(t, s.ProcessMessage(&network.DecodedMessage{From: "peer", Data: network.NewCompactBlockMessage(block)}))
+		// 再次收到正在等待交易的区块不会重复加入
+		assert.Nil(t, s.ProcessMessage(&network.DecodedMessage{From: "peer", Data: network.NewCompactBlockMessage(block)}))
+	}
+	assert.Equal(t, maxPartialBlocks, len(s.partial))
+	assert.NotContains(t, s.partial, hashes[0])
+	for _, h := range hashes[1:] {
+		assert.Contains(t, s.partial, h)
+	}
+}
+
+func TestChainServicePartialBlockFromSilentPeer(t *testing.T) {
+	net := network.NewLocalNetwork()
+	n, bc := newTestNode(t, net, "node", nil)
+	a, b := net.NewTransport("a"), net.NewTransport("b")
+	assert.Nil(t, a.Dial("node"))
+	assert.Nil(t, b.Dial("node"))
+	s := n.chainService
+
+	tx := newScenarioTx(t)
+	block := newCompactTestBlock(t, bc, tx)
+	hash := block.Hash(core.BlockHasher{})
+	cb := network.NewCompactBlockMessage(block)
+
+	// a 发来紧凑区块后一直不回复缺少的交易，之后 b 也发来同一个区块，改为向 b 请求
+	assert.Nil(t, s.ProcessMessage(&network.DecodedMessage{From: "a", Data: cb}))
+	assert.Nil(t, s.ProcessMessage(&network.DecodedMessage{From: "b", Data: cb}))
+	req := new(network.GetBlockTxsMessage)
+	assert.Eventually(t, func() bool {
+		select {
+		case rpc := <-b.Consume():
+			return rpc.Message.Header == network.MessageTypeGetBlockTxs && rpc.Codec.Unmarshal(rpc.Message.Data, req) == nil
+		default:
+			return false
+		}
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, hash, req.BlockHash)
+	assert.Equal(t, []uint32{0}, req.Indexes)
+
+	resp := &network.BlockTxsMessage{BlockHash: hash, Transactions: []*core.Transaction{tx}}
+	assert.Nil(t, s.ProcessMessage(&network.DecodedMessage{From: "b", Data: resp}))
+	assert.Equal(t, uint32(1), bc.Height())
+	assert.NotNil(t, s.ProcessMessage(&network.DecodedMessage{From: "a", Data: resp}))
+}
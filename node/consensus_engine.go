@@ -1,6 +1,7 @@
 package node
 
 import (
+	"context"
 	"fmt"
 	"github.com/go-kit/log"
 	"github.com/virtue186/xchain/core"
@@ -61,14 +62,20 @@ func (ce *ConsensusEngine) IsValidator() bool {
 	return ce.privateKey != nil
 }
 
-func (ce *ConsensusEngine) Start() {
+// Start 按出块间隔创建新区块，直到 ctx 被取消。正在创建的区块会先完成
+func (ce *ConsensusEngine) Start(ctx context.Context) {
 	ticker := time.NewTicker(ce.blockTime)
+	defer ticker.Stop()
 	ce.logger.Log("msg", "starting consensus engine", "blockTime", ce.blockTime)
 
 	for {
-		<-ticker.C
-		if err := ce.createNewBlock(); err != nil {
-			ce.logger.Log("msg", "failed to create new block", "err", err)
+		select {
+		case <-ticker.C:
+			if err := ce.createNewBlock(); err != nil {
+				ce.logger.Log("msg", "failed to create new block", "err", err)
+			}
+		case <-ctx.Done():
+			return
 		}
 	}
 }
@@ -0,0 +1,217 @@
+package node
+
+import (
+	"context"
+	"github.com/go-kit/log"
+	"github.com/virtue186/xchain/network"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTargetOutbound = 8
+	discoveryInterval     = 5 * time.Second // 请求节点列表和保存地址簿的间隔
+	reconnectInterval     = time.Second     // 检查连接和拨号的间隔
+	maxPeersPerMessage    = 32
+)
+
+// DiscoveryService 通过引导节点和节点交换发现新的节点，并不断拨号直到主动连接数达到目标。
+// 它同时负责重连：断开的主动连接会按地址簿中的指数退避重新拨号
+type DiscoveryService struct {
+	logger         log.Logger
+	transport      network.Transport
+	server         *network.Server
+	book           *network.AddrBook
+	bootnodes      []string
+	targetOutbound int
+	dialNow        chan struct{} // 得知新地址后通知主循环立即拨号
+
+	lock     sync.Mutex
+	dialing  map[string]bool            // 正在拨号的地址
+	outbound map[network.NetAddr]string // 上一次检查时的主动连接，节点 ID -> 地址
+}
+
+func NewDiscoveryService(l log.Logger, tr network.Transport, s *network.Server, book *network.AddrBook, bootnodes []string, targetOutbound int) *DiscoveryService {
+	if targetOutbound <= 0 {
+		targetOutbound = defaultTargetOutbound
+	}
+	return &DiscoveryService{
+		logger:         l,
+		transport:      tr,
+		server:         s,
+		book:           book,
+		bootnodes:      bootnodes,
+		targetOutbound: targetOutbound,
+		dialNow:        make(chan struct{}, 1),
+		dialing:        make(map[string]bool),
+		outbound:       make(map[network.NetAddr]string),
+	}
+}
+
+// Start 把引导节点加入地址簿，然后定期检查连接、拨号、请求新的节点并保存地址簿，直到 ctx 被取消。
+// 地址簿中上次运行时的节点会在启动后立即被拨号，停止时地址簿会再保存一次
+func (ds *DiscoveryService) Start(ctx context.Context) {
+	ds.logger.Log("msg", "starting discovery service", "bootnodes", len(ds.bootnodes), "known", ds.book.Len(), "target", ds.targetOutbound)
+	for _, addr := range ds.bootnodes {
+		ds.book.Add(network.PeerAddr{Addr: addr})
+	}
+
+	clock := ds.server.Clock
+	ticker := clock.NewTicker(reconnectInterval)
+	defer ticker.Stop()
+	lastDiscovery := time.Time{}
+	defer func() {
+		if err := ds.book.Save(); err != nil {
+			ds.logger.Log("msg", "failed to save address book", "err", err)
+		}
+	}()
+	for {
+		ds.checkConnections()
+		need := ds.dialPeers()
+		if clock.Now().Sub(lastDiscovery) >= discoveryInterval {
+			lastDiscovery = clock.Now()
+			if need > 0 {
+				// 主动连接数仍未达标，向已连接的节点请求更多地址
+				if err := ds.server.Broadcast(network.MessageTypeGetPeers, new(network.GetPeersMessage)); err != nil {
+					ds.logger.Log("msg", "failed to request peers", "err", err)
+				}
+			}
+			if err := ds.book.Save(); err != nil {
+				ds.logger.Log("msg", "failed to save address book", "err", err)
+			}
+		}
+		select {
+		case <-ticker.C():
+		case <-ds.dialNow:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// checkConnections 更新已连接节点的最近连接时间，并找出断开的主动连接，它们会在退避时间后被重新拨号
+func (ds *DiscoveryService) checkConnections() {
+	now := ds.server.Clock.Now()
+	current := make(map[network.NetAddr]string)
+	for _, p := range ds.transport.Peers() {
+		ds.book.MarkSeen(p.Info().ListenAddr, now)
+		if p.IsOutbound() {
+			current[p.ID()] = p.Info().ListenAddr
+		}
+	}
+
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+	for id, addr := range ds.outbound {
+		if _, ok := current[id]; !ok {
+			ds.logger.Log("msg", "lost outbound peer, will reconnect", "id", id, "addr", addr)
+		}
+	}
+	ds.outbound = current
+}
+
+// OnPeer 记录新节点通告的地址并向它请求节点列表
+func (ds *DiscoveryService) OnPeer(peer network.Peer) error {
+	ds.book.MarkConnected(network.PeerAddr{ID: peer.ID(), Addr: peer.Info().ListenAddr}, peer.IsOutbound(), ds.server.Clock.Now())
+	return peer.Send(network.MessageTypeGetPeers, new(network.GetPeersMessage))
+}
+
+func (ds *DiscoveryService) ProcessMessage(msg *network.DecodedMessage) error {
+	switch t := msg.Data.(type) {
+	case *network.GetPeersMessage:
+		return ds.handleGetPeersMessage(msg)
+	case *network.PeersMessage:
+		return ds.handlePeersMessage(msg.From, t)
+	}
+	return nil
+}
+
+// handleGetPeersMessage 回复除请求方以外、通告了监听地址的已连接节点
+func (ds *DiscoveryService) handleGetPeersMessage(req *network.DecodedMessage) error {
+	from := req.From
+	msg := new(network.PeersMessage)
+	for _, p := range ds.transport.Peers() {
+		if p.ID() == from || p.Info().ListenAddr == "" {
+			continue
+		}
+		msg.Peers = append(msg.Peers, network.PeerAddr{ID: p.ID(), Addr: p.Info().ListenAddr})
+		if len(msg.Peers) == maxPeersPerMessage {
+			break
+		}
+	}
+	return ds.server.Reply(req, network.MessageTypePeers, msg)
+}
+
+func (ds *DiscoveryService) handlePeersMessage(from network.NetAddr, data *network.PeersMessage) error {
+	added := 0
+	for i, addr := range data.Peers {
+		if i == maxPeersPerMessage {
+			break
+		}
+		if addr.ID == ds.transport.ID() {
+			continue
+		}
+		if ds.book.Add(addr) {
+			added++
+		}
+	}
+	if added > 0 {
+		ds.logger.Log("msg", "learned new peer addresses", "from", from, "count", added)
+		// 通知主循环拨号，服务停止后不会再发起新的拨号
+		select {
+		case ds.dialNow <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// dialPeers 拨号地址簿中已过退避时间且尚未连接的地址，直到主动连接数达到目标，返回仍然缺少的连接数
+func (ds *DiscoveryService) dialPeers() int {
+	connectedIDs := make(map[network.NetAddr]bool)
+	connectedAddrs := make(map[string]bool)
+	outbound := 0
+	for _, p := range ds.transport.Peers() {
+		connectedIDs[p.ID()] = true
+		connectedAddrs[p.Info().ListenAddr] = true
+		if p.IsOutbound() {
+			outbound++
+		}
+	}
+
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	need := ds.targetOutbound - outbound - len(ds.dialing)
+	now := ds.server.Clock.Now()
+	for _, e := range ds.book.DialCandidates(now) {
+		if need <= 0 {
+			break
+		}
+		if e.ID == ds.transport.ID() || connectedIDs[e.ID] || connectedAddrs[e.Addr] || ds.dialing[e.Addr] {
+			continue
+		}
+		ds.book.MarkAttempt(e.Addr, now)
+		ds.dialing[e.Addr] = true
+		go ds.dial(e)
+		need--
+	}
+	return need
+}
+
+// dial 拨号并完成握手，失败时记录到地址簿以增加下一次的退避时间
+func (ds *DiscoveryService) dial(e network.AddrEntry) {
+	err := ds.transport.Dial(e.Addr)
+
+	ds.lock.Lock()
+	delete(ds.dialing, e.Addr)
+	ds.lock.Unlock()
+
+	if err != nil {
+		ds.book.MarkFailed(e.Addr)
+		failed, _ := ds.book.Get(e.Addr)
+		ds.logger.Log("msg", "failed to dial peer", "addr", e.Addr, "failures", failed.Failures, "next", failed.NextDial().Format(time.TimeOnly), "err", err)
+		return
+	}
+	ds.logger.Log("msg", "dialed peer", "addr", e.Addr, "id", e.ID)
+}
@@ -1,13 +1,17 @@
 package node
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/go-kit/log"
 	"github.com/virtue186/xchain/api"
 	"github.com/virtue186/xchain/core"
 	"github.com/virtue186/xchain/crypto"
 	"github.com/virtue186/xchain/network"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -17,8 +21,18 @@ type Node struct {
 	server           *network.Server
 	consensusEngine  *ConsensusEngine // 假设我们有一个共识引擎
 	broadcastService *BroadcastService
+	discoveryService *DiscoveryService
 	transport        network.Transport
 	apiServer        *api.APIServer
+	blockChain       *core.BlockChain
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup // 各服务和同步协程
+	stopOnce sync.Once
+	stopErr  error
+
+	background atomic.Int64 // 正在运行的同步协程和请求节点列表的协程数
 }
 
 type NodeOpts struct {
@@ -28,36 +42,50 @@ type NodeOpts struct {
 	TxPool     *network.TxPool
 	PrivateKey *crypto.PrivateKey
 	BlockTime  time.Duration
-	Encoder    core.Encoder[any]
+	Codec      string // 默认编解码器的名字，为空时使用 JSON
 	APIServer  *api.APIServer
+	// Bootnodes 是启动时用于发现其他节点的地址
+	Bootnodes []string
+	// TargetOutboundPeers 是希望主动建立的连接数，为 0 时使用默认值
+	TargetOutboundPeers int
+	// BanList 记录被封禁的节点，应当与 Transport 共用同一个实例
+	BanList *network.BanList
+	// BanThreshold 是封禁阈值，分数降到阈值及以下的节点会被断开并封禁，为 0 时使用 network.DefaultBanThreshold
+	BanThreshold int
+	// BanDuration 是封禁时长，为 0 时使用 network.DefaultBanDuration
+	BanDuration time.Duration
+	// DataDir 是节点的数据目录，地址簿保存在其中，为空时地址簿只保存在内存中
+	DataDir string
+	// RequestTimeout 是等待其他节点响应请求的最长时间，为 0 时使用 network.DefaultRequestTimeout
+	RequestTimeout time.Duration
+	// Clock 驱动请求超时、重试和重新拨号，为空时使用 network.SystemClock，模拟网络中使用模拟器
+	Clock network.Clock
 }
 
 func NewNode(opts NodeOpts) (*Node, error) {
 
-	// 1. 初始化编码器，提供默认值
-	encoder := opts.Encoder
-	if encoder == nil {
-		encoder = core.JSONEncoder[any]{}
-	}
-
-	// 2. 初始化 ServerOpts，但先不创建Server，因为Server依赖RPCProcessor
+	// 1. 初始化 ServerOpts，但先不创建Server，因为Server依赖RPCProcessor
 	serverOpts := network.ServerOpts{
-		Logger:     opts.Logger,
-		Transports: []network.Transport{opts.Transport},
-		ID:         fmt.Sprintf("NODE-%s", opts.Transport.Addr()),
-		Encoder:    encoder, // 注入统一的编码器
-		// Decoder 将在 NewServer 内部使用默认值
+		Logger:         opts.Logger,
+		Transports:     []network.Transport{opts.Transport},
+		ID:             fmt.Sprintf("NODE-%s", opts.Transport.Addr()),
+		Codec:          opts.Codec,
+		BanList:        opts.BanList,
+		BanThreshold:   opts.BanThreshold,
+		BanDuration:    opts.BanDuration,
+		RequestTimeout: opts.RequestTimeout,
+		Clock:          opts.Clock,
 	}
 	server := network.NewServer(serverOpts)
-	// 3. 初始化 BroadcastService，它依赖 Server 和 Encoder
-	broadcastService := NewBroadcastService(opts.Logger, server, encoder)
+	// 2. 初始化 BroadcastService，它依赖 Server；消息由 Transport 按各连接协商出的编解码器编码
+	broadcastService := NewBroadcastService(opts.Logger, server)
 
-	// 4. 初始化 ChainService，它依赖 BroadcastService
+	// 3. 初始化 ChainService，它依赖 BroadcastService
 	chainService := NewChainService(
 		opts.BlockChain,
 		opts.TxPool,
 		opts.Logger,
-		broadcastService.TxBroadcastChan(), // 现在 broadcastService 已经存在
+		broadcastService, // 现在 broadcastService 已经存在
 		server,
 	)
 
@@ -74,71 +102,140 @@ func NewNode(opts NodeOpts) (*Node, error) {
 		return nil, err
 	}
 
-	// 6. 将完全初始化的 chainService 设置为 Server 的处理器
-	server.RPCProcessor = chainService
+	book := network.NewAddrBook()
+	if opts.DataDir != "" {
+		if book, err = network.LoadAddrBook(filepath.Join(opts.DataDir, "peers.json")); err != nil {
+			return nil, fmt.Errorf("load address book: %w", err)
+		}
+	}
+	discoveryService := NewDiscoveryService(opts.Logger, opts.Transport, server, book, opts.Bootnodes, opts.TargetOutboundPeers)
 
-	// 7. 返回完全组装好的 Node
-	return &Node{
+	n := &Node{
 		logger:           opts.Logger,
 		chainService:     chainService,
 		server:           server,
 		consensusEngine:  consensusEngine,
 		broadcastService: broadcastService,
+		discoveryService: discoveryService,
 		transport:        opts.Transport,
 		apiServer:        opts.APIServer,
-	}, nil
+		blockChain:       opts.BlockChain,
+	}
+	n.ctx, n.cancel = context.WithCancel(context.Background())
+
+	// 6. 由 Node 把消息分发给各个服务
+	server.RPCProcessor = n
+
+	// 7. 返回完全组装好的 Node
+	return n, nil
 }
 
-func (n *Node) Start() {
+// ProcessMessage 实现了 network.RPCProcessor，节点发现消息交给 DiscoveryService，其余交给 ChainService
+func (n *Node) ProcessMessage(msg *network.DecodedMessage) error {
+	switch msg.Data.(type) {
+	case *network.GetPeersMessage, *network.PeersMessage:
+		return n.discoveryService.ProcessMessage(msg)
+	default:
+		err := n.chainService.ProcessMessage(msg)
+		if errors.Is(err, errBlockAhead) {
+			// 区块接不到链上，说明本节点错过了一些区块，从发送方补齐
+			n.goSync(msg.From)
+		}
+		return err
+	}
+}
+
+// Start 启动节点的全部服务并阻塞，直到 ctx 被取消或 Stop 被调用。
+// ctx 被取消时节点不会自动释放资源，调用方仍需调用 Stop
+func (n *Node) Start(ctx context.Context) {
 	n.logger.Log("msg", "starting node...")
+	stop := context.AfterFunc(ctx, n.cancel)
+	defer stop()
 
-	go n.listenForPeers()
-	go n.broadcastService.Start()
+	n.goService(n.listenForPeers)
+	n.goService(n.broadcastService.Start)
+	n.goService(n.discoveryService.Start)
 	// 启动共识引擎（如果它是验证者）
 	if n.consensusEngine.IsValidator() {
-		go n.consensusEngine.Start()
+		n.goService(n.consensusEngine.Start)
 	}
 
 	if n.apiServer != nil {
-		go n.apiServer.Run()
+		n.goService(func(ctx context.Context) {
+			if err := n.apiServer.Run(ctx); err != nil {
+				n.logger.Log("msg", "API server stopped", "err", err)
+			}
+		})
 	}
 
 	// 启动网络服务器
-	n.server.Start()
+	n.wg.Add(1)
+	defer n.wg.Done()
+	n.server.Start(n.ctx)
+}
+
+// goService 在新协程中运行 fn，Stop 会等待它返回
+func (n *Node) goService(fn func(context.Context)) {
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		fn(n.ctx)
+	}()
 }
 
-// listenForPeers 监听来自 Transport 层的 Peer 事件
-func (n *Node) listenForPeers() {
+// Stop 停止全部服务：等待正在处理的消息、出块和同步完成，发出已排队的广播并保存地址簿，
+// 然后断开全部节点、关闭 API 服务器和数据库。可以重复调用
+func (n *Node) Stop() error {
+	n.stopOnce.Do(func() {
+		n.logger.Log("msg", "stopping node...")
+		n.cancel()
+		n.wg.Wait()
+
+		n.stopErr = errors.Join(n.transport.Close(), n.blockChain.Close())
+		n.logger.Log("msg", "node stopped", "err", n.stopErr)
+	})
+	return n.stopErr
+}
+
+// listenForPeers 监听来自 Transport 层的 Peer 事件，直到 ctx 被取消
+func (n *Node) listenForPeers(ctx context.Context) {
 	peerEvents := n.transport.PeerEvents()
-	for peer := range peerEvents {
-		n.logger.Log("msg", "new peer connected", "addr", peer.RemoteAddr())
+	for {
+		var peer network.Peer
+		select {
+		case peer = <-peerEvents:
+		case <-ctx.Done():
+			return
+		}
+		info := peer.Info()
+		n.logger.Log("msg", "new peer connected", "id", peer.ID(), "addr", peer.RemoteAddr(),
+			"height", info.BestHeight, "best", info.BestHash)
 		// 为每个新 Peer 启动一个独立的 goroutine 来处理状态检查
-		go n.processNewPeer(peer)
+		n.goSync(peer.ID())
+		n.background.Add(1)
+		go func(p network.Peer) {
+			defer n.background.Add(-1)
+			if err := n.discoveryService.OnPeer(p); err != nil {
+				n.logger.Log("msg", "failed to request peers", "to", p.ID(), "err", err)
+			}
+		}(peer)
 	}
 }
 
-// processNewPeer 向新连接的 Peer 发送状态请求
-func (n *Node) processNewPeer(peer network.Peer) error {
-	// 1. 构造一个 GetStatusMessage
-	getStatusMsg := new(network.GetStatusMessage) // 当前是空结构，未来可扩展
-
-	// 2. 编码成二进制
-	buf := new(bytes.Buffer)
-	if err := n.server.Encoder.Encode(buf, getStatusMsg); err != nil {
-		return err
-	}
+// goSync 在新协程中与节点 id 同步
+func (n *Node) goSync(id network.NetAddr) {
+	n.background.Add(1)
+	n.goService(func(context.Context) {
+		defer n.background.Add(-1)
+		n.syncWith(id)
+	})
+}
 
-	// 3. 包装成顶层 Message
-	msg := network.NewMessage(network.MessageTypeGetStatus, buf.Bytes())
+// syncWith 向节点请求状态，必要时从它同步区块
+func (n *Node) syncWith(id network.NetAddr) {
+	n.logger.Log("msg", "requesting status from peer", "to", id)
 
-	// 4. 再次编码（因为网络上传输的是顶层 Message）
-	finalBuf := new(bytes.Buffer)
-	if err := n.server.Encoder.Encode(finalBuf, msg); err != nil {
-		return err
+	if err := n.chainService.SyncWith(id); err != nil {
+		n.logger.Log("msg", "sync failed", "with", id, "err", err)
 	}
-
-	n.logger.Log("msg", "requesting status from new peer", "to", peer.RemoteAddr())
-
-	// 5. 直接通过 Peer 发送
-	return peer.Send(finalBuf.Bytes())
 }
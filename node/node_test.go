@@ -0,0 +1,79 @@
+package node
+
+import (
+	"context"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/virtue186/xchain/core"
+	"github.com/virtue186/xchain/crypto"
+	"github.com/virtue186/xchain/network"
+	"testing"
+	"time"
+)
+
+// newTestNode 在 net 中启动一个使用内存存储的节点，测试结束时停止它
+func newTestNode(t *testing.T, net *network.LocalNetwork, addr string, key *crypto.PrivateKey, bootnodes ...string) (*Node, *core.BlockChain) {
+	return startTestNode(t, NodeOpts{
+		Transport:  net.NewTransport(network.NetAddr(addr)),
+		PrivateKey: key,
+		BlockTime:  20 * time.Millisecond,
+		Bootnodes:  bootnodes,
+	})
+}
+
+// startTestNode 为 opts 补上内存中的区块链和交易池后启动节点，测试结束时停止它
+func startTestNode(t *testing.T, opts NodeOpts) (*Node, *core.BlockChain) {
+	genesis, err := core.NewBlock(&core.Header{Version: 1}, nil)
+	assert.Nil(t, err)
+	bc, err := core.NewBlockChain(log.NewNopLogger(), core.NewMemoryStorage(), genesis)
+	assert.Nil(t, err)
+
+	opts.Logger = log.NewNopLogger()
+	opts.BlockChain = bc
+	opts.TxPool = network.NewTxPool(100)
+	n, err := NewNode(opts)
+	assert.Nil(t, err)
+	go n.Start(context.Background())
+	t.Cleanup(func() { assert.Nil(t, n.Stop()) })
+	return n, bc
+}
+
+func TestNodesSyncOverLocalNetwork(t *testing.T) {
+	net := network.NewLocalNetwork()
+	key := crypto.GeneratePrivateKey()
+	_, validator := newTestNode(t, net, "validator", &key)
+	assert.Eventually(t, func() bool { return validator.Height() >= 3 }, 2*time.Second, 10*time.Millisecond)
+
+	// 后加入的节点先同步已有的区块，再接收新广播的区块
+	_, a := newTestNode(t, net, "a", nil, "validator")
+	_, b := newTestNode(t, net, "b", nil, "validator")
+	target := validator.Height() + 2
+	for _, bc := range []*core.BlockChain{a, b} {
+		assert.Eventually(t, func() bool { return bc.Height() >= target }, 3*time.Second, 10*time.Millisecond)
+		want, err := validator.GetHeader(target)
+		assert.Nil(t, err)
+		got, err := bc.GetHeader(target)
+		assert.Nil(t, err)
+		assert.Equal(t, core.BlockHasher{}.Hash(want), core.BlockHasher{}.Hash(got))
+	}
+}
+
+func TestNodeBansPenalizedPeer(t *testing.T) {
+	net := network.NewLocalNetwork()
+	validator, _ := startTestNode(t, NodeOpts{
+		Transport:    net.NewTransport("validator"),
+		BlockTime:    time.Hour,
+		BanThreshold: -network.PenaltyInvalidTx,
+		BanDuration:  time.Minute,
+	})
+	newTestNode(t, net, "a", nil, "validator")
+	assert.Eventually(t, func() bool { return len(validator.server.Peers()) == 1 }, time.Second, 10*time.Millisecond)
+
+	// 一次扣分就达到节点设置的阈值，对方被封禁并断开
+	validator.server.Penalize("a", network.PenaltyInvalidTx, "invalid transaction")
+	assert.True(t, validator.server.BanList.IsNodeBanned("a"))
+	bans := validator.server.BanList.List()
+	assert.Equal(t, 1, len(bans))
+	assert.WithinDuration(t, time.Now().Add(time.Minute), bans[0].Until, 5*time.Second)
+	assert.Empty(t, validator.server.Peers())
+}
@@ -0,0 +1,118 @@
+package node
+
+import (
+	"github.com/virtue186/xchain/core"
+	"github.com/virtue186/xchain/network"
+	"github.com/virtue186/xchain/types"
+	"sync"
+)
+
+// defaultMaxOrphans 是孤块池最多保存的区块数
+const defaultMaxOrphans = 64
+
+// orphanBlock 是父区块还没有到达的区块
+type orphanBlock struct {
+	block *core.Block
+	hash  types.Hash
+	from  network.NetAddr
+}
+
+// orphanPool 按父区块哈希保存先于父区块到达的区块，父区块加到链上后取出它们接到链上。
+// 池满时淘汰最早加入的区块
+type orphanPool struct {
+	lock     sync.Mutex
+	max      int
+	byHash   map[types.Hash]*orphanBlock
+	byParent map[types.Hash][]*orphanBlock
+	order    []types.Hash // 按加入顺序排列，用于淘汰
+}
+
+func newOrphanPool(max int) *orphanPool {
+	if max <= 0 {
+		max = defaultMaxOrphans
+	}
+	return &orphanPool{
+		max:      max,
+		byHash:   make(map[types.Hash]*orphanBlock),
+		byParent: make(map[types.Hash][]*orphanBlock),
+	}
+}
+
+// add 保存孤块，已经在池中时返回 false
+func (p *orphanPool) add(block *core.Block, from network.NetAddr) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	hash := block.Hash(core.BlockHasher{})
+	if _, ok := p.byHash[hash]; ok {
+		return false
+	}
+	for len(p.byHash) >= p.max && len(p.order) > 0 {
+		p.remove(p.order[0])
+	}
+	o := &orphanBlock{block: block, hash: hash, from: from}
+	p.byHash[hash] = o
+	p.byParent[block.PrevBlockHash] = append(p.byParent[block.PrevBlockHash], o)
+	p.order = append(p.order, hash)
+	return true
+}
+
+// take 取出并删除父区块为 parent 的全部孤块
+func (p *orphanPool) take(parent types.Hash) []*orphanBlock {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	children := p.byParent[parent]
+	for _, o := range children {
+		p.remove(o.hash)
+	}
+	return children
+}
+
+// prune 删除高度不超过 height 的孤块，它们已经不可能接到链上
+func (p *orphanPool) prune(height uint32) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for hash, o := range p.byHash {
+		if o.block.Height <= height {
+			p.remove(hash)
+		}
+	}
+}
+
+func (p *orphanPool) len() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return len(p.byHash)
+}
+
+// remove 删除一个孤块，调用方需持有锁
+func (p *orphanPool) remove(hash types.Hash) {
+	o, ok := p.byHash[hash]
+	if !ok {
+		return
+	}
+	delete(p.byHash, hash)
+
+	parent := o.block.PrevBlockHash
+	siblings := p.byParent[parent]
+	for i, s := range siblings {
+		if s == o {
+			siblings = append(siblings[:i], siblings[i+1:]...)
+			break
+		}
+	}
+	if len(siblings) == 0 {
+		delete(p.byParent, parent)
+	} else {
+		p.byParent[parent] = siblings
+	}
+
+	for i, h := range p.order {
+		if h == hash {
+			p.order = append(p.order[:i], p.order[i+1:]...)
+			break
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package node
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/virtue186/xchain/core"
+	"github.com/virtue186/xchain/types"
+	"testing"
+)
+
+func newOrphan(height uint32, parent types.Hash) *core.Block {
+	b, _ := core.NewBlock(&core.Header{Version: 1, Height: height, PrevBlockHash: parent}, nil)
+	return b
+}
+
+func TestOrphanPool(t *testing.T) {
+	p := newOrphanPool(3)
+	var root types.Hash
+	root[0] = 1
+	a := newOrphan(5, root)
+	b := newOrphan(6, a.Hash(core.BlockHasher{}))
+	c := newOrphan(5, root)
+	c.Nonce = 1
+
+	assert.True(t, p.add(a, "x"))
+	assert.False(t, p.add(a, "y"))
+	assert.True(t, p.add(b, "x"))
+	assert.True(t, p.add(c, "x"))
+	assert.Equal(t, 3, p.len())
+
+	// 同一个父区块下的孤块一起取出
+	children := p.take(root)
+	assert.Equal(t, 2, len(children))
+	assert.Equal(t, 1, p.len())
+	assert.Empty(t, p.take(root))
+
+	// 池满时淘汰最早加入的孤块
+	d := newOrphan(7, b.Hash(core.BlockHasher{}))
+	e := newOrphan(8, d.Hash(core.BlockHasher{}))
+	f := newOrphan(9, e.Hash(core.BlockHasher{}))
+	p.add(d, "x")
+	p.add(e, "x")
+	p.add(f, "x")
+	assert.Equal(t, 3, p.len())
+	assert.Empty(t, p.take(a.Hash(core.BlockHasher{})))
+
+	p.prune(8)
+	assert.Equal(t, 1, p.len())
+	assert.Equal(t, []*orphanBlock{{block: f, hash: f.Hash(core.BlockHasher{}), from: "x"}}, p.take(e.Hash(core.BlockHasher{})))
+}
@@ -0,0 +1,279 @@
+package node
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/virtue186/xchain/core"
+	"github.com/virtue186/xchain/crypto"
+	"github.com/virtue186/xchain/network"
+	"github.com/virtue186/xchain/types"
+	"testing"
+	"time"
+)
+
+const (
+	// scenarioStep 是每次推进虚拟时钟的步长，每一步之后等待节点处理完收到的消息
+	scenarioStep = 10 * time.Millisecond
+	// settleTimeout 是每一步等待节点处理消息的最长真实时间
+	settleTimeout = 5 * time.Second
+)
+
+// scenario 在模拟网络中运行一组节点，出块和时钟都由测试控制
+type scenario struct {
+	t     *testing.T
+	sim   *network.Simulator
+	nodes map[string]*scenarioNode
+	names []string
+}
+
+type scenarioNode struct {
+	node      *Node
+	bc        *core.BlockChain
+	transport *network.LocalTransport
+}
+
+// idle 判断节点是否处理完了投递给它的消息和节点事件，通告完了排队的区块和交易，
+// 并且后台协程都在等待其他节点的响应
+func (n *scenarioNode) idle() bool {
+	return n.node.server.Handled() == n.transport.Delivered() &&
+		len(n.transport.PeerEvents()) == 0 &&
+		n.node.broadcastService.idle() &&
+		n.node.background.Load() == n.node.server.Waiting()
+}
+
+func newScenario(t *testing.T, seed int64, link network.LinkConfig) *scenario {
+	return &scenario{t: t, sim: network.NewSimulator(seed, link), nodes: make(map[string]*scenarioNode)}
+}
+
+// addNode 启动一个节点，验证者不会自动出块，需要调用 mine
+func (s *scenario) addNode(name string, validator bool, bootnodes ...string) *scenarioNode {
+	tr := s.sim.NewTransport(network.NetAddr(name))
+	opts := NodeOpts{
+		Transport:      tr,
+		BlockTime:      time.Hour,
+		Bootnodes:      bootnodes,
+		RequestTimeout: 500 * time.Millisecond,
+		Clock:          s.sim,
+	}
+	if validator {
+		key := crypto.GeneratePrivateKey()
+		opts.PrivateKey = &key
+	}
+	n, bc := startTestNode(s.t, opts)
+	sn := &scenarioNode{node: n, bc: bc, transport: tr}
+	s.nodes[name] = sn
+	s.names = append(s.names, name)
+	// 节点在后台启动，等它连上引导节点，之后的虚拟时间才有意义
+	assert.Eventually(s.t, func() bool { return sn.connected(bootnodes...) }, settleTimeout, time.Millisecond)
+	return sn
+}
+
+// connected 判断节点是否已经连上 ids 中的全部节点
+func (n *scenarioNode) connected(ids ...string) bool {
+	peers := make(map[network.NetAddr]bool)
+	for _, p := range n.transport.Peers() {
+		peers[p.ID()] = true
+	}
+	for _, id := range ids {
+		if !peers[network.NetAddr(id)] {
+			return false
+		}
+	}
+	return true
+}
+
+// run 把虚拟时钟推进 d
+func (s *scenario) run(d time.Duration) {
+	for elapsed := time.Duration(0); elapsed < d; elapsed += scenarioStep {
+		s.sim.Advance(scenarioStep)
+		s.settle()
+	}
+}
+
+// settle 等待全部节点处理完已经投递的消息和到期的定时器，处理中发出的消息要等虚拟时钟推进后才会投递
+func (s *scenario) settle() {
+	deadline := time.Now().Add(settleTimeout)
+	for !s.idle() {
+		if time.Now().After(deadline) {
+			s.t.Fatalf("nodes did not settle within %s", settleTimeout)
+		}
+		time.Sleep(10 * time.Microsecond)
+	}
+}
+
+func (s *scenario) idle() bool {
+	if !s.sim.Drained() {
+		return false
+	}
+	for _, name := range s.names {
+		if !s.nodes[name].idle() {
+			return false
+		}
+	}
+	return true
+}
+
+// mine 让验证者创建一个区块，并等待它被通告出去
+func (s *scenario) mine(name string) {
+	bs := s.nodes[name].node.broadcastService
+	before := bs.announced.Load()
+	assert.Nil(s.t, s.nodes[name].node.consensusEngine.createNewBlock())
+	assert.Eventually(s.t, func() bool { return bs.announced.Load() > before }, settleTimeout, 10*time.Microsecond)
+}
+
+// converged 判断全部节点的最新区块是否相同
+func (s *scenario) converged() bool {
+	var want types.Hash
+	for i, name := range s.names {
+		h := core.BlockHasher{}.Hash(s.nodes[name].bc.CurrentHeader())
+		if i > 0 && h != want {
+			return false
+		}
+		want = h
+	}
+	return true
+}
+
+// assertConverged 在 d 的虚拟时间内等待全部节点的最新区块相同
+func (s *scenario) assertConverged(d time.Duration) {
+	for elapsed := time.Duration(0); elapsed < d; elapsed += scenarioStep {
+		if s.converged() {
+			return
+		}
+		s.run(scenarioStep)
+	}
+	for _, name := range s.names {
+		h := s.nodes[name].bc.CurrentHeader()
+		s.t.Logf("%s: height %d head %s", name, h.Height, core.BlockHasher{}.Hash(h))
+	}
+	s.t.Fatalf("nodes did not converge within %s", d)
+}
+
+func TestScenarioLossyLinks(t *testing.T) {
+	s := newScenario(t, 1, network.LinkConfig{
+		Delay:     40 * time.Millisecond,
+		Jitter:    20 * time.Millisecond,
+		DropRate:  0.1,
+		Bandwidth: 64 * 1024,
+	})
+	s.addNode("validator", true)
+	s.addNode("a", false, "validator")
+	s.addNode("b", false, "validator")
+	s.addNode("c", false, "a")
+	s.run(time.Second)
+
+	for i := 0; i < 10; i++ {
+		s.mine("validator")
+		s.run(100 * time.Millisecond)
+	}
+	// 丢失的区块在收到后续区块时补齐，最后一个区块不能丢失
+	for _, a := range s.names {
+		for _, b := range s.names {
+			if a != b {
+				s.sim.SetLink(network.NetAddr(a), network.NetAddr(b), network.LinkConfig{Delay: 40 * time.Millisecond})
+			}
+		}
+	}
+	s.mine("validator")
+	s.assertConverged(10 * time.Second)
+	assert.Equal(t, uint32(11), s.nodes["c"].bc.Height())
+}
+
+func TestScenarioPartition(t *testing.T) {
+	s := newScenario(t, 2, network.LinkConfig{Delay: 20 * time.Millisecond, Jitter: 10 * time.Millisecond})
+	s.addNode("validator", true)
+	s.addNode("a", false, "validator")
+	s.addNode("b", false, "validator")
+	s.addNode("c", false, "validator")
+	s.run(time.Second)
+	s.mine("validator")
+	s.assertConverged(time.Second)
+
+	// 分区期间 b、c 收不到新区块
+	s.sim.Partition([]network.NetAddr{"validator", "a"}, []network.NetAddr{"b", "c"})
+	for i := 0; i < 5; i++ {
+		s.mine("validator")
+		s.run(100 * time.Millisecond)
+	}
+	assert.Equal(t, uint32(6), s.nodes["a"].bc.Height())
+	assert.Equal(t, uint32(1), s.nodes["b"].bc.Height())
+	assert.Equal(t, uint32(1), s.nodes["c"].bc.Height())
+
+	// 恢复后的第一个区块让 b、c 发现落后并补齐
+	s.sim.Heal()
+	s.mine("validator")
+	s.assertConverged(5 * time.Second)
+	assert.Equal(t, uint32(7), s.nodes["b"].bc.Height())
+}
+
+func TestScenarioMultiHopRelay(t *testing.T) {
+	s := newScenario(t, 3, network.LinkConfig{Delay: 20 * time.Millisecond})
+	s.addNode("validator", true)
+	s.addNode("a", false, "validator")
+	s.addNode("b", false, "a")
+	s.addNode("c", false, "b")
+	// 节点发现会连上其他节点，丢弃不相邻节点之间的全部消息，只留下 validator - a - b - c 一条链路
+	names := []network.NetAddr{"validator", "a", "b", "c"}
+	for i := range names {
+		for j := i + 2; j < len(names); j++ {
+			s.sim.SetLink(names[i], names[j], network.LinkConfig{DropRate: 1})
+		}
+	}
+	s.run(time.Second)
+
+	s.mine("validator")
+	s.assertConverged(time.Second)
+	assert.Equal(t, uint32(1), s.nodes["c"].bc.Height())
+
+	// c 收到的交易逐跳转发到验证者
+	key := crypto.GeneratePrivateKey()
+	tx := core.NewTransaction([]byte("relay"))
+	assert.Nil(t, tx.Sign(key))
+	c := s.nodes["c"].node
+	assert.Nil(t, c.ProcessMessage(&network.DecodedMessage{From: "client", Data: tx}))
+	s.run(200 * time.Millisecond)
+	for _, name := range s.names {
+		assert.True(t, s.nodes[name].node.chainService.txPool.Contains(tx.Hash(core.TxHasher{})), name)
+	}
+}
+
+// newScenarioTx 返回一笔由新账户签名的零金额转账，可以被打包到区块中
+func newScenarioTx(t *testing.T) *core.Transaction {
+	key := crypto.GeneratePrivateKey()
+	tx := core.NewTransaction(nil)
+	tx.To = crypto.GeneratePrivateKey().PublicKey().Address()
+	assert.Nil(t, tx.Sign(key))
+	return tx
+}
+
+func TestScenarioCompactBlocks(t *testing.T) {
+	s := newScenario(t, 4, network.LinkConfig{Delay: 20 * time.Millisecond})
+	s.addNode("validator", true)
+	s.addNode("a", false, "validator")
+	s.addNode("b", false, "a")
+	s.run(time.Second)
+
+	// 三笔交易通过广播到达所有节点，第四笔只在验证者的交易池中
+	var gossiped []*core.Transaction
+	for i := 0; i < 3; i++ {
+		tx := newScenarioTx(t)
+		gossiped = append(gossiped, tx)
+		assert.Nil(t, s.nodes["a"].node.ProcessMessage(&network.DecodedMessage{From: "client", Data: tx}))
+	}
+	s.run(200 * time.Millisecond)
+	for _, name := range s.names {
+		for _, tx := range gossiped {
+			assert.True(t, s.nodes[name].node.chainService.txPool.Contains(tx.Hash(core.TxHasher{})), name)
+		}
+	}
+	private := newScenarioTx(t)
+	s.nodes["validator"].node.chainService.txPool.Add(private)
+
+	s.mine("validator")
+	s.assertConverged(2 * time.Second)
+	for _, name := range s.names {
+		block, err := s.nodes[name].bc.GetBlockByHash(core.BlockHasher{}.Hash(s.nodes[name].bc.CurrentHeader()))
+		assert.Nil(t, err)
+		assert.Equal(t, 4, len(block.Transactions), name)
+		assert.Equal(t, 0, s.nodes[name].node.chainService.txPool.PendingCount(), name)
+	}
+}
@@ -51,3 +51,12 @@ func AddressFromHex(s string) (Address, error) {
 	// 4. 使用已有的 AddressFromBytes 进行转换
 	return AddressFromBytes(b), nil
 }
+
+func (addr Address) IsZero() bool {
+	for i := 0; i < 20; i++ {
+		if addr[i] != 0 {
+			return false
+		}
+	}
+	return true
+}